@@ -0,0 +1,128 @@
+package cache
+
+import (
+	"errors"
+	"math"
+)
+
+// DefaultSlabSize is the size of the slabs allocated by WithArena when no size is given.
+const DefaultSlabSize = 1 << 20
+
+var ErrInvalidSlabSize = errors.New("slab size must not be negative") // ErrInvalidSlabSize is returned by WithArena for a negative slab size.
+
+// arenaSlot locates a value stored in an arena.
+type arenaSlot struct {
+	Slab   uint32
+	Offset uint32
+	Length uint32
+}
+
+// arena keeps values in large contiguous slabs instead of individual allocations, so
+// a cache holding millions of values has a few large objects for the garbage
+// collector to track rather than millions of small ones.
+//
+// Slabs are filled front to back and bytes are never overwritten, so slices returned
+// by Bytes stay valid after the value is released. A slab is dropped once every
+// value in it is released, and Compact moves the values of partly used slabs into
+// new ones. All methods must be called with the store lock held, exclusively for
+// those that modify the arena.
+type arena struct {
+	SlabSize int
+	Slabs    [][]byte
+	Live     []int    // Live is the number of bytes still in use in each slab.
+	Free     []uint32 // Free lists the slots of dropped slabs for reuse.
+	Current  int      // Current is the slab being filled, or -1 if there is none.
+}
+
+// newArena creates an empty arena allocating slabs of slabSize bytes.
+func newArena(slabSize int) *arena {
+	return &arena{SlabSize: slabSize, Current: -1}
+}
+
+// Fits reports whether value can be stored in the arena. Empty values need no
+// storage and values beyond the range of a slot stay on the heap.
+func (a *arena) Fits(value []byte) bool {
+	return len(value) > 0 && len(value) <= math.MaxUint32
+}
+
+// Alloc copies value into the arena and returns its slot. A value larger than a slab
+// gets a slab of its own, which is dropped when it is released.
+func (a *arena) Alloc(value []byte) arenaSlot {
+	if len(value) > a.SlabSize {
+		idx := a.newSlab(len(value))
+		a.Slabs[idx] = append(a.Slabs[idx], value...)
+		a.Live[idx] = len(value)
+
+		return arenaSlot{Slab: idx, Length: uint32(len(value))}
+	}
+
+	if a.Current < 0 || cap(a.Slabs[a.Current])-len(a.Slabs[a.Current]) < len(value) {
+		prev := a.Current
+		a.Current = int(a.newSlab(a.SlabSize))
+
+		// The previous slab is no longer filled, so it can go as soon as it is empty.
+		if prev >= 0 && a.Live[prev] == 0 {
+			a.drop(uint32(prev))
+		}
+	}
+
+	idx := a.Current
+	offset := len(a.Slabs[idx])
+
+	a.Slabs[idx] = append(a.Slabs[idx], value...)
+	a.Live[idx] += len(value)
+
+	return arenaSlot{Slab: uint32(idx), Offset: uint32(offset), Length: uint32(len(value))}
+}
+
+// Bytes returns the value stored in slot. The slice shares the slab, so it must not be modified.
+func (a *arena) Bytes(slot arenaSlot) []byte {
+	end := slot.Offset + slot.Length
+
+	return a.Slabs[slot.Slab][slot.Offset:end:end]
+}
+
+// Release marks the value in slot as no longer used, dropping its slab once the
+// slab is empty and no longer being filled.
+func (a *arena) Release(slot arenaSlot) {
+	a.Live[slot.Slab] -= int(slot.Length)
+
+	if a.Live[slot.Slab] == 0 && int(slot.Slab) != a.Current {
+		a.drop(slot.Slab)
+	}
+}
+
+// newSlab allocates an empty slab with room for size bytes and returns its index.
+func (a *arena) newSlab(size int) uint32 {
+	slab := make([]byte, 0, size)
+
+	if n := len(a.Free); n > 0 {
+		idx := a.Free[n-1]
+		a.Free = a.Free[:n-1]
+		a.Slabs[idx] = slab
+
+		return idx
+	}
+
+	a.Slabs = append(a.Slabs, slab)
+	a.Live = append(a.Live, 0)
+
+	return uint32(len(a.Slabs) - 1)
+}
+
+// drop releases an empty slab to the garbage collector.
+func (a *arena) drop(idx uint32) {
+	a.Slabs[idx] = nil
+	a.Free = append(a.Free, idx)
+}
+
+// Size returns the number of bytes held by the slabs, including unused space.
+func (a *arena) Size() int {
+	size := 0
+
+	for _, slab := range a.Slabs {
+		size += cap(slab)
+	}
+
+	return size
+}
@@ -0,0 +1,214 @@
+package cache
+
+import (
+	"bytes"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+func TestArena(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Alloc", func(t *testing.T) {
+		t.Parallel()
+
+		a := newArena(16)
+
+		first := a.Alloc([]byte("0123456789"))
+		second := a.Alloc([]byte("abcdef"))
+
+		if first.Slab != second.Slab {
+			t.Fatalf("expected values that fit to share a slab")
+		}
+
+		// Does not fit in the rest of the slab.
+		third := a.Alloc([]byte("ABC"))
+		if third.Slab == first.Slab {
+			t.Fatalf("expected a new slab once the current one is full")
+		}
+
+		for slot, want := range map[arenaSlot]string{first: "0123456789", second: "abcdef", third: "ABC"} {
+			if got := a.Bytes(slot); string(got) != want {
+				t.Errorf("expected %q, got %q", want, got)
+			}
+		}
+	})
+
+	t.Run("Release", func(t *testing.T) {
+		t.Parallel()
+
+		a := newArena(8)
+
+		first := a.Alloc([]byte("1234"))
+		second := a.Alloc([]byte("5678"))
+		kept := a.Bytes(first)
+
+		// Filling a new slab retires the first one, which is dropped once empty.
+		a.Alloc([]byte("next"))
+
+		a.Release(first)
+
+		if a.Slabs[first.Slab] == nil {
+			t.Fatalf("expected a slab with live values to be kept")
+		}
+
+		a.Release(second)
+
+		if a.Slabs[first.Slab] != nil {
+			t.Fatalf("expected an empty slab to be dropped")
+		}
+
+		if string(kept) != "1234" {
+			t.Fatalf("expected a released value to stay readable, got %q", kept)
+		}
+
+		// The dropped slot is reused for the next slab.
+		if slot := a.Alloc([]byte("large value")); slot.Slab != first.Slab {
+			t.Fatalf("expected slab %d to be reused, got %d", first.Slab, slot.Slab)
+		}
+	})
+
+	t.Run("Large", func(t *testing.T) {
+		t.Parallel()
+
+		a := newArena(8)
+
+		want := bytes.Repeat([]byte("Value"), 10)
+
+		slot := a.Alloc(want)
+		if got := a.Bytes(slot); !bytes.Equal(got, want) {
+			t.Fatalf("expected %q, got %q", want, got)
+		}
+
+		a.Release(slot)
+
+		if a.Slabs[slot.Slab] != nil {
+			t.Fatalf("expected the dedicated slab to be dropped")
+		}
+	})
+}
+
+func TestCacheArena(t *testing.T) {
+	t.Parallel()
+
+	db, err := OpenRawMem(WithArena(64))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	value := func(i int) []byte {
+		return []byte("Value" + strconv.Itoa(i))
+	}
+
+	for i := range 100 {
+		if err := db.Set([]byte(strconv.Itoa(i)), value(i), 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// Overwrite and delete some values so slabs are left partly used.
+	for i := range 100 {
+		key := []byte(strconv.Itoa(i))
+
+		switch i % 3 {
+		case 0:
+			if err := db.Delete(key); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		case 1:
+			if err := db.Set(key, value(i*10), 0); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+	}
+
+	check := func() {
+		t.Helper()
+
+		var cost uint64
+
+		for i := range 100 {
+			key := []byte(strconv.Itoa(i))
+
+			var got []byte
+
+			_, err := db.Get(key, &got)
+
+			switch i % 3 {
+			case 0:
+				if err == nil {
+					t.Fatalf("expected %q to be deleted", key)
+				}
+
+				continue
+			case 1:
+				if err != nil || !bytes.Equal(got, value(i*10)) {
+					t.Fatalf("expected %q for %q, got %q, %v", value(i*10), key, got, err)
+				}
+			case 2:
+				if err != nil || !bytes.Equal(got, value(i)) {
+					t.Fatalf("expected %q for %q, got %q, %v", value(i), key, got, err)
+				}
+			}
+
+			cost += uint64(len(key) + len(got))
+		}
+
+		if db.Cost() != cost {
+			t.Fatalf("expected cost %d, got %d", cost, db.Cost())
+		}
+	}
+
+	check()
+
+	before := db.Store.Arena.Size()
+
+	if err := db.Compact(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if after := db.Store.Arena.Size(); after >= before {
+		t.Fatalf("expected Compact to shrink the arena from %d bytes, got %d", before, after)
+	}
+
+	check()
+}
+
+func BenchmarkStoreArenaGC(b *testing.B) {
+	for name, enabled := range map[string]bool{"Heap": false, "Arena": true} {
+		b.Run(name, func(b *testing.B) {
+			store := setupTestStore(b)
+
+			if enabled {
+				store.Arena = newArena(DefaultSlabSize)
+			}
+
+			for i := range 500_000 {
+				store.Set([]byte(strconv.Itoa(i)), []byte("Value"+strconv.Itoa(i)), 0)
+			}
+
+			var before, after runtime.MemStats
+
+			runtime.GC()
+			runtime.ReadMemStats(&before)
+
+			for b.Loop() {
+				runtime.GC()
+			}
+
+			runtime.ReadMemStats(&after)
+
+			b.ReportMetric(float64(after.PauseTotalNs-before.PauseTotalNs)/float64(after.NumGC-before.NumGC), "pause-ns/gc")
+			b.ReportMetric(float64(after.HeapObjects), "objects")
+
+			runtime.KeepAlive(store)
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package cache
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// blobStore keeps values too large to hold in memory as individual files in a directory.
+type blobStore struct {
+	Dir       string
+	Threshold uint64
+
+	mu      sync.Mutex
+	pins    int      // pins counts the snapshots still reading blob files.
+	pending []string // pending holds the files removed while the store was pinned.
+}
+
+// newBlobStore creates a blob store in dir, creating the directory if needed.
+func newBlobStore(dir string, threshold uint64) (*blobStore, error) {
+	if err := os.MkdirAll(dir, 0o777); err != nil {
+		return nil, err
+	}
+
+	return &blobStore{Dir: dir, Threshold: threshold}, nil
+}
+
+// ShouldSpill reports whether a value is large enough to be moved to disk.
+func (b *blobStore) ShouldSpill(value []byte) bool {
+	return uint64(len(value)) > b.Threshold
+}
+
+// Store writes value to a new blob file and returns the encoded reference to it.
+func (b *blobStore) Store(value []byte) ([]byte, error) {
+	file, err := b.Create()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := file.Write(value); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+
+		return nil, err
+	}
+
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+
+		return nil, err
+	}
+
+	return b.Ref(file.Name())
+}
+
+// Create opens a new blob file for the caller to fill. Once it is written and closed,
+// Ref turns its name into a reference.
+func (b *blobStore) Create() (*os.File, error) {
+	return os.CreateTemp(b.Dir, "blob-*")
+}
+
+// Ref returns the encoded reference to the blob file with the given name.
+func (b *blobStore) Ref(name string) ([]byte, error) {
+	var buf bytes.Buffer
+
+	e := newEncoder(&buf)
+
+	if err := e.EncodeBytes([]byte(filepath.Base(name))); err != nil {
+		return nil, err
+	}
+
+	if err := e.Flush(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// path decodes a reference into the path of its blob file.
+func (b *blobStore) path(ref []byte) (string, error) {
+	name, err := newDecoder(bytes.NewReader(ref)).DecodeBytes()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(b.Dir, filepath.Base(string(name))), nil
+}
+
+// Load reads the value a reference points to.
+func (b *blobStore) Load(ref []byte) ([]byte, error) {
+	path, err := b.path(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.ReadFile(path)
+}
+
+// Remove deletes the blob file a reference points to. While the store is pinned the
+// file is kept until the last Unpin, so snapshots in flight can still read it.
+func (b *blobStore) Remove(ref []byte) error {
+	path, err := b.path(ref)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	if b.pins > 0 {
+		b.pending = append(b.pending, path)
+		b.mu.Unlock()
+
+		return nil
+	}
+	b.mu.Unlock()
+
+	return os.Remove(path)
+}
+
+// Pin defers the removal of blob files until the matching Unpin.
+func (b *blobStore) Pin() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.pins++
+}
+
+// Unpin releases a Pin, deleting the files removed in the meantime once no pins are left.
+// Deletion is best effort since the entries are already gone from the store.
+func (b *blobStore) Unpin() {
+	b.mu.Lock()
+
+	b.pins--
+	if b.pins > 0 {
+		b.mu.Unlock()
+		return
+	}
+
+	pending := b.pending
+	b.pending = nil
+	b.mu.Unlock()
+
+	for _, path := range pending {
+		_ = os.Remove(path)
+	}
+}
@@ -0,0 +1,277 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func countBlobs(tb testing.TB, dir string) int {
+	tb.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		tb.Fatalf("unexpected error: %v", err)
+	}
+
+	return len(entries)
+}
+
+func TestStoreBlob(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Spill", func(t *testing.T) {
+		t.Parallel()
+
+		dir := filepath.Join(t.TempDir(), "blobs")
+
+		blobs, err := newBlobStore(dir, 16)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		store := setupTestStore(t)
+		store.Blobs = blobs
+
+		want := bytes.Repeat([]byte("Value"), 100)
+
+		if err := store.Set([]byte("Large"), want, 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if err := store.Set([]byte("Small"), []byte("Value"), 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if got := countBlobs(t, dir); got != 1 {
+			t.Fatalf("expected 1 blob, got %d", got)
+		}
+
+		v, _, _ := store.lookup([]byte("Large"))
+		if v == nil || !v.Blob {
+			t.Fatalf("expected value to be spilled")
+		}
+
+		got, _, ok := store.Get([]byte("Large"))
+		if !ok {
+			t.Fatalf("expected key to exist")
+		}
+
+		if !bytes.Equal(got, want) {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+
+		if !store.Delete([]byte("Large")) {
+			t.Fatalf("expected key to be deleted")
+		}
+
+		if got := countBlobs(t, dir); got != 0 {
+			t.Fatalf("expected 0 blobs, got %d", got)
+		}
+	})
+
+	t.Run("Overwrite", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+
+		blobs, err := newBlobStore(dir, 16)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		store := setupTestStore(t)
+		store.Blobs = blobs
+
+		if err := store.Set([]byte("Key"), bytes.Repeat([]byte("Value"), 100), 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if err := store.Set([]byte("Key"), []byte("Value"), 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if got := countBlobs(t, dir); got != 0 {
+			t.Fatalf("expected 0 blobs, got %d", got)
+		}
+	})
+
+	t.Run("Snapshot", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+
+		blobs, err := newBlobStore(dir, 16)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		want := setupTestStore(t)
+		want.Blobs = blobs
+
+		value := bytes.Repeat([]byte("Value"), 100)
+
+		if err := want.Set([]byte("Key"), value, 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		var buf bytes.Buffer
+		if err := want.Snapshot(&buf); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		got := setupTestStore(t)
+		if err := got.LoadSnapshot(bytes.NewReader(buf.Bytes())); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		gotVal, _, ok := got.Get([]byte("Key"))
+		if !ok {
+			t.Fatalf("expected key to exist")
+		}
+
+		if !bytes.Equal(gotVal, value) {
+			t.Fatalf("expected %v, got %v", value, gotVal)
+		}
+	})
+
+	for name, format := range map[string]SnapshotFormat{"Binary": FormatBinary, "Msgpack": FormatMsgpack} {
+		t.Run("Delete During Snapshot "+name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+
+			blobs, err := newBlobStore(dir, 16)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			want := setupTestStore(t)
+			want.Blobs = blobs
+
+			value := bytes.Repeat([]byte("Value"), 100)
+
+			if err := want.Set([]byte("Key"), value, 0); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			want.Lock.RLock()
+			view, err := want.view()
+			want.Lock.RUnlock()
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !view.Nodes[0].Blob {
+				t.Fatalf("expected the view to keep the blob reference")
+			}
+
+			// The file is kept while the snapshot is still being written.
+			if !want.Delete([]byte("Key")) {
+				t.Fatalf("expected key to be deleted")
+			}
+
+			if got := countBlobs(t, dir); got != 1 {
+				t.Fatalf("expected 1 blob, got %d", got)
+			}
+
+			var buf bytes.Buffer
+			if err := writeSnapshot(&buf, view, format, 0); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			view.release()
+
+			if got := countBlobs(t, dir); got != 0 {
+				t.Fatalf("expected 0 blobs, got %d", got)
+			}
+
+			got := setupTestStore(t)
+			if err := got.LoadSnapshot(bytes.NewReader(buf.Bytes())); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			gotVal, _, ok := got.Get([]byte("Key"))
+			if !ok {
+				t.Fatalf("expected key to exist")
+			}
+
+			if !bytes.Equal(gotVal, value) {
+				t.Fatalf("expected %v, got %v", value, gotVal)
+			}
+		})
+	}
+}
+
+func TestCacheOverflow(t *testing.T) {
+	t.Parallel()
+
+	dir := filepath.Join(t.TempDir(), "overflow")
+
+	db, err := OpenRawMem(WithOverflow(dir, 1000), WithSyncMaintenance())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	value := func(i int) []byte {
+		return bytes.Repeat([]byte{byte('A' + i)}, 200)
+	}
+
+	for i := range 10 {
+		if err := db.Set([]byte(strconv.Itoa(i)), value(i), 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if cost := db.Cost(); cost > 1000 {
+		t.Fatalf("expected cost within the threshold, got %d", cost)
+	}
+
+	spilled := countBlobs(t, dir)
+	if spilled == 0 {
+		t.Fatalf("expected values to be spilled")
+	}
+
+	// The oldest entries are the first the policy would remove.
+	if v, _, _ := db.Store.lookup([]byte("0")); v == nil || !v.Blob {
+		t.Fatalf("expected the oldest value to be spilled")
+	}
+
+	if v, _, _ := db.Store.lookup([]byte("9")); v == nil || v.Blob {
+		t.Fatalf("expected the newest value to stay in memory")
+	}
+
+	for i := range 10 {
+		var got []byte
+		if _, err := db.Get([]byte(strconv.Itoa(i)), &got); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if !bytes.Equal(got, value(i)) {
+			t.Fatalf("expected %q, got %q", value(i), got)
+		}
+	}
+
+	if err := db.Delete([]byte("0")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := countBlobs(t, dir); got != spilled-1 {
+		t.Fatalf("expected %d blobs, got %d", spilled-1, got)
+	}
+
+	_, err = OpenRawMem(WithBlobStore(t.TempDir(), 16), WithOverflow(dir, 1000))
+	if !errors.Is(err, ErrOverflowDir) {
+		t.Fatalf("expected error: %v, got %v", ErrOverflowDir, err)
+	}
+}
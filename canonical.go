@@ -0,0 +1,131 @@
+package cache
+
+import (
+	"bytes"
+	"reflect"
+	"slices"
+	"sync"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"github.com/vmihailenco/msgpack/v5/msgpcode"
+)
+
+// canonicalTypes caches whether the encoding of a key type needs canonicalizing.
+var canonicalTypes sync.Map
+
+// marshalKey encodes a key so that equal keys always produce the same bytes.
+// Go maps are encoded in random order, so keys that may contain maps have their
+// map entries sorted. Other keys are encoded as marshal encodes them.
+func marshalKey[T any](v T) ([]byte, error) {
+	data, err := marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	t := reflect.TypeFor[T]()
+
+	needed, ok := canonicalTypes.Load(t)
+	if !ok {
+		needed, _ = canonicalTypes.LoadOrStore(t, mayHaveMap(t, map[reflect.Type]bool{}))
+	}
+
+	if !needed.(bool) {
+		return data, nil
+	}
+
+	var buf bytes.Buffer
+	if err := canonicalize(msgpack.NewDecoder(bytes.NewReader(data)), &buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// mayHaveMap reports whether values of t may contain a map.
+func mayHaveMap(t reflect.Type, seen map[reflect.Type]bool) bool {
+	if seen[t] {
+		return false
+	}
+
+	seen[t] = true
+
+	switch t.Kind() {
+	case reflect.Map, reflect.Interface:
+		return true
+	case reflect.Pointer, reflect.Slice, reflect.Array:
+		return mayHaveMap(t.Elem(), seen)
+	case reflect.Struct:
+		for i := range t.NumField() {
+			if mayHaveMap(t.Field(i).Type, seen) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// canonicalize copies the next value from dec to buf, sorting the entries of every
+// map by their encoded keys.
+func canonicalize(dec *msgpack.Decoder, buf *bytes.Buffer) error {
+	code, err := dec.PeekCode()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case msgpcode.IsFixedMap(code) || code == msgpcode.Map16 || code == msgpcode.Map32:
+		n, err := dec.DecodeMapLen()
+		if err != nil {
+			return err
+		}
+
+		entries := make([][2]bytes.Buffer, n)
+		for i := range entries {
+			if err := canonicalize(dec, &entries[i][0]); err != nil {
+				return err
+			}
+
+			if err := canonicalize(dec, &entries[i][1]); err != nil {
+				return err
+			}
+		}
+
+		slices.SortFunc(entries, func(a, b [2]bytes.Buffer) int {
+			return bytes.Compare(a[0].Bytes(), b[0].Bytes())
+		})
+
+		if err := msgpack.NewEncoder(buf).EncodeMapLen(n); err != nil {
+			return err
+		}
+
+		for i := range entries {
+			buf.Write(entries[i][0].Bytes())
+			buf.Write(entries[i][1].Bytes())
+		}
+	case msgpcode.IsFixedArray(code) || code == msgpcode.Array16 || code == msgpcode.Array32:
+		n, err := dec.DecodeArrayLen()
+		if err != nil {
+			return err
+		}
+
+		if err := msgpack.NewEncoder(buf).EncodeArrayLen(n); err != nil {
+			return err
+		}
+
+		for range n {
+			if err := canonicalize(dec, buf); err != nil {
+				return err
+			}
+		}
+	default:
+		raw, err := dec.DecodeRaw()
+		if err != nil {
+			return err
+		}
+
+		buf.Write(raw)
+	}
+
+	return nil
+}
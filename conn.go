@@ -1,11 +1,17 @@
 package cache
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"math"
+	"math/rand/v2"
 	"os"
+	"path"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/rogpeppe/go-internal/lockedfile"
@@ -15,34 +21,108 @@ import (
 // The Core interface for cache
 type Cacher[K any, V any] interface {
 	Clear()
+	Drain() error
+	Reset() error
+	Compact() error
+	Freeze()
+	Unfreeze()
 	Close() error
+	CloseWithoutFlush() error
 	Cost() uint64
+	MaxCost() uint64
+	SetMaxCost(maxCost uint64) error
 	Delete(key K) error
+	Range(fn func(key K, value V, ttl time.Duration) bool) error
+	RangeContext(ctx context.Context, fn func(key K, value V, ttl time.Duration) bool) error
+	RangeLenient(fn func(key K, value V, ttl time.Duration) bool) error
+	Scan(cursor uint64, count int) ([]K, uint64, error)
+	ParallelRange(workers int, fn func(key K, value V) error) error
+	Expired() ([]KeyValue[K, V], error)
+	Snapshot() ([]Entry[K, V], error)
 	Error() error
+	ClearError() error
+	Healthy() error
 	Flush() error
+	Sync() error
 	Get(key K, value *V) (time.Duration, error)
 	GetValue(key K) (V, time.Duration, error)
+	GetAllowStale(key K) (V, time.Duration, bool, error)
+	HasMulti(keys []K) ([]bool, error)
+	GetMultiTTL(keys []K) ([]Entry[K, V], error)
+	GetBatch(keys []K) ([]Result[V], error)
+	TTL(key K) (time.Duration, error)
+	GetMeta(key K) (Meta, error)
+	Touch(key K, ttl time.Duration) error
+	ExpireMulti(keys []K, ttl time.Duration) (int, error)
 	Set(key K, value V, ttl time.Duration) error
+	SetIfNewer(key K, value V, version uint64, ttl time.Duration) (bool, error)
+	GetWithVersion(key K) (V, uint64, time.Duration, error)
+	SetIfVersion(key K, value V, expectedVersion uint64, ttl time.Duration) (bool, error)
+	SetTagged(key K, value V, ttl time.Duration, tags ...string) error
+	InvalidateTag(tag string) (int, error)
+	SetEntries(entries []Entry[K, V]) error
+	ReplaceAll(entries []Entry[K, V]) error
 	SetConfig(options ...Option) error
 	Memorize(key K, factoryFunc func() (V, error), ttl time.Duration) (V, error)
+	MemorizeX(key K, factoryFunc func() (V, error), ttl time.Duration) (V, bool, error)
+	Preload(keys []K, factory func(K) (V, error), ttl time.Duration, concurrency int) error
+	MemorizeTimeout(key K, factoryFunc func(ctx context.Context) (V, error), ttl, timeout time.Duration) (V, error)
 	UpdateInPlace(key K, processFunc func(V) (V, error), ttl time.Duration) error
+	IncrementWithTTL(key K, delta int64, ttl time.Duration) (int64, error)
+	Transaction(fn func(tx *Tx[K, V]) error) error
 }
 
 // cache represents a cache database with file-backed storage and in-memory operation.
 type cache struct {
-	File  io.WriteSeeker
-	Store store
-	Stop  chan struct{}
-	wg    sync.WaitGroup
-	err   error
+	File        io.WriteSeeker
+	Store       store
+	Stop        chan struct{}
+	OpenTimeout time.Duration
+
+	SyncOnSnapshot       bool
+	DeferInitialSnapshot bool
+
+	KeyNormalizer any
+	KeyFunc       any
+
+	Fallback     any
+	FallbackTTL  time.Duration
+	WriteThrough bool
+
+	SnapshotJitter time.Duration
+	jitter         func(max time.Duration) time.Duration
+
+	MinSnapshotInterval time.Duration
+	lastSnapshot        time.Time
+	snapshotWritten     atomic.Uint64
+	lastSnapshotSize    atomic.Uint64
+
+	CleanupBatch int
+
+	SnapshotFailureThreshold int
+	snapshotMu               sync.Mutex // snapshotMu guards snapshotFailures and snapshotErr.
+	snapshotFailures         int
+	snapshotErr              error
+
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+	closeErr  error
+	err       atomic.Pointer[error] // err is set by the background worker, so it is only accessed through Error and setErr.
 }
 
+// defaultSnapshotFailureThreshold is the number of consecutive failed background
+// snapshots tolerated before the cache records a fatal error.
+const defaultSnapshotFailureThreshold = 3
+
 // Option is a function type for configuring the cache.
 type Option func(*cache) error
 
 // open opens a file-backed cache database with the given options.
 func open(filename string, options ...Option) (*cache, error) {
-	ret := &cache{}
+	ret := &cache{
+		SnapshotFailureThreshold: defaultSnapshotFailureThreshold,
+		jitter:                   rand.N[time.Duration],
+	}
 	ret.Store.Init()
 
 	if err := ret.SetConfig(options...); err != nil {
@@ -53,7 +133,7 @@ func open(filename string, options ...Option) (*cache, error) {
 		return ret, nil
 	}
 
-	file, err := lockedfile.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0o666)
+	file, err := openLocked(filename, ret.OpenTimeout)
 	if err != nil {
 		return nil, err
 	}
@@ -65,12 +145,18 @@ func open(filename string, options ...Option) (*cache, error) {
 
 	if fileInfo.Size() == 0 {
 		ret.File = file
-		if err := ret.Flush(); err != nil {
+
+		// Without changes the background worker skips snapshots until the first write.
+		if ret.DeferInitialSnapshot {
+			ret.Store.Dirty.Store(false)
+		} else if err := ret.Flush(); err != nil {
 			return nil, err
 		}
 	} else {
+		// Duplicate keys are already reconciled, and the next snapshot rewrites the file without them.
+		// An unregistered custom policy is replaced by PolicyNone, which WithCustomPolicy can override.
 		err := ret.Store.LoadSnapshot(file)
-		if err != nil {
+		if err != nil && !errors.Is(err, ErrDuplicateKey) && !errors.Is(err, ErrUnknownPolicy) {
 			return nil, err
 		}
 
@@ -80,6 +166,45 @@ func open(filename string, options ...Option) (*cache, error) {
 	return ret, nil
 }
 
+var ErrLocked = errors.New("cache file is locked by another process") // ErrLocked is returned when the file lock cannot be acquired in time.
+
+// openLocked opens and locks the cache file, giving up with ErrLocked after timeout.
+// A zero timeout waits for the lock indefinitely.
+func openLocked(filename string, timeout time.Duration) (*lockedfile.File, error) {
+	if timeout == 0 {
+		return lockedfile.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0o666)
+	}
+
+	type result struct {
+		file *lockedfile.File
+		err  error
+	}
+
+	ch := make(chan result, 1)
+
+	go func() {
+		file, err := lockedfile.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0o666)
+		ch <- result{file, err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case r := <-ch:
+		return r.file, r.err
+	case <-timer.C:
+		// Release the lock once the abandoned attempt acquires it.
+		go func() {
+			if r := <-ch; r.err == nil {
+				r.file.Close()
+			}
+		}()
+
+		return nil, ErrLocked
+	}
+}
+
 // start begins the background worker for periodic tasks.
 func (c *cache) start() {
 	c.Stop = make(chan struct{})
@@ -89,309 +214,2060 @@ func (c *cache) start() {
 	go c.backgroundWorker()
 }
 
-// SetConfig applies configuration options to the cache.
+// SetConfig applies configuration options to the cache. If an option fails or the
+// resulting policy has no limit to enforce, the eviction policy and cost limits are
+// restored to what they were before the call.
 func (c *cache) SetConfig(options ...Option) error {
+	if c.closed() {
+		return ErrClosed
+	}
+
 	c.Store.Lock.Lock()
 	defer c.Store.Lock.Unlock()
 
+	prev := c.saveLimits()
+
 	for _, opt := range options {
 		if err := opt(c); err != nil {
-			return err
+			return errors.Join(err, c.restoreLimits(prev))
 		}
 	}
 
+	if missingMaxCost(c.Store.Policy.Type, c.Store.MaxCost, c.Store.Unlimited) {
+		return errors.Join(ErrNoMaxCost, c.restoreLimits(prev))
+	}
+
 	return nil
 }
 
-// WithPolicy sets the eviction policy for the cache.
-func WithPolicy(e EvictionPolicyType) Option {
-	return func(d *cache) error {
-		return d.Store.Policy.SetPolicy(e)
+// limits holds the eviction settings SetConfig restores when a config is rejected.
+type limits struct {
+	Type       EvictionPolicyType
+	Name       string
+	SLRURatio  float64
+	SampleSize int
+	MaxCost    uint64
+	Unlimited  bool
+}
+
+func (c *cache) saveLimits() limits {
+	return limits{
+		Type:       c.Store.Policy.Type,
+		Name:       c.Store.Policy.Name,
+		SLRURatio:  c.Store.Policy.SLRURatio,
+		SampleSize: c.Store.Policy.SampleSize,
+		MaxCost:    c.Store.MaxCost,
+		Unlimited:  c.Store.Unlimited,
 	}
 }
 
-// WithMaxCost sets the maximum cost for the cache.
-func WithMaxCost(maxCost uint64) Option {
-	return func(d *cache) error {
-		d.Store.MaxCost = maxCost
+// restoreLimits puts back the saved settings. The policy is only set again if it
+// changed, since that reorders the eviction list.
+func (c *cache) restoreLimits(prev limits) error {
+	c.Store.MaxCost = prev.MaxCost
+	c.Store.Unlimited = prev.Unlimited
 
+	if prev == c.saveLimits() {
 		return nil
 	}
-}
 
-// SetSnapshotTime sets the interval for taking snapshots of the cache.
-func SetSnapshotTime(t time.Duration) Option {
-	return func(d *cache) error {
-		d.Store.SnapshotTicker.Reset(t)
+	c.Store.Policy.SLRURatio = prev.SLRURatio
+	c.Store.Policy.SampleSize = prev.SampleSize
 
-		return nil
+	if prev.Type == PolicyCustom {
+		return c.Store.Policy.SetCustomPolicy(prev.Name)
 	}
+
+	return c.Store.Policy.SetPolicy(prev.Type)
 }
 
-// SetCleanupTime sets the interval for cleaning up expired entries.
-func SetCleanupTime(t time.Duration) Option {
+var ErrNoMaxCost = errors.New("eviction policy requires a max cost or WithUnlimited") // ErrNoMaxCost is returned when a policy is set without a limit to enforce.
+
+// WithPolicy sets the eviction policy for the cache.
+func WithPolicy(e EvictionPolicyType) Option {
 	return func(d *cache) error {
-		d.Store.CleanupTicker.Reset(t)
+		return d.Store.Policy.SetPolicy(e)
+	}
+}
 
-		return nil
+// WithCustomPolicy sets the eviction policy to the strategy registered under name
+// with RegisterPolicy, returning an UnknownPolicyError if there is none.
+func WithCustomPolicy(name string) Option {
+	return func(d *cache) error {
+		return d.Store.Policy.SetCustomPolicy(name)
 	}
 }
 
-// backgroundWorker performs periodic tasks such as snapshotting and cleanup.
-func (c *cache) backgroundWorker() {
-	defer c.wg.Done()
+// WithSLRURatio sets the share of entries kept in the protected segment of PolicySLRU.
+func WithSLRURatio(ratio float64) Option {
+	return func(d *cache) error {
+		if ratio <= 0 || ratio >= 1 {
+			return ErrInvalidRatio
+		}
 
-	defer func() {
-		if r := recover(); r != nil {
-			c.err = fmt.Errorf("panic occurred: %v", r)
+		d.Store.Policy.SLRURatio = ratio
+
+		if d.Store.Policy.Type != PolicySLRU {
+			return nil
 		}
-	}()
 
-	c.Store.SnapshotTicker.Resume()
-	defer c.Store.SnapshotTicker.Stop()
+		return d.Store.Policy.SetPolicy(PolicySLRU)
+	}
+}
 
-	c.Store.CleanupTicker.Resume()
-	defer c.Store.CleanupTicker.Stop()
+// WithSampleSize sets the number of entries inspected per eviction by PolicyLRUSample.
+func WithSampleSize(n int) Option {
+	return func(d *cache) error {
+		if n <= 0 {
+			return ErrInvalidSampleSize
+		}
 
-	c.Store.Cleanup()
-	c.Store.Evict()
+		d.Store.Policy.SampleSize = n
 
-	for {
-		select {
-		case <-c.Stop:
-			return
-		case <-c.Store.SnapshotTicker.C:
-			if err := c.Flush(); err != nil {
-				c.err = err
-			}
-		case <-c.Store.CleanupTicker.C:
-			c.Store.Cleanup()
-			c.Store.Evict()
+		if d.Store.Policy.Type != PolicyLRUSample {
+			return nil
 		}
+
+		return d.Store.Policy.SetPolicy(PolicyLRUSample)
 	}
 }
 
-func (c *cache) Error() error {
-	return c.err
-}
+// WithOpenTimeout sets how long opening a file-backed cache waits for the file lock
+// before failing with ErrLocked. A zero timeout waits indefinitely.
+func WithOpenTimeout(d time.Duration) Option {
+	return func(c *cache) error {
+		c.OpenTimeout = d
 
-func (c *cache) Cost() uint64 {
-	return c.Store.Cost
+		return nil
+	}
 }
 
-// Close stops the background worker and cleans up resources.
-func (c *cache) Close() error {
-	close(c.Stop)
-	c.wg.Wait()
+// WithSnapshotFailureThreshold sets how many consecutive background snapshots may fail
+// before the cache stops serving requests with the snapshot error.
+// Until then failures are only reported by SnapshotError and retried on the next tick.
+func WithSnapshotFailureThreshold(n int) Option {
+	return func(d *cache) error {
+		d.SnapshotFailureThreshold = n
 
-	err := c.Flush()
-	c.Clear()
+		return nil
+	}
+}
 
-	var err1 error
+// WithMinSnapshotInterval sets the shortest time between two background snapshots.
+// Ticks of the snapshot and forced snapshot intervals arriving sooner are skipped, and
+// the changes are written on the next tick after the interval, so a short snapshot
+// interval on a large cache cannot rewrite the whole file over and over.
+// Snapshots taken by Flush, Sync and Close are not limited.
+func WithMinSnapshotInterval(t time.Duration) Option {
+	return func(d *cache) error {
+		d.MinSnapshotInterval = t
 
-	if c.File != nil {
-		closer, ok := c.File.(io.Closer)
-		if ok {
-			err1 = closer.Close()
-		}
+		return nil
 	}
+}
 
-	if err != nil {
-		return err
-	}
+// WithParallelResize rehashes large hash tables using up to workers goroutines when
+// the cache grows, shortening the time writes are blocked. Values below 2 disable it.
+func WithParallelResize(workers int) Option {
+	return func(d *cache) error {
+		d.Store.ResizeWorkers = workers
 
-	return err1
+		return nil
+	}
 }
 
-// Flush writes the current state of the store to the file.
-func (c *cache) Flush() error {
-	if c.File != nil {
-		return c.Store.Snapshot(c.File)
-	}
+// WithMaxBuckets caps the number of buckets in the hash table, bounding the memory a
+// flood of inserts can make it allocate. Once the cap is reached the table stops
+// growing and lookups slow down as buckets hold more entries. The cap is rounded down
+// to a power of two, and zero removes it.
+func WithMaxBuckets(n uint64) Option {
+	return func(d *cache) error {
+		d.Store.MaxBuckets = n
 
-	return nil
+		return nil
+	}
 }
 
-// Clear removes all entries from the in-memory store.
-func (c *cache) Clear() {
-	c.Store.Clear()
+// WithSyncMaintenance enforces MaxCost inline on every write instead of waiting for
+// the background worker. Writes that exceed the limit pay for the eviction themselves.
+func WithSyncMaintenance() Option {
+	return func(d *cache) error {
+		d.Store.SyncMaintain = true
+
+		return nil
+	}
 }
 
-var ErrKeyNotFound = errors.New("key not found") // ErrKeyNotFound is returned when a key is not found in the cache.
+var ErrInvalidOvershoot = errors.New("overshoot must not be negative") // ErrInvalidOvershoot is returned for a negative overshoot ratio.
 
-// Get retrieves a value from the cache by key and returns its TTL.
-func (c *cache) Get(key []byte, value *[]byte) (time.Duration, error) {
-	v, ttl, err := c.GetValue(key)
-	*value = v
+// WithEvictionOvershoot lets the cost exceed MaxCost by up to ratio (0.1 for 10%)
+// between runs of the background worker. Writes that go beyond that evict inline
+// back down to MaxCost. A ratio of 0 is the same as WithSyncMaintenance.
+func WithEvictionOvershoot(ratio float64) Option {
+	return func(d *cache) error {
+		if ratio < 0 {
+			return ErrInvalidOvershoot
+		}
 
-	return ttl, err
-}
+		if ratio == 0 {
+			d.Store.SyncMaintain = true
+		}
 
-// GetValue retrieves a value from the cache by key and returns the value and its TTL.
-func (c *cache) GetValue(key []byte) ([]byte, time.Duration, error) {
-	if err := c.err; err != nil {
-		return zero[[]byte](), 0, err
-	}
+		d.Store.Overshoot = ratio
 
-	v, ttl, ok := c.Store.Get(key)
-	if !ok {
-		return v, 0, ErrKeyNotFound
+		return nil
 	}
-
-	return v, ttl, nil
 }
 
-// Set adds a key-value pair to the cache with a specified TTL.
-func (c *cache) Set(key, value []byte, ttl time.Duration) error {
-	if err := c.err; err != nil {
-		return err
+// WithMaxCost sets the maximum cost for the cache.
+// An eviction policy requires a non-zero max cost unless WithUnlimited is set.
+func WithMaxCost(maxCost uint64) Option {
+	return func(d *cache) error {
+		d.Store.MaxCost = maxCost
+		d.Store.Unlimited = false
+
+		return nil
 	}
+}
 
-	c.Store.Set(key, value, ttl)
+// WithHasher replaces the hash function used to place keys in the hash table.
+// Existing entries are rehashed with it.
+func WithHasher(fn func(key []byte) uint64) Option {
+	return func(d *cache) error {
+		d.Store.setHasher(fn)
 
-	return nil
+		return nil
+	}
 }
 
-// Delete removes a key-value pair from the cache.
-func (c *cache) Delete(key []byte) error {
-	ok := c.Store.Delete(key)
-	if !ok {
-		return ErrKeyNotFound
-	}
+// WithRejectOnFull makes writes fail with ErrCacheFull when they would exceed MaxCost
+// and the policy is PolicyNone, instead of growing the cache past its limit.
+func WithRejectOnFull() Option {
+	return func(d *cache) error {
+		d.Store.RejectOnFull = true
 
-	return nil
+		return nil
+	}
 }
 
-// UpdateInPlace retrieves a value from the cache, processes it using the provided function,
-// and then sets the result back into the cache with the same key.
-func (c *cache) UpdateInPlace(key []byte, processFunc func([]byte) ([]byte, error), ttl time.Duration) error {
-	if err := c.err; err != nil {
-		return err
-	}
+// WithUnlimited removes the capacity limit, so the eviction policy never evicts.
+// It is the explicit way to use a policy without a max cost.
+func WithUnlimited() Option {
+	return func(d *cache) error {
+		d.Store.MaxCost = 0
+		d.Store.Unlimited = true
 
-	return c.Store.UpdateInPlace(key, processFunc, ttl)
+		return nil
+	}
 }
 
-// Memorize attempts to retrieve a value from the cache. If the retrieval fails,
-// it sets the result of the factory function into the cache and returns that result.
-func (c *cache) Memorize(key []byte, factoryFunc func() ([]byte, error), ttl time.Duration) ([]byte, error) {
-	if err := c.err; err != nil {
-		return []byte{}, err
-	}
+// WithExpirationWheel indexes expiring entries by the second they expire in, so cleanup
+// only visits the entries that are due instead of scanning the whole cache.
+// Expired entries are removed up to a second late, and each entry uses a bit more memory.
+func WithExpirationWheel() Option {
+	return func(d *cache) error {
+		d.Store.enableWheel()
 
-	return c.Store.Memorize(key, factoryFunc, ttl)
+		return nil
+	}
 }
 
-// The Cache database. Can be initialized by either Open or OpenFile or OpenMem. Uses per Cache Locks.
-// Cache represents a generic cache database with key-value pairs.
-type Cache[K any, V any] struct {
-	*cache
+// WithEntryOverhead adds a fixed cost to every entry on top of its key and value bytes,
+// so that MaxCost better tracks the memory used by many small entries.
+func WithEntryOverhead(overhead uint64) Option {
+	return func(d *cache) error {
+		d.Store.Cost = d.Store.Cost - d.Store.Length*d.Store.EntryOverhead + d.Store.Length*overhead
+		d.Store.EntryOverhead = overhead
+
+		return nil
+	}
 }
 
-var _ Cacher[any, any] = Cache[any, any]{}
+// WithDefaultTTL sets the TTL used for entries stored with a TTL of 0 or DefaultTTL.
+// Use NoExpire to store entries without expiration once a default is set.
+func WithDefaultTTL(ttl time.Duration) Option {
+	return func(d *cache) error {
+		d.Store.DefaultTTL = ttl
 
-// The CacheRaw database. Can be initialized by either OpenRaw or OpenRawFile or OpenRawMem. Uses per Cache Locks.
-// CacheRaw represents a binary cache database with key-value pairs.
-type CacheRaw struct {
-	*cache
+		return nil
+	}
 }
 
-var _ Cacher[[]byte, []byte] = CacheRaw{}
+// WithMaxAge sets the maximum time any entry may live, regardless of its TTL.
+// Entries without a TTL expire after maxAge as well. A zero maxAge disables the limit.
+func WithMaxAge(maxAge time.Duration) Option {
+	return func(d *cache) error {
+		d.Store.MaxAge = maxAge
 
-// OpenRaw opens a binary cache database with the specified options. If filename is empty then in-memory otherwise file backed.
-func OpenRaw(filename string, options ...Option) (CacheRaw, error) {
-	ret, err := open(filename, options...)
-	if err != nil {
-		return zero[CacheRaw](), err
+		return nil
 	}
+}
 
-	ret.start()
+var ErrInvalidTTLBounds = errors.New("invalid ttl bounds") // ErrInvalidTTLBounds is returned by WithTTLBounds for a negative or empty range.
 
-	return CacheRaw{cache: ret}, nil
-}
+// WithTTLBounds rejects writes whose TTL is below minTTL or above maxTTL with a
+// TTLRangeError, to catch TTLs passed in the wrong unit. TTLs of 0, NoExpire and
+// DefaultTTL are always accepted. A zero maxTTL leaves the upper bound open.
+func WithTTLBounds(minTTL, maxTTL time.Duration) Option {
+	return func(d *cache) error {
+		if minTTL < 0 || (maxTTL != 0 && maxTTL < minTTL) {
+			return ErrInvalidTTLBounds
+		}
 
-var ErrEmptyFilename = errors.New("cannot open empty filename")
+		d.Store.MinTTL = minTTL
+		d.Store.MaxTTL = maxTTL
 
-// OpenRawFile opens a binary file-backed cache database with the specified options.
-func OpenRawFile(filename string, options ...Option) (CacheRaw, error) {
-	if filename == "" {
-		return zero[CacheRaw](), ErrEmptyFilename
+		return nil
 	}
-
-	return OpenRaw(filename, options...)
 }
 
-// OpenRawMem initializes a binary in-memory cache database with the specified options.
-func OpenRawMem(options ...Option) (CacheRaw, error) {
-	return OpenRaw("", options...)
-}
+var ErrKeyNormalizerType = errors.New("key normalizer has a different key type") // ErrKeyNormalizerType is returned when the key normalizer does not match the cache key type.
 
-// Open opens a cache database with the specified options. If filename is empty then in-memory otherwise file backed.
-func Open[K, V any](filename string, options ...Option) (Cache[K, V], error) {
-	ret, err := OpenRaw(filename, options...)
-	if err != nil {
-		return zero[Cache[K, V]](), err
-	}
+// WithKeyNormalizer applies fn to every key before it is encoded, so keys that are
+// equal by your own definition, such as differently cased strings, hit the same entry.
+// Maps inside keys are always encoded in sorted order, so they need no normalizing.
+// Range and the other methods returning keys return them normalized.
+func WithKeyNormalizer[K any](fn func(K) K) Option {
+	return func(d *cache) error {
+		d.KeyNormalizer = fn
 
-	return Cache[K, V]{cache: ret.cache}, nil
+		return nil
+	}
 }
 
-// OpenFile opens a file-backed cache database with the specified options.
-func OpenFile[K, V any](filename string, options ...Option) (Cache[K, V], error) {
-	if filename == "" {
-		return zero[Cache[K, V]](), ErrEmptyFilename
-	}
+var ErrKeyFuncType = errors.New("key function has a different key type") // ErrKeyFuncType is returned when the key function does not match the cache key type.
 
-	return Open[K, V](filename, options...)
-}
+var ErrKeyNotDecodable = errors.New("key derived by a key function cannot be decoded") // ErrKeyNotDecodable is returned by methods returning keys when WithKeyFunc is set.
 
-// OpenMem initializes an in-memory cache database with the specified options.
-func OpenMem[K, V any](options ...Option) (Cache[K, V], error) {
-	return Open[K, V]("", options...)
+// WithKeyFunc derives the stored key bytes from a key with fn instead of encoding the
+// whole key, for example from only the identity fields of a struct, so keys that
+// differ only in other fields hit the same entry. It is applied after the key
+// normalizer. The derived bytes cannot be turned back into a key, so Range, Snapshot,
+// Expired and the other methods returning stored keys fail with ErrKeyNotDecodable.
+// GetMultiTTL returns the keys it was called with instead.
+func WithKeyFunc[K any](fn func(K) []byte) Option {
+	return func(d *cache) error {
+		d.KeyFunc = fn
+
+		return nil
+	}
 }
 
-// marshal serializes a value using msgpack.
-func marshal[T any](v T) ([]byte, error) {
+// WithOnSet registers a hook called on every Set.
+// The hook runs while the cache is locked, so it must not block or call back into the cache.
+func WithOnSet(fn func(key, value []byte, ttl time.Duration)) Option {
+	return func(d *cache) error {
+		d.Store.OnSet = fn
+
+		return nil
+	}
+}
+
+// WithOnGet registers a hook called on every Get with whether the key was found.
+// The hook runs while the cache is read locked, so it must not block or call back into the cache,
+// and it may be called concurrently.
+func WithOnGet(fn func(key []byte, hit bool)) Option {
+	return func(d *cache) error {
+		d.Store.OnGet = fn
+
+		return nil
+	}
+}
+
+// WithOnEvict registers a hook called for every entry removed by eviction, cleanup or Drain.
+// The hook runs while the cache is locked, so it must not block or call back into the cache.
+func WithOnEvict(fn func(key, value []byte, reason EvictionReason)) Option {
+	return func(d *cache) error {
+		d.Store.OnEvict = fn
+
+		return nil
+	}
+}
+
+// WithBlobStore stores values larger than threshold bytes as individual files in dir
+// instead of keeping them in memory. They are loaded back transparently on access.
+func WithBlobStore(dir string, threshold uint64) Option {
+	return func(d *cache) error {
+		blobs, err := newBlobStore(dir, threshold)
+		if err != nil {
+			return err
+		}
+
+		d.Store.Blobs = blobs
+
+		return nil
+	}
+}
+
+var ErrOverflowDir = errors.New("overflow directory differs from the blob store") // ErrOverflowDir is returned by WithOverflow when a blob store uses another directory.
+
+// WithOverflow moves values to files in dir once the cost of the cache exceeds
+// threshold, starting with the entries the eviction policy would remove first, instead
+// of keeping them in memory. The keys stay in memory and the values are loaded back
+// transparently on access. Values are spilled by the same maintenance that evicts, and
+// an eviction policy still applies to the remaining cost. Values of 64 bytes or less
+// are never spilled. It shares the directory of WithBlobStore if both are used.
+func WithOverflow(dir string, threshold uint64) Option {
+	return func(d *cache) error {
+		if d.Store.Blobs == nil {
+			blobs, err := newBlobStore(dir, math.MaxUint64)
+			if err != nil {
+				return err
+			}
+
+			d.Store.Blobs = blobs
+		} else if d.Store.Blobs.Dir != dir {
+			return ErrOverflowDir
+		}
+
+		d.Store.OverflowCost = threshold
+
+		return nil
+	}
+}
+
+// WithArena copies values into large contiguous slabs of slabSize bytes instead of
+// keeping each value in its own allocation, which reduces the work of the garbage
+// collector for caches holding many small values. A slab is freed once all of its
+// values are removed, and Compact moves the remaining values out of partly used
+// slabs. A slabSize of 0 uses DefaultSlabSize. Values already in the cache stay where
+// they are until they are rewritten.
+func WithArena(slabSize int) Option {
+	return func(d *cache) error {
+		if slabSize < 0 {
+			return ErrInvalidSlabSize
+		}
+
+		if slabSize == 0 {
+			slabSize = DefaultSlabSize
+		}
+
+		if d.Store.Arena != nil {
+			d.Store.Arena.SlabSize = slabSize
+
+			return nil
+		}
+
+		d.Store.Arena = newArena(slabSize)
+
+		return nil
+	}
+}
+
+// WithStatsWindow enables tracking of hits and misses over a rolling window of the given length.
+func WithStatsWindow(window time.Duration) Option {
+	return func(d *cache) error {
+		d.Store.Stats = newWindowStats(window)
+		d.Store.StatsTicker.Reset(d.Store.Stats.Span)
+
+		return nil
+	}
+}
+
+// WithAgeStats records when each entry is written, so the ages of the oldest and
+// newest entries can be read with AgeStats.
+func WithAgeStats() Option {
+	return func(d *cache) error {
+		d.Store.TrackAge = true
+
+		return nil
+	}
+}
+
+// WithAccessTracking records when each entry is created and last read and how often it
+// is read, reported by GetMeta and kept in snapshots. It adds atomic writes to every
+// read, so it is off by default.
+func WithAccessTracking() Option {
+	return func(d *cache) error {
+		d.Store.TrackAccess = true
+
+		return nil
+	}
+}
+
+// WithLockMetrics records how long writes wait for the store lock and how long they
+// hold it, to diagnose contention. The histograms are read with Stats.
+func WithLockMetrics() Option {
+	return func(d *cache) error {
+		d.Store.Lock.Metrics.Store(true)
+
+		return nil
+	}
+}
+
+// WithEvictionHistory keeps a record of the last n keys removed by eviction or expiry cleanup.
+func WithEvictionHistory(n int) Option {
+	return func(d *cache) error {
+		if n <= 0 {
+			d.Store.History = nil
+
+			return nil
+		}
+
+		d.Store.History = newHistoryRing(n)
+
+		return nil
+	}
+}
+
+// WithSnapshotFormat sets the format snapshots are written in. Loading detects the format automatically.
+func WithSnapshotFormat(format SnapshotFormat) Option {
+	return func(d *cache) error {
+		if format != FormatBinary && format != FormatMsgpack {
+			return ErrInvalidFormat
+		}
+
+		d.Store.Format = format
+
+		return nil
+	}
+}
+
+// WithIncrementRefreshTTL makes IncrementWithTTL reset the TTL of a counter on every
+// increment, giving a window that slides with each hit. By default only the increment
+// that creates the counter sets its TTL.
+func WithIncrementRefreshTTL() Option {
+	return func(d *cache) error {
+		d.Store.IncrementRefresh = true
+
+		return nil
+	}
+}
+
+// WithSnapshotFilter makes snapshots keep only the entries for which keep returns true,
+// for example only entries without expiration. The filter sees raw keys and values
+// and a TTL of 0 for entries that never expire. It runs under the cache lock and
+// must not block. Filtered entries stay in memory but are lost on restart.
+func WithSnapshotFilter(keep func(key, value []byte, ttl time.Duration) bool) Option {
+	return func(d *cache) error {
+		d.Store.SnapshotFilter = keep
+
+		return nil
+	}
+}
+
+// WithDeferInitialSnapshot skips writing an empty snapshot when a file-backed cache
+// creates a new file, so the disk is not touched until there is data to snapshot.
+// Until then the file stays empty, which opens as an empty cache.
+func WithDeferInitialSnapshot() Option {
+	return func(d *cache) error {
+		d.DeferInitialSnapshot = true
+
+		return nil
+	}
+}
+
+// WithSnapshotBufferSize sets the size of the buffer snapshots are written through.
+// A larger buffer means fewer write syscalls for large caches. Values below 1 use
+// the default of 4KB.
+func WithSnapshotBufferSize(n int) Option {
+	return func(d *cache) error {
+		d.Store.SnapshotBufferSize = n
+
+		return nil
+	}
+}
+
+// SetSnapshotTime sets the interval for taking snapshots of the cache.
+// Snapshots are skipped if the cache has not changed since the last one.
+func SetSnapshotTime(t time.Duration) Option {
+	return func(d *cache) error {
+		d.Store.SnapshotTicker.Reset(t)
+
+		return nil
+	}
+}
+
+// WithForceSnapshotInterval sets the interval for taking snapshots of the cache
+// even when it has not changed.
+func WithForceSnapshotInterval(t time.Duration) Option {
+	return func(d *cache) error {
+		d.Store.ForceTicker.Reset(t)
+
+		return nil
+	}
+}
+
+// WithSnapshotJitter delays the first snapshot by a random duration up to jitter, so
+// many caches opened together with the same snapshot interval do not all write at once.
+// It applies to both the snapshot and the forced snapshot interval.
+func WithSnapshotJitter(jitter time.Duration) Option {
+	return func(d *cache) error {
+		d.SnapshotJitter = jitter
+
+		return nil
+	}
+}
+
+// WithSyncOnSnapshot fsyncs the file after every snapshot, including the background ones
+// and the one taken on Close, trading write latency for durability.
+func WithSyncOnSnapshot() Option {
+	return func(d *cache) error {
+		d.SyncOnSnapshot = true
+
+		return nil
+	}
+}
+
+// SetCleanupTime sets the interval for cleaning up expired entries.
+func SetCleanupTime(t time.Duration) Option {
+	return func(d *cache) error {
+		d.Store.CleanupTicker.Reset(t)
+
+		return nil
+	}
+}
+
+// defaultCleanupBatch is the number of hash buckets cleaned per lock hold by
+// WithConcurrentCleanup.
+const defaultCleanupBatch = 256
+
+// WithConcurrentCleanup runs the cleanup of expired entries in its own goroutine
+// instead of the one taking snapshots, so a long cleanup does not delay a due snapshot
+// and the other way round. The cleanup releases the cache lock after every batch hash
+// buckets to let snapshots and writes in. Values below 1 use a batch of 256 buckets.
+func WithConcurrentCleanup(batch int) Option {
+	return func(d *cache) error {
+		if batch < 1 {
+			batch = defaultCleanupBatch
+		}
+
+		d.CleanupBatch = batch
+
+		return nil
+	}
+}
+
+// backgroundWorker performs periodic tasks such as snapshotting and cleanup.
+func (c *cache) backgroundWorker() {
+	defer c.wg.Done()
+
+	defer func() {
+		if r := recover(); r != nil {
+			c.setErr(fmt.Errorf("%w: %v", ErrPanic, r))
+		}
+	}()
+
+	// With jitter the snapshot tickers only start once a random phase has passed.
+	var phase <-chan time.Time
+
+	if c.SnapshotJitter > 0 {
+		c.Store.SnapshotTicker.Stop()
+		c.Store.ForceTicker.Stop()
+
+		phase = time.After(c.jitter(c.SnapshotJitter))
+	} else {
+		c.Store.SnapshotTicker.Resume()
+		c.Store.ForceTicker.Resume()
+	}
+
+	defer c.Store.SnapshotTicker.Stop()
+	defer c.Store.ForceTicker.Stop()
+
+	c.Store.StatsTicker.Resume()
+	defer c.Store.StatsTicker.Stop()
+
+	cleanup := c.Store.CleanupTicker.C
+
+	if c.CleanupBatch > 0 {
+		cleanup = nil
+
+		c.wg.Add(1)
+
+		go c.cleanupWorker()
+	} else {
+		c.Store.CleanupTicker.Resume()
+		defer c.Store.CleanupTicker.Stop()
+
+		c.Store.Cleanup()
+		c.Store.Evict()
+	}
+
+	for {
+		select {
+		case <-c.Stop:
+			return
+		case <-phase:
+			phase = nil
+
+			c.Store.SnapshotTicker.Resume()
+			c.Store.ForceTicker.Resume()
+		case <-c.Store.SnapshotTicker.C:
+			if !c.Store.Dirty.Load() {
+				continue
+			}
+
+			c.snapshot()
+		case <-c.Store.ForceTicker.C:
+			c.snapshot()
+		case <-cleanup:
+			c.Store.Cleanup()
+			c.Store.Evict()
+		case <-c.Store.StatsTicker.C:
+			if c.Store.Stats != nil {
+				c.Store.Stats.Advance()
+			}
+		}
+	}
+}
+
+// cleanupWorker removes expired entries in batches on its own ticker, for WithConcurrentCleanup.
+func (c *cache) cleanupWorker() {
+	defer c.wg.Done()
+
+	defer func() {
+		if r := recover(); r != nil {
+			c.setErr(fmt.Errorf("%w: %v", ErrPanic, r))
+		}
+	}()
+
+	c.Store.CleanupTicker.Resume()
+	defer c.Store.CleanupTicker.Stop()
+
+	for {
+		c.Store.CleanupBatched(c.CleanupBatch)
+		c.Store.Evict()
+
+		select {
+		case <-c.Stop:
+			return
+		case <-c.Store.CleanupTicker.C:
+		}
+	}
+}
+
+// snapshot flushes the store from the background worker. Failures are recorded and
+// retried on the next tick, and only become fatal after SnapshotFailureThreshold
+// consecutive failures.
+func (c *cache) snapshot() {
+	if c.MinSnapshotInterval > 0 && time.Since(c.lastSnapshot) < c.MinSnapshotInterval {
+		return
+	}
+
+	err := c.flush()
+
+	c.snapshotMu.Lock()
+	defer c.snapshotMu.Unlock()
+
+	if err == nil {
+		c.snapshotFailures = 0
+		c.snapshotErr = nil
+		c.lastSnapshot = time.Now()
+
+		return
+	}
+
+	c.snapshotFailures++
+	c.snapshotErr = err
+
+	if c.snapshotFailures >= c.SnapshotFailureThreshold {
+		c.setErr(err)
+	}
+}
+
+// SnapshotError returns the error of the last background snapshot if it failed.
+func (c *cache) SnapshotError() error {
+	c.snapshotMu.Lock()
+	defer c.snapshotMu.Unlock()
+
+	return c.snapshotErr
+}
+
+var ErrPanic = errors.New("panic occurred") // ErrPanic is recorded when the background worker panics.
+
+func (c *cache) Error() error {
+	if err := c.err.Load(); err != nil {
+		return *err
+	}
+
+	return nil
+}
+
+// setErr records err as the error returned by every operation, or clears it if err is nil.
+func (c *cache) setErr(err error) {
+	if err == nil {
+		c.err.Store(nil)
+		return
+	}
+
+	c.err.Store(&err)
+}
+
+// ClearError clears the recorded background error so operations can resume.
+// Errors wrapping ErrPanic and ErrClosed cannot be cleared since the background worker
+// has stopped, in which case the error is returned unchanged.
+func (c *cache) ClearError() error {
+	if err := c.Error(); errors.Is(err, ErrPanic) || c.closed() {
+		return err
+	}
+
+	c.setErr(nil)
+
+	return nil
+}
+
+// Cost returns the total cost of the entries. It takes the store lock, since the
+// background worker changes the cost while it evicts. After Close it reports 0, since
+// the entries are dropped.
+func (c *cache) Cost() uint64 {
+	c.Store.Lock.RLock()
+	defer c.Store.Lock.RUnlock()
+
+	return c.Store.Cost
+}
+
+// Cleanup removes expired entries now instead of waiting for the background cleanup,
+// and returns how many entries were removed and the cost they freed.
+func (c *cache) Cleanup() (removed int, freedCost uint64, err error) {
+	if c.closed() {
+		return 0, 0, ErrClosed
+	}
+
+	removed, freedCost = c.Store.Cleanup()
+
+	return removed, freedCost, nil
+}
+
+// EvictN removes up to n entries in the order of the eviction policy, even if the cache
+// is within MaxCost, for example to respond to memory pressure. It returns how many
+// entries were removed, which is 0 with PolicyNone.
+func (c *cache) EvictN(n int) (int, error) {
+	if c.closed() {
+		return 0, ErrClosed
+	}
+
+	return c.Store.EvictN(n), nil
+}
+
+// HitRatio returns the share of lookups that were hits within the stats window.
+// It returns 0 if stats are not enabled with WithStatsWindow.
+func (c *cache) HitRatio() float64 {
+	if c.Store.Stats == nil {
+		return 0
+	}
+
+	return c.Store.Stats.HitRatio()
+}
+
+// Stats returns the metrics collected by the cache. The lock histograms are empty
+// unless enabled with WithLockMetrics, and the snapshot byte counts are only kept for
+// file-backed caches.
+func (c *cache) Stats() Stats {
+	return Stats{
+		LockWait: c.Store.Lock.Wait.Snapshot(),
+		LockHold: c.Store.Lock.Hold.Snapshot(),
+
+		SnapshotWritten:  c.snapshotWritten.Load(),
+		LastSnapshotSize: c.lastSnapshotSize.Load(),
+	}
+}
+
+// TTLHistogram counts the live entries by remaining time to live, to help tune the
+// cleanup interval. Count i is the number of entries expiring within buckets[i] but
+// after buckets[i-1], and the extra last count is the number of entries that never
+// expire. The buckets must be in ascending order, and entries expiring after the last
+// bucket are not counted.
+func (c *cache) TTLHistogram(buckets []time.Duration) []uint64 {
+	return c.Store.TTLHistogram(buckets)
+}
+
+// AgeStats returns the ages of the oldest and newest live entries since they were
+// last written. It returns 0 for both unless enabled with WithAgeStats.
+func (c *cache) AgeStats() (oldest, newest time.Duration) {
+	return c.Store.AgeStats()
+}
+
+// EvictionHistory returns the most recent evictions and expirations from oldest to newest.
+// It returns nil unless enabled with WithEvictionHistory.
+func (c *cache) EvictionHistory() []EvictionRecord {
+	return c.Store.EvictionHistory()
+}
+
+var ErrClosed = errors.New("cache is closed") // ErrClosed is returned when the cache has been closed.
+
+// closed reports whether Close has been called.
+func (c *cache) closed() bool {
+	select {
+	case <-c.Stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// Healthy reports whether the cache is operational. It returns the recorded background
+// error if any, ErrClosed once the cache is closed, and an error if the backing file
+// can no longer be accessed.
+func (c *cache) Healthy() error {
+	if err := c.Error(); err != nil {
+		return err
+	}
+
+	select {
+	case <-c.Stop:
+		return ErrClosed
+	default:
+	}
+
+	if file, ok := c.File.(interface{ Stat() (os.FileInfo, error) }); ok {
+		if _, err := file.Stat(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Compact removes expired entries and shrinks the hash table after heavy churn.
+func (c *cache) Compact() error {
+	if err := c.Error(); err != nil {
+		return err
+	}
+
+	c.Store.Compact()
+
+	return nil
+}
+
+// HashDistribution returns the number of entries in each bucket of the hash table,
+// to evaluate how well the hasher spreads the keys.
+func (c *cache) HashDistribution() []int {
+	return c.Store.HashDistribution()
+}
+
+// Drain passes every entry to the OnEvict hook and then removes all entries,
+// for example to flush them to a backing store before Close.
+func (c *cache) Drain() error {
+	if c.closed() {
+		return ErrClosed
+	}
+
+	c.Store.Drain()
+
+	return nil
+}
+
+// Freeze blocks every operation on the cache, including background snapshots,
+// until Unfreeze is called, for example while copying the file for a backup.
+// Calls made while frozen wait instead of failing, so the goroutine holding the
+// freeze must not use the cache, or Close it, before calling Unfreeze, otherwise it
+// deadlocks. Every Freeze must be paired with exactly one Unfreeze.
+func (c *cache) Freeze() {
+	c.Store.Lock.Lock()
+}
+
+// Unfreeze resumes operations blocked by Freeze.
+func (c *cache) Unfreeze() {
+	c.Store.Lock.Unlock()
+}
+
+// MaxCost returns the maximum cost of the cache. It keeps reporting the configured
+// limit after Close.
+func (c *cache) MaxCost() uint64 {
+	c.Store.Lock.RLock()
+	defer c.Store.Lock.RUnlock()
+
+	return c.Store.MaxCost
+}
+
+// SetMaxCost changes the maximum cost of the cache at runtime,
+// evicting entries right away if the cache is over the new limit.
+// It returns ErrNoMaxCost for a max cost of 0 while an eviction policy is set,
+// unless the cache was opened with WithUnlimited.
+func (c *cache) SetMaxCost(maxCost uint64) error {
+	if c.closed() {
+		return ErrClosed
+	}
+
+	return c.Store.SetMaxCost(maxCost)
+}
+
+// Close stops the background worker and cleans up resources.
+// It is safe to call Close more than once; later calls return the result of the first.
+func (c *cache) Close() error {
+	c.closeOnce.Do(func() {
+		c.closeErr = c.close(true)
+	})
+
+	return c.closeErr
+}
+
+// CloseWithoutFlush is like Close but discards the changes since the last snapshot
+// instead of writing them, leaving the file as it is, for example when the cache
+// holds known-bad data or was already saved elsewhere. Once the cache is closed by
+// either method, later calls to both return the result of the first.
+func (c *cache) CloseWithoutFlush() error {
+	c.closeOnce.Do(func() {
+		c.closeErr = c.close(false)
+	})
+
+	return c.closeErr
+}
+
+// close stops the background worker, flushes the store if requested and closes the file.
+// Afterwards every operation fails with ErrClosed. The exceptions are the methods without
+// an error to return: Cost, MaxCost and the statistics report on the empty store, and
+// Clear, Freeze and Unfreeze have nothing left to act on.
+func (c *cache) close(flush bool) error {
+	close(c.Stop)
+	c.wg.Wait()
+
+	var err error
+	if flush {
+		err = c.flush()
+	}
+
+	c.Clear()
+	c.setErr(ErrClosed)
+
+	var err1 error
+
+	if c.File != nil {
+		closer, ok := c.File.(io.Closer)
+		if ok {
+			err1 = closer.Close()
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return err1
+}
+
+// Flush writes the current state of the store to the file.
+// The data may remain in the OS page cache unless WithSyncOnSnapshot is set.
+func (c *cache) Flush() error {
+	if c.closed() {
+		return ErrClosed
+	}
+
+	return c.flush()
+}
+
+// flush writes the store to the file, fsyncing it if WithSyncOnSnapshot is set.
+func (c *cache) flush() error {
+	if c.File == nil {
+		return nil
+	}
+
+	if err := c.writeFile(); err != nil {
+		return err
+	}
+
+	if c.SyncOnSnapshot {
+		return c.sync()
+	}
+
+	return nil
+}
+
+// writeFile writes a snapshot to the file and counts the bytes written.
+func (c *cache) writeFile() error {
+	if err := c.Store.Snapshot(c.File); err != nil {
+		return err
+	}
+
+	// The snapshot is written from the start of the file, so the offset is its size.
+	n, err := c.File.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	c.snapshotWritten.Add(uint64(n))
+	c.lastSnapshotSize.Store(uint64(n))
+
+	return nil
+}
+
+// Sync writes the current state of the store to the file and forces it to stable
+// storage, so it survives a power loss.
+func (c *cache) Sync() error {
+	if c.closed() {
+		return ErrClosed
+	}
+
+	if c.File == nil {
+		return nil
+	}
+
+	if err := c.writeFile(); err != nil {
+		return err
+	}
+
+	return c.sync()
+}
+
+// LoadSnapshotReader replaces the contents of the cache with a snapshot read from r,
+// such as a pipe or a decompressing reader that cannot seek. Like opening a file,
+// the limits and intervals saved in the snapshot replace the current ones.
+// If the snapshot cannot be read the cache is left empty. A snapshot holding duplicate
+// keys is still loaded, keeping the last entry for each key, and a DuplicateKeyError is returned.
+// A snapshot naming an unregistered custom policy is loaded with PolicyNone, and an
+// UnknownPolicyError is returned.
+func (c *cache) LoadSnapshotReader(r io.Reader) error {
+	if err := c.Error(); err != nil {
+		return err
+	}
+
+	c.Store.Lock.Lock()
+	defer c.Store.Lock.Unlock()
+
+	c.Store.clear()
+
+	err := c.Store.LoadSnapshotReader(r)
+	if err != nil && !errors.Is(err, ErrDuplicateKey) && !errors.Is(err, ErrUnknownPolicy) {
+		c.Store.clear()
+
+		return err
+	}
+
+	c.Store.Dirty.Store(true)
+
+	return err
+}
+
+// SnapshotBytes returns a snapshot of the cache in memory, for example to embed it in
+// a message. It uses the configured snapshot format.
+func (c *cache) SnapshotBytes() ([]byte, error) {
+	if err := c.Error(); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := c.Store.Snapshot(&buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// LoadSnapshotBytes replaces the contents of the cache with a snapshot returned by
+// SnapshotBytes, like LoadSnapshotReader.
+func (c *cache) LoadSnapshotBytes(data []byte) error {
+	return c.LoadSnapshotReader(bytes.NewReader(data))
+}
+
+// sync fsyncs the file if it supports it.
+func (c *cache) sync() error {
+	if file, ok := c.File.(interface{ Sync() error }); ok {
+		return file.Sync()
+	}
+
+	return nil
+}
+
+// Clear removes all entries from the in-memory store.
+func (c *cache) Clear() {
+	c.Store.Clear()
+}
+
+// Reset removes all entries, restores the default configuration and clears any recorded error.
+// Unlike Clear, which only drops entries, the cache behaves as if it was freshly opened.
+// Like ClearError, it refuses an error wrapping ErrPanic, since the background worker has
+// stopped, and returns the error without changing the cache.
+func (c *cache) Reset() error {
+	if c.closed() {
+		return ErrClosed
+	}
+
+	if err := c.Error(); errors.Is(err, ErrPanic) {
+		return err
+	}
+
+	c.setErr(nil)
+
+	return c.Store.Reset()
+}
+
+var ErrKeyNotFound = errors.New("key not found") // ErrKeyNotFound is returned when a key is not found in the cache.
+
+// Get retrieves a value from the cache by key and returns its TTL.
+func (c *cache) Get(key []byte, value *[]byte) (time.Duration, error) {
+	v, ttl, err := c.GetValue(key)
+	*value = v
+
+	return ttl, err
+}
+
+// GetValue retrieves a value from the cache by key and returns the value and its TTL.
+func (c *cache) GetValue(key []byte) ([]byte, time.Duration, error) {
+	if err := c.Error(); err != nil {
+		return zero[[]byte](), 0, err
+	}
+
+	v, ttl, ok := c.Store.Get(key)
+	if !ok {
+		return v, 0, ErrKeyNotFound
+	}
+
+	return v, ttl, nil
+}
+
+// TTL returns the remaining time-to-live of a key without fetching its value.
+// It returns 0 for keys that never expire.
+func (c *cache) TTL(key []byte) (time.Duration, error) {
+	if err := c.Error(); err != nil {
+		return 0, err
+	}
+
+	ttl, ok := c.Store.TTL(key)
+	if !ok {
+		return 0, ErrKeyNotFound
+	}
+
+	return ttl, nil
+}
+
+// GetMeta returns the access metadata of a key without fetching its value. It does
+// not count as an access. LastAccess, AccessCount and CreatedAt are only recorded
+// with WithAccessTracking and are zero otherwise.
+func (c *cache) GetMeta(key []byte) (Meta, error) {
+	if err := c.Error(); err != nil {
+		return Meta{}, err
+	}
+
+	meta, ok := c.Store.Meta(key)
+	if !ok {
+		return Meta{}, ErrKeyNotFound
+	}
+
+	return meta, nil
+}
+
+// Touch resets the TTL of a key without rewriting its value.
+// It returns ErrKeyNotFound for missing and expired keys.
+func (c *cache) Touch(key []byte, ttl time.Duration) error {
+	if err := c.Error(); err != nil {
+		return err
+	}
+
+	ok, err := c.Store.Touch(key, ttl)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return ErrKeyNotFound
+	}
+
+	return nil
+}
+
+// ExpireMulti resets the TTL of every present key under a single write lock and
+// returns how many entries were updated. Missing and expired keys are skipped.
+func (c *cache) ExpireMulti(keys [][]byte, ttl time.Duration) (int, error) {
+	if err := c.Error(); err != nil {
+		return 0, err
+	}
+
+	return c.Store.ExpireMulti(keys, ttl)
+}
+
+// GetAllowStale is like GetValue, but returns an expired entry that has not been
+// cleaned up yet instead of ErrKeyNotFound, with stale set and a negative TTL.
+// This allows serving stale values while a backend is down. The entry is not removed.
+func (c *cache) GetAllowStale(key []byte) ([]byte, time.Duration, bool, error) {
+	if err := c.Error(); err != nil {
+		return nil, 0, false, err
+	}
+
+	v, ttl, stale, ok := c.Store.GetStale(key)
+	if !ok {
+		return nil, 0, false, ErrKeyNotFound
+	}
+
+	return v, ttl, stale, nil
+}
+
+// GetMultiTTL retrieves the values and remaining TTLs of many keys under a single
+// read lock. Missing and expired keys are left out, and the rest keep the order of keys.
+func (c *cache) GetMultiTTL(keys [][]byte) ([]Entry[[]byte, []byte], error) {
+	if err := c.Error(); err != nil {
+		return nil, err
+	}
+
+	return c.Store.GetMulti(keys), nil
+}
+
+// GetBatch retrieves many keys under a single read lock. Unlike GetMultiTTL the result
+// is aligned index for index with keys, and misses are reported with Found unset.
+func (c *cache) GetBatch(keys [][]byte) ([]Result[[]byte], error) {
+	if err := c.Error(); err != nil {
+		return nil, err
+	}
+
+	return c.Store.GetBatch(keys), nil
+}
+
+// HasMulti reports for each key whether it is present and not expired, without fetching
+// the values. The result is in the same order as keys.
+func (c *cache) HasMulti(keys [][]byte) ([]bool, error) {
+	if err := c.Error(); err != nil {
+		return nil, err
+	}
+
+	return c.Store.HasMulti(keys), nil
+}
+
+// Set adds a key-value pair to the cache with a specified TTL.
+// An empty key is a valid key like any other and survives snapshots.
+func (c *cache) Set(key, value []byte, ttl time.Duration) error {
+	if err := c.Error(); err != nil {
+		return err
+	}
+
+	return c.Store.Set(key, value, ttl)
+}
+
+// SetIfNewer adds or updates a key-value pair only if version is greater than the
+// version of the existing entry, and reports whether it was stored.
+// Equal versions are rejected, and entries written by Set have version 0.
+func (c *cache) SetIfNewer(key, value []byte, version uint64, ttl time.Duration) (bool, error) {
+	if err := c.Error(); err != nil {
+		return false, err
+	}
+
+	return c.Store.SetIfNewer(key, value, version, ttl)
+}
+
+// GetWithVersion retrieves a value with its TTL and generation. The generation is
+// assigned by the cache and grows on every write, unlike the version of SetIfNewer,
+// which is chosen by the caller. Pass it to SetIfVersion for optimistic concurrency.
+func (c *cache) GetWithVersion(key []byte) ([]byte, uint64, time.Duration, error) {
+	if err := c.Error(); err != nil {
+		return nil, 0, 0, err
+	}
+
+	value, ttl, generation, ok := c.Store.GetWithVersion(key)
+	if !ok {
+		return nil, 0, 0, ErrKeyNotFound
+	}
+
+	return value, generation, ttl, nil
+}
+
+// SetIfVersion stores a key-value pair only if the generation of the entry is still
+// expectedVersion, as returned by GetWithVersion, and reports whether it was stored.
+// Missing keys have generation 0, so passing 0 only stores new keys.
+func (c *cache) SetIfVersion(key, value []byte, expectedVersion uint64, ttl time.Duration) (bool, error) {
+	if err := c.Error(); err != nil {
+		return false, err
+	}
+
+	return c.Store.SetIfVersion(key, value, expectedVersion, ttl)
+}
+
+// Range calls fn for each entry in the cache until fn returns false.
+// The cache is read locked during iteration, so fn must not modify it.
+func (c *cache) Range(fn func(key, value []byte, ttl time.Duration) bool) error {
+	return c.RangeContext(context.Background(), fn)
+}
+
+// Scan returns a batch of at least count keys, unless the scan ends first, starting at
+// cursor, and the cursor to pass to the next call. Start with a cursor of 0; a
+// returned cursor of 0 means every bucket was visited. The lock is only held for one
+// batch, so writes continue between calls. Keys present for the whole scan are
+// returned at least once, others may or may not be, and a key may be returned more
+// than once if the cache is resized between calls.
+func (c *cache) Scan(cursor uint64, count int) ([][]byte, uint64, error) {
+	if err := c.Error(); err != nil {
+		return nil, 0, err
+	}
+
+	keys, next := c.Store.Scan(cursor, count)
+
+	return keys, next, nil
+}
+
+// RangeContext is like Range but stops early and returns the context error once ctx is cancelled.
+func (c *cache) RangeContext(ctx context.Context, fn func(key, value []byte, ttl time.Duration) bool) error {
+	if err := c.Error(); err != nil {
+		return err
+	}
+
+	return c.Store.Range(ctx, fn)
+}
+
+// Entry is a key-value pair with its own TTL, used for batch writes.
+type Entry[K any, V any] struct {
+	Key   K
+	Value V
+	TTL   time.Duration
+}
+
+// Result is the outcome of looking up one key in a batch. Found is false for missing
+// and expired keys, which leave Value and TTL zero.
+type Result[V any] struct {
+	Value V
+	TTL   time.Duration
+	Found bool
+}
+
+// Meta describes how an entry has been used, as returned by GetMeta.
+type Meta struct {
+	LastAccess  time.Time // LastAccess is when the entry was last read, or zero if it never was.
+	AccessCount uint64    // AccessCount is the number of times the entry was read.
+	CreatedAt   time.Time // CreatedAt is when the key was inserted. Overwriting it keeps the time.
+	Expiration  time.Time // Expiration is when the entry expires, or zero if it never does.
+}
+
+// RangeLenient is like Range. Raw entries cannot fail to decode, so it never reports a DecodeError.
+func (c *cache) RangeLenient(fn func(key, value []byte, ttl time.Duration) bool) error {
+	return c.Range(fn)
+}
+
+// ParallelRange calls fn for each entry using the given number of goroutines, each
+// covering part of the hash table. The cache is read locked during iteration, so fn must
+// not modify it and must be safe for concurrent use. The first error from fn is returned.
+func (c *cache) ParallelRange(workers int, fn func(key, value []byte) error) error {
+	if err := c.Error(); err != nil {
+		return err
+	}
+
+	return c.Store.ParallelRange(workers, fn)
+}
+
+// Snapshot returns a point-in-time copy of all live entries. The cache is only locked
+// while copying, so unlike Range, iterating the result does not block writers.
+func (c *cache) Snapshot() ([]Entry[[]byte, []byte], error) {
+	if err := c.Error(); err != nil {
+		return nil, err
+	}
+
+	return c.Store.Entries(), nil
+}
+
+// KeyValue is a key-value pair returned by the cache.
+type KeyValue[K any, V any] struct {
+	Key   K
+	Value V
+}
+
+// Expired returns the entries that have expired but have not been cleaned up yet,
+// without removing them.
+func (c *cache) Expired() ([]KeyValue[[]byte, []byte], error) {
+	if err := c.Error(); err != nil {
+		return nil, err
+	}
+
+	return c.Store.Expired(), nil
+}
+
+// SetEntries adds many key-value pairs to the cache, each with its own TTL.
+func (c *cache) SetEntries(entries []Entry[[]byte, []byte]) error {
+	if err := c.Error(); err != nil {
+		return err
+	}
+
+	return c.Store.SetEntries(entries)
+}
+
+// WouldFit reports whether setting values under keys would keep the cache within its
+// max cost, counting the cost of the entries they overwrite as freed. It always
+// reports true without a max cost, and false if keys and values differ in length.
+// The answer can change before the entries are stored if other writes happen in between.
+func (c *cache) WouldFit(keys, values [][]byte) (bool, error) {
+	if c.closed() {
+		return false, ErrClosed
+	}
+
+	return c.Store.WouldFit(keys, values), nil
+}
+
+// ReplaceAll atomically replaces the whole contents of the cache with entries.
+// Readers see either the old or the new contents, never an empty or partial cache.
+func (c *cache) ReplaceAll(entries []Entry[[]byte, []byte]) error {
+	if err := c.Error(); err != nil {
+		return err
+	}
+
+	return c.Store.ReplaceAll(entries)
+}
+
+// SetTagged adds a key-value pair to the cache like Set and labels it with tags,
+// so it can be deleted together with other entries by InvalidateTag.
+// The tags replace any tags the key had, and a plain Set removes them.
+func (c *cache) SetTagged(key, value []byte, ttl time.Duration, tags ...string) error {
+	if err := c.Error(); err != nil {
+		return err
+	}
+
+	return c.Store.SetTagged(key, value, ttl, tags)
+}
+
+// InvalidateTag deletes every entry labelled with tag and returns how many were deleted.
+func (c *cache) InvalidateTag(tag string) (int, error) {
+	if err := c.Error(); err != nil {
+		return 0, err
+	}
+
+	return c.Store.InvalidateTag(tag), nil
+}
+
+// Delete removes a key-value pair from the cache.
+func (c *cache) Delete(key []byte) error {
+	if err := c.Error(); err != nil {
+		return err
+	}
+
+	ok := c.Store.Delete(key)
+	if !ok {
+		return ErrKeyNotFound
+	}
+
+	return nil
+}
+
+// UpdateInPlace retrieves a value from the cache, processes it using the provided function,
+// and then sets the result back into the cache with the same key. processFunc runs
+// without the cache lock and is called again if the key is written meanwhile, so it
+// may run more than once.
+func (c *cache) UpdateInPlace(key []byte, processFunc func([]byte) ([]byte, error), ttl time.Duration) error {
+	if err := c.Error(); err != nil {
+		return err
+	}
+
+	return c.Store.UpdateInPlace(key, processFunc, ttl)
+}
+
+// IncrementWithTTL atomically adds delta to the msgpack encoded integer at key and
+// returns the new value. A missing or expired counter starts at 0 and gets ttl.
+func (c *cache) IncrementWithTTL(key []byte, delta int64, ttl time.Duration) (int64, error) {
+	if err := c.Error(); err != nil {
+		return 0, err
+	}
+
+	return c.Store.IncrementWithTTL(key, delta, ttl)
+}
+
+// Memorize attempts to retrieve a value from the cache. If the retrieval fails,
+// it sets the result of the factory function into the cache and returns that result.
+func (c *cache) Memorize(key []byte, factoryFunc func() ([]byte, error), ttl time.Duration) ([]byte, error) {
+	value, _, err := c.MemorizeX(key, factoryFunc, ttl)
+
+	return value, err
+}
+
+// MemorizeX is like Memorize, but also reports whether the factory function ran
+// because of a miss (true) or the cached value was returned (false).
+func (c *cache) MemorizeX(key []byte, factoryFunc func() ([]byte, error), ttl time.Duration) ([]byte, bool, error) {
+	if err := c.Error(); err != nil {
+		return []byte{}, false, err
+	}
+
+	computed := false
+
+	value, err := c.Store.Memorize(key, func() ([]byte, error) {
+		computed = true
+
+		return factoryFunc()
+	}, ttl)
+
+	return value, computed, err
+}
+
+var ErrFactoryTimeout = errors.New("factory timed out") // ErrFactoryTimeout is returned when a factory does not finish in time.
+
+// withTimeout wraps a factory so that it fails with ErrFactoryTimeout if it does not finish in time.
+// The context passed to the factory is canceled on timeout, so the factory can stop; one that
+// ignores it keeps running in the background until it returns, and its result is discarded.
+func withTimeout[T any](factoryFunc func(ctx context.Context) (T, error), timeout time.Duration) func() (T, error) {
+	return func() (T, error) {
+		type result struct {
+			value T
+			err   error
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		ch := make(chan result, 1)
+
+		go func() {
+			value, err := factoryFunc(ctx)
+			ch <- result{value, err}
+		}()
+
+		select {
+		case r := <-ch:
+			return r.value, r.err
+		case <-ctx.Done():
+			return zero[T](), ErrFactoryTimeout
+		}
+	}
+}
+
+// MemorizeTimeout is like Memorize but fails with ErrFactoryTimeout if the factory
+// does not finish within timeout. Nothing is cached in that case. The factory runs
+// without holding the cache lock, and its context is canceled on timeout.
+func (c *cache) MemorizeTimeout(key []byte, factoryFunc func(ctx context.Context) ([]byte, error), ttl, timeout time.Duration) ([]byte, error) {
+	return c.Memorize(key, withTimeout(factoryFunc, timeout), ttl)
+}
+
+// The Cache database. Can be initialized by either Open or OpenFile or OpenMem. Uses per Cache Locks.
+// Cache represents a generic cache database with key-value pairs.
+type Cache[K any, V any] struct {
+	*cache
+}
+
+var _ Cacher[any, any] = Cache[any, any]{}
+
+// The CacheRaw database. Can be initialized by either OpenRaw or OpenRawFile or OpenRawMem. Uses per Cache Locks.
+// CacheRaw represents a binary cache database with key-value pairs.
+type CacheRaw struct {
+	*cache
+}
+
+var _ Cacher[[]byte, []byte] = CacheRaw{}
+
+// OpenRaw opens a binary cache database with the specified options. If filename is empty then in-memory otherwise file backed.
+func OpenRaw(filename string, options ...Option) (CacheRaw, error) {
+	ret, err := open(filename, options...)
+	if err != nil {
+		return zero[CacheRaw](), err
+	}
+
+	ret.start()
+
+	return CacheRaw{cache: ret}, nil
+}
+
+// DumpJSON writes a human readable JSON dump of the cache for debugging.
+// Keys and values are base64 encoded. The dump cannot be loaded back.
+func (c CacheRaw) DumpJSON(w io.Writer) error {
+	if c.closed() {
+		return ErrClosed
+	}
+
+	return c.Store.DumpJSON(w)
+}
+
+// NextEvictionKey returns the key that would be evicted next under the current policy,
+// without evicting it. It returns false if the policy would not evict anything.
+// PolicyLRUTTL returns an expired key first, and PolicyLRUSample may return another
+// key on every call, since it samples the entries anew.
+func (c CacheRaw) NextEvictionKey() ([]byte, bool, error) {
+	if c.closed() {
+		return nil, false, ErrClosed
+	}
+
+	key, ok := c.Store.NextEviction()
+
+	return key, ok, nil
+}
+
+// TopBySize returns the n keys whose entries have the largest cost, largest first.
+func (c CacheRaw) TopBySize(n int) []KeyCost {
+	return c.Store.TopBySize(n)
+}
+
+var ErrEmptyFilename = errors.New("cannot open empty filename")
+
+// OpenRawFile opens a binary file-backed cache database with the specified options.
+func OpenRawFile(filename string, options ...Option) (CacheRaw, error) {
+	if filename == "" {
+		return zero[CacheRaw](), ErrEmptyFilename
+	}
+
+	return OpenRaw(filename, options...)
+}
+
+// OpenRawMem initializes a binary in-memory cache database with the specified options.
+func OpenRawMem(options ...Option) (CacheRaw, error) {
+	return OpenRaw("", options...)
+}
+
+// Open opens a cache database with the specified options. If filename is empty then in-memory otherwise file backed.
+func Open[K, V any](filename string, options ...Option) (Cache[K, V], error) {
+	ret, err := OpenRaw(filename, append([]Option{withUpgrade[K, V]()}, options...)...)
+	if err != nil {
+		return zero[Cache[K, V]](), err
+	}
+
+	return Cache[K, V]{cache: ret.cache}, nil
+}
+
+// OpenFile opens a file-backed cache database with the specified options.
+func OpenFile[K, V any](filename string, options ...Option) (Cache[K, V], error) {
+	if filename == "" {
+		return zero[Cache[K, V]](), ErrEmptyFilename
+	}
+
+	return Open[K, V](filename, options...)
+}
+
+// OpenMem initializes an in-memory cache database with the specified options.
+func OpenMem[K, V any](options ...Option) (Cache[K, V], error) {
+	return Open[K, V]("", options...)
+}
+
+// marshal serializes a value using msgpack. Strings and byte slices are already
+// bytes, so they are copied as is instead, saving the msgpack header and an allocation.
+func marshal[T any](v T) ([]byte, error) {
+	// Switching on a pointer matches the type parameter, not the dynamic type of an
+	// interface, so a string in an any is still encoded with msgpack.
+	switch v := any(&v).(type) {
+	case *[]byte:
+		return bytes.Clone(*v), nil
+	case *string:
+		return []byte(*v), nil
+	}
+
 	return msgpack.Marshal(v)
 }
 
-// unmarshal deserializes data into a value using msgpack.
-func unmarshal[T any](data []byte, v *T) error {
-	return msgpack.Unmarshal(data, v)
+// isRaw reports whether marshal stores values of type T as is.
+func isRaw[T any]() bool {
+	switch any(zero[T]()).(type) {
+	case []byte, string:
+		return true
+	}
+
+	return false
+}
+
+// upgradeRaw converts data encoded with msgpack, as snapshots older than rawRevision
+// store every type, to the encoding of marshal.
+func upgradeRaw[T any](data []byte) ([]byte, error) {
+	if !isRaw[T]() {
+		return data, nil
+	}
+
+	var v T
+	if err := msgpack.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+
+	return marshal(v)
+}
+
+// withUpgrade converts the string and []byte keys and values of snapshots older
+// than rawRevision, which encoded them with msgpack, when they are loaded.
+func withUpgrade[K, V any]() Option {
+	return func(d *cache) error {
+		if !isRaw[K]() && !isRaw[V]() {
+			return nil
+		}
+
+		d.Store.Upgrade = func(key, value []byte) ([]byte, []byte, error) {
+			key, err := upgradeRaw[K](key)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			value, err = upgradeRaw[V](value)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			return key, value, nil
+		}
+
+		return nil
+	}
+}
+
+// unmarshal deserializes data written by marshal into a value.
+func unmarshal[T any](data []byte, v *T) error {
+	switch v := any(v).(type) {
+	case *[]byte:
+		*v = bytes.Clone(data)
+
+		return nil
+	case *string:
+		*v = string(data)
+
+		return nil
+	}
+
+	return msgpack.Unmarshal(data, v)
+}
+
+// encodeKey applies the key normalizer, if any, and encodes the key canonically
+// or with the key function.
+func (c Cache[K, V]) encodeKey(key K) ([]byte, error) {
+	if c.KeyNormalizer != nil {
+		normalize, ok := c.KeyNormalizer.(func(K) K)
+		if !ok {
+			return nil, ErrKeyNormalizerType
+		}
+
+		key = normalize(key)
+	}
+
+	if c.KeyFunc != nil {
+		fn, ok := c.KeyFunc.(func(K) []byte)
+		if !ok {
+			return nil, ErrKeyFuncType
+		}
+
+		return bytes.Clone(fn(key)), nil
+	}
+
+	return marshalKey(key)
+}
+
+// decodeKey decodes a key written by encodeKey.
+func (c Cache[K, V]) decodeKey(data []byte, key *K) error {
+	if c.KeyFunc != nil {
+		return ErrKeyNotDecodable
+	}
+
+	return unmarshal(data, key)
+}
+
+// Get retrieves a value from the cache by key and returns its TTL.
+func (c Cache[K, V]) Get(key K, value *V) (time.Duration, error) {
+	keyData, err := c.encodeKey(key)
+	if err != nil {
+		return 0, err
+	}
+
+	v, ttl, err := c.cache.GetValue(keyData)
+	if errors.Is(err, ErrKeyNotFound) {
+		fallbackValue, ttl, err := readThrough(c.cache, key, c.setLocal)
+		if err != nil {
+			return 0, err
+		}
+
+		*value = fallbackValue
+
+		return ttl, nil
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	if v != nil {
+		if err = unmarshal(v, value); err != nil {
+			return 0, err
+		}
+	}
+
+	return ttl, err
+}
+
+// GetValue retrieves a value from the cache by key and returns the value and its TTL.
+func (c Cache[K, V]) GetValue(key K) (V, time.Duration, error) {
+	value := zero[V]()
+	ttl, err := c.Get(key, &value)
+
+	return value, ttl, err
+}
+
+// GetRaw returns a copy of the encoded value of a key and its TTL without decoding
+// it into V, for example to forward the cached payload verbatim. The bytes can be
+// decoded again with the same encoding the cache uses, msgpack for most types.
+// Misses are not read through to a fallback cache.
+func (c Cache[K, V]) GetRaw(key K) ([]byte, time.Duration, error) {
+	keyData, err := c.encodeKey(key)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	v, ttl, err := c.cache.GetValue(keyData)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return bytes.Clone(v), ttl, nil
+}
+
+// TTL returns the remaining time-to-live of a key without decoding its value.
+// It returns 0 for keys that never expire.
+func (c Cache[K, V]) TTL(key K) (time.Duration, error) {
+	keyData, err := c.encodeKey(key)
+	if err != nil {
+		return 0, err
+	}
+
+	return c.cache.TTL(keyData)
+}
+
+// GetMeta returns the access metadata of a key without fetching its value.
+func (c Cache[K, V]) GetMeta(key K) (Meta, error) {
+	keyData, err := c.encodeKey(key)
+	if err != nil {
+		return Meta{}, err
+	}
+
+	return c.cache.GetMeta(keyData)
+}
+
+// Touch resets the TTL of a key in place. Only the key is encoded, so unlike a Get
+// and Set round trip the value is never decoded or encoded again.
+// It returns ErrKeyNotFound for missing and expired keys.
+func (c Cache[K, V]) Touch(key K, ttl time.Duration) error {
+	keyData, err := c.encodeKey(key)
+	if err != nil {
+		return err
+	}
+
+	return c.cache.Touch(keyData, ttl)
+}
+
+// ExpireMulti resets the TTL of every present key and returns how many entries were
+// updated. Missing and expired keys are skipped. All keys are encoded before any
+// entry is changed, which happens under a single write lock.
+func (c Cache[K, V]) ExpireMulti(keys []K, ttl time.Duration) (int, error) {
+	raw := make([][]byte, 0, len(keys))
+
+	for _, key := range keys {
+		keyData, err := c.encodeKey(key)
+		if err != nil {
+			return 0, err
+		}
+
+		raw = append(raw, keyData)
+	}
+
+	return c.cache.ExpireMulti(raw, ttl)
+}
+
+// GetAllowStale is like GetValue, but returns an expired entry that has not been
+// cleaned up yet instead of ErrKeyNotFound, with stale set and a negative TTL.
+// This allows serving stale values while a backend is down. The entry is not removed,
+// and misses are not read through to a fallback cache.
+func (c Cache[K, V]) GetAllowStale(key K) (V, time.Duration, bool, error) {
+	keyData, err := c.encodeKey(key)
+	if err != nil {
+		return zero[V](), 0, false, err
+	}
+
+	data, ttl, stale, err := c.cache.GetAllowStale(keyData)
+	if err != nil {
+		return zero[V](), 0, false, err
+	}
+
+	var value V
+	if err := unmarshal(data, &value); err != nil {
+		return zero[V](), 0, false, err
+	}
+
+	return value, ttl, stale, nil
 }
 
-// Get retrieves a value from the cache by key and returns its TTL.
-func (c Cache[K, V]) Get(key K, value *V) (time.Duration, error) {
-	keyData, err := marshal(key)
+// GetMultiTTL retrieves the values and remaining TTLs of many keys under a single
+// read lock. Missing and expired keys are left out, and the rest keep the order of keys.
+// A map cannot be returned since K is not required to be comparable.
+// Unlike Get, misses are not read through to a fallback cache.
+func (c Cache[K, V]) GetMultiTTL(keys []K) ([]Entry[K, V], error) {
+	raw := make([][]byte, 0, len(keys))
+
+	// Keys derived by a key function are mapped back to the keys asked for.
+	var original map[string]K
+	if c.KeyFunc != nil {
+		original = make(map[string]K, len(keys))
+	}
+
+	for _, key := range keys {
+		keyData, err := c.encodeKey(key)
+		if err != nil {
+			return nil, err
+		}
+
+		raw = append(raw, keyData)
+
+		if original != nil {
+			original[string(keyData)] = key
+		}
+	}
+
+	found, err := c.cache.GetMultiTTL(raw)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	v, ttl, err := c.cache.GetValue(keyData)
+	entries := make([]Entry[K, V], 0, len(found))
+
+	for _, e := range found {
+		entry := Entry[K, V]{TTL: e.TTL}
+		if key, ok := original[string(e.Key)]; ok {
+			entry.Key = key
+		} else if err := c.decodeKey(e.Key, &entry.Key); err != nil {
+			return nil, err
+		}
+
+		if err := unmarshal(e.Value, &entry.Value); err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// GetBatch retrieves many keys under a single read lock. Unlike GetMultiTTL the result
+// is aligned index for index with keys, and misses are reported with Found unset.
+// Misses are not read through to a fallback cache.
+func (c Cache[K, V]) GetBatch(keys []K) ([]Result[V], error) {
+	raw := make([][]byte, 0, len(keys))
+
+	for _, key := range keys {
+		keyData, err := c.encodeKey(key)
+		if err != nil {
+			return nil, err
+		}
+
+		raw = append(raw, keyData)
+	}
+
+	found, err := c.cache.GetBatch(raw)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	if v != nil {
-		if err = unmarshal(v, value); err != nil {
-			return 0, err
+	results := make([]Result[V], len(found))
+
+	for i, r := range found {
+		if !r.Found {
+			continue
+		}
+
+		results[i] = Result[V]{TTL: r.TTL, Found: true}
+		if err := unmarshal(r.Value, &results[i].Value); err != nil {
+			return nil, err
 		}
 	}
 
-	return ttl, err
+	return results, nil
 }
 
-// GetValue retrieves a value from the cache by key and returns the value and its TTL.
-func (c Cache[K, V]) GetValue(key K) (V, time.Duration, error) {
-	value := zero[V]()
-	ttl, err := c.Get(key, &value)
+// HasMulti reports for each key whether it is present and not expired, without fetching
+// the values. The result is in the same order as keys.
+// All keys are encoded before any lookup, which happen under a single read lock.
+func (c Cache[K, V]) HasMulti(keys []K) ([]bool, error) {
+	raw := make([][]byte, 0, len(keys))
 
-	return value, ttl, err
+	for _, key := range keys {
+		keyData, err := c.encodeKey(key)
+		if err != nil {
+			return nil, err
+		}
+
+		raw = append(raw, keyData)
+	}
+
+	return c.cache.HasMulti(raw)
 }
 
-// Set adds a key-value pair to the cache with a specified TTL.
+// Set adds a key-value pair to the cache with a specified TTL,
+// and to the fallback cache if write-through is enabled.
 func (c Cache[K, V]) Set(key K, value V, ttl time.Duration) error {
-	keyData, err := marshal(key)
+	if err := c.setLocal(key, value, ttl); err != nil {
+		return err
+	}
+
+	return writeThrough(c.cache, key, value, ttl)
+}
+
+// setLocal adds a key-value pair to this cache only.
+func (c Cache[K, V]) setLocal(key K, value V, ttl time.Duration) error {
+	keyData, err := c.encodeKey(key)
 	if err != nil {
 		return err
 	}
@@ -404,9 +2280,365 @@ func (c Cache[K, V]) Set(key K, value V, ttl time.Duration) error {
 	return c.cache.Set(keyData, valueData, ttl)
 }
 
+// SetTagged adds a key-value pair to the cache like Set and labels it with tags,
+// so it can be deleted together with other entries by InvalidateTag.
+// The tags replace any tags the key had, and a plain Set removes them.
+func (c Cache[K, V]) SetTagged(key K, value V, ttl time.Duration, tags ...string) error {
+	keyData, err := c.encodeKey(key)
+	if err != nil {
+		return err
+	}
+
+	valueData, err := marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return c.cache.SetTagged(keyData, valueData, ttl, tags...)
+}
+
+// SetIfNewer adds or updates a key-value pair only if version is greater than the
+// version of the existing entry, and reports whether it was stored.
+// Equal versions are rejected, and entries written by Set have version 0.
+func (c Cache[K, V]) SetIfNewer(key K, value V, version uint64, ttl time.Duration) (bool, error) {
+	keyData, err := c.encodeKey(key)
+	if err != nil {
+		return false, err
+	}
+
+	valueData, err := marshal(value)
+	if err != nil {
+		return false, err
+	}
+
+	return c.cache.SetIfNewer(keyData, valueData, version, ttl)
+}
+
+// GetWithVersion retrieves a value with its TTL and generation. The generation is
+// assigned by the cache and grows on every write, unlike the version of SetIfNewer,
+// which is chosen by the caller. Pass it to SetIfVersion for optimistic concurrency.
+// Misses are not read through to a fallback cache.
+func (c Cache[K, V]) GetWithVersion(key K) (V, uint64, time.Duration, error) {
+	keyData, err := c.encodeKey(key)
+	if err != nil {
+		return zero[V](), 0, 0, err
+	}
+
+	data, generation, ttl, err := c.cache.GetWithVersion(keyData)
+	if err != nil {
+		return zero[V](), 0, 0, err
+	}
+
+	value := zero[V]()
+	if err := unmarshal(data, &value); err != nil {
+		return zero[V](), 0, 0, err
+	}
+
+	return value, generation, ttl, nil
+}
+
+// SetIfVersion stores a key-value pair only if the generation of the entry is still
+// expectedVersion, as returned by GetWithVersion, and reports whether it was stored.
+// Missing keys have generation 0, so passing 0 only stores new keys.
+func (c Cache[K, V]) SetIfVersion(key K, value V, expectedVersion uint64, ttl time.Duration) (bool, error) {
+	keyData, err := c.encodeKey(key)
+	if err != nil {
+		return false, err
+	}
+
+	valueData, err := marshal(value)
+	if err != nil {
+		return false, err
+	}
+
+	return c.cache.SetIfVersion(keyData, valueData, expectedVersion, ttl)
+}
+
+// SetEntries adds many key-value pairs to the cache, each with its own TTL.
+// All entries are encoded before any of them is stored.
+func (c Cache[K, V]) SetEntries(entries []Entry[K, V]) error {
+	raw := make([]Entry[[]byte, []byte], 0, len(entries))
+
+	for _, e := range entries {
+		keyData, err := c.encodeKey(e.Key)
+		if err != nil {
+			return err
+		}
+
+		valueData, err := marshal(e.Value)
+		if err != nil {
+			return err
+		}
+
+		raw = append(raw, Entry[[]byte, []byte]{Key: keyData, Value: valueData, TTL: e.TTL})
+	}
+
+	return c.cache.SetEntries(raw)
+}
+
+var ErrBatchLength = errors.New("keys and values differ in length") // ErrBatchLength is returned when a batch has a different number of keys and values.
+
+// WouldFit reports whether setting values under keys would keep the cache within its
+// max cost, counting the cost of the entries they overwrite as freed. It always
+// reports true without a max cost. The answer can change before the entries are
+// stored if other writes happen in between.
+func (c Cache[K, V]) WouldFit(keys []K, values []V) (bool, error) {
+	if len(keys) != len(values) {
+		return false, ErrBatchLength
+	}
+
+	rawKeys := make([][]byte, 0, len(keys))
+	rawValues := make([][]byte, 0, len(values))
+
+	for i, key := range keys {
+		keyData, err := c.encodeKey(key)
+		if err != nil {
+			return false, err
+		}
+
+		valueData, err := marshal(values[i])
+		if err != nil {
+			return false, err
+		}
+
+		rawKeys = append(rawKeys, keyData)
+		rawValues = append(rawValues, valueData)
+	}
+
+	return c.cache.WouldFit(rawKeys, rawValues)
+}
+
+// ReplaceAll atomically replaces the whole contents of the cache with entries.
+// Readers see either the old or the new contents, never an empty or partial cache.
+func (c Cache[K, V]) ReplaceAll(entries []Entry[K, V]) error {
+	raw := make([]Entry[[]byte, []byte], 0, len(entries))
+
+	for _, e := range entries {
+		keyData, err := c.encodeKey(e.Key)
+		if err != nil {
+			return err
+		}
+
+		valueData, err := marshal(e.Value)
+		if err != nil {
+			return err
+		}
+
+		raw = append(raw, Entry[[]byte, []byte]{Key: keyData, Value: valueData, TTL: e.TTL})
+	}
+
+	return c.cache.ReplaceAll(raw)
+}
+
+// Range calls fn for each entry in the cache until fn returns false.
+// The cache is read locked during iteration, so fn must not modify it.
+func (c Cache[K, V]) Range(fn func(key K, value V, ttl time.Duration) bool) error {
+	return c.RangeContext(context.Background(), fn)
+}
+
+// RangeContext is like Range but stops early and returns the context error once ctx is cancelled.
+func (c Cache[K, V]) RangeContext(ctx context.Context, fn func(key K, value V, ttl time.Duration) bool) error {
+	var err error
+
+	rangeErr := c.cache.RangeContext(ctx, func(keyData, valueData []byte, ttl time.Duration) bool {
+		var key K
+		if err = c.decodeKey(keyData, &key); err != nil {
+			return false
+		}
+
+		var value V
+		if err = unmarshal(valueData, &value); err != nil {
+			return false
+		}
+
+		return fn(key, value, ttl)
+	})
+	if rangeErr != nil {
+		return rangeErr
+	}
+
+	return err
+}
+
+// Scan returns a batch of at least count keys, unless the scan ends first, starting at
+// cursor, and the cursor to pass to the next call. Start with a cursor of 0; a
+// returned cursor of 0 means every bucket was visited. Keys present for the whole
+// scan are returned at least once, others may or may not be, and a key may be
+// returned more than once if the cache is resized between calls.
+func (c Cache[K, V]) Scan(cursor uint64, count int) ([]K, uint64, error) {
+	raw, next, err := c.cache.Scan(cursor, count)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	keys := make([]K, 0, len(raw))
+
+	for _, keyData := range raw {
+		var key K
+		if err := c.decodeKey(keyData, &key); err != nil {
+			return nil, 0, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, next, nil
+}
+
+// ScanMatch returns the keys whose string form, as printed by fmt.Sprint, matches the
+// glob pattern with path.Match semantics, such as "user:*". Expired entries are
+// skipped. It decodes every key under the read lock, so it takes O(n) time and is
+// meant for admin tasks rather than the request path.
+func (c Cache[K, V]) ScanMatch(pattern string) ([]K, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	var (
+		keys []K
+		err  error
+	)
+
+	rangeErr := c.cache.Range(func(keyData, _ []byte, _ time.Duration) bool {
+		var key K
+		if err = c.decodeKey(keyData, &key); err != nil {
+			return false
+		}
+
+		// The pattern is valid, so matching cannot fail.
+		if ok, _ := path.Match(pattern, fmt.Sprint(key)); ok {
+			keys = append(keys, key)
+		}
+
+		return true
+	})
+	if rangeErr != nil {
+		return nil, rangeErr
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// DecodeError reports an entry whose key or value could not be decoded.
+type DecodeError struct {
+	Key []byte
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("decode entry %q: %v", e.Key, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// RangeLenient is like Range but skips entries that cannot be decoded instead of stopping.
+// It returns a DecodeError for each skipped entry, joined with errors.Join.
+func (c Cache[K, V]) RangeLenient(fn func(key K, value V, ttl time.Duration) bool) error {
+	var errs []error
+
+	rangeErr := c.cache.Range(func(keyData, valueData []byte, ttl time.Duration) bool {
+		var key K
+		if err := c.decodeKey(keyData, &key); err != nil {
+			errs = append(errs, &DecodeError{Key: keyData, Err: err})
+			return true
+		}
+
+		var value V
+		if err := unmarshal(valueData, &value); err != nil {
+			errs = append(errs, &DecodeError{Key: keyData, Err: err})
+			return true
+		}
+
+		return fn(key, value, ttl)
+	})
+	if rangeErr != nil {
+		return rangeErr
+	}
+
+	return errors.Join(errs...)
+}
+
+// ParallelRange calls fn for each entry using the given number of goroutines, each
+// covering part of the hash table. The cache is read locked during iteration, so fn must
+// not modify it and must be safe for concurrent use. The first error from fn is returned.
+func (c Cache[K, V]) ParallelRange(workers int, fn func(key K, value V) error) error {
+	return c.cache.ParallelRange(workers, func(keyData, valueData []byte) error {
+		var key K
+		if err := c.decodeKey(keyData, &key); err != nil {
+			return err
+		}
+
+		var value V
+		if err := unmarshal(valueData, &value); err != nil {
+			return err
+		}
+
+		return fn(key, value)
+	})
+}
+
+// Snapshot returns a point-in-time copy of all live entries. The cache is only locked
+// while copying, and the entries are decoded afterwards, so unlike Range, iterating
+// the result does not block writers.
+func (c Cache[K, V]) Snapshot() ([]Entry[K, V], error) {
+	raw, err := c.cache.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry[K, V], 0, len(raw))
+
+	for _, e := range raw {
+		entry := Entry[K, V]{TTL: e.TTL}
+		if err := c.decodeKey(e.Key, &entry.Key); err != nil {
+			return nil, err
+		}
+
+		if err := unmarshal(e.Value, &entry.Value); err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// Expired returns the entries that have expired but have not been cleaned up yet,
+// without removing them.
+func (c Cache[K, V]) Expired() ([]KeyValue[K, V], error) {
+	raw, err := c.cache.Expired()
+	if err != nil {
+		return nil, err
+	}
+
+	expired := make([]KeyValue[K, V], 0, len(raw))
+
+	for _, e := range raw {
+		var kv KeyValue[K, V]
+		if err := c.decodeKey(e.Key, &kv.Key); err != nil {
+			return nil, err
+		}
+
+		if err := unmarshal(e.Value, &kv.Value); err != nil {
+			return nil, err
+		}
+
+		expired = append(expired, kv)
+	}
+
+	return expired, nil
+}
+
 // Delete removes a key-value pair from the cache.
 func (c Cache[K, V]) Delete(key K) error {
-	keyData, err := marshal(key)
+	keyData, err := c.encodeKey(key)
 	if err != nil {
 		return err
 	}
@@ -415,9 +2647,11 @@ func (c Cache[K, V]) Delete(key K) error {
 }
 
 // UpdateInPlace retrieves a value from the cache, processes it using the provided function,
-// and then sets the result back into the cache with the same key.
+// and then sets the result back into the cache with the same key. processFunc runs
+// without the cache lock and is called again if the key is written meanwhile, so it
+// may run more than once.
 func (c Cache[K, V]) UpdateInPlace(key K, processFunc func(V) (V, error), ttl time.Duration) error {
-	keyData, err := marshal(key)
+	keyData, err := c.encodeKey(key)
 	if err != nil {
 		return err
 	}
@@ -437,15 +2671,37 @@ func (c Cache[K, V]) UpdateInPlace(key K, processFunc func(V) (V, error), ttl ti
 	}, ttl)
 }
 
+// IncrementWithTTL atomically adds delta to the counter at key and returns the new
+// value. A missing or expired counter starts at 0 and gets ttl; an existing one keeps
+// its expiration unless WithIncrementRefreshTTL is set. V must be an integer type,
+// otherwise reading the counter with Get fails. ErrNotCounter is returned if the
+// stored value is not an integer.
+func (c Cache[K, V]) IncrementWithTTL(key K, delta int64, ttl time.Duration) (int64, error) {
+	keyData, err := c.encodeKey(key)
+	if err != nil {
+		return 0, err
+	}
+
+	return c.cache.IncrementWithTTL(keyData, delta, ttl)
+}
+
 // Memorize attempts to retrieve a value from the cache. If the retrieval fails,
 // it sets the result of the factory function into the cache and returns that result.
 func (c Cache[K, V]) Memorize(key K, factoryFunc func() (V, error), ttl time.Duration) (V, error) {
-	keyData, err := marshal(key)
+	value, _, err := c.MemorizeX(key, factoryFunc, ttl)
+
+	return value, err
+}
+
+// MemorizeX is like Memorize, but also reports whether the factory function ran
+// because of a miss (true) or the cached value was returned (false).
+func (c Cache[K, V]) MemorizeX(key K, factoryFunc func() (V, error), ttl time.Duration) (V, bool, error) {
+	keyData, err := c.encodeKey(key)
 	if err != nil {
-		return zero[V](), err
+		return zero[V](), false, err
 	}
 
-	data, err := c.cache.Memorize(keyData, func() ([]byte, error) {
+	data, computed, err := c.cache.MemorizeX(keyData, func() ([]byte, error) {
 		value, err := factoryFunc()
 		if err != nil {
 			return nil, err
@@ -454,13 +2710,20 @@ func (c Cache[K, V]) Memorize(key K, factoryFunc func() (V, error), ttl time.Dur
 		return marshal(value)
 	}, ttl)
 	if err != nil {
-		return zero[V](), err
+		return zero[V](), computed, err
 	}
 
 	var value V
 	if err := unmarshal(data, &value); err != nil {
-		return zero[V](), err
+		return zero[V](), computed, err
 	}
 
-	return value, nil
+	return value, computed, nil
+}
+
+// MemorizeTimeout is like Memorize but fails with ErrFactoryTimeout if the factory
+// does not finish within timeout. Nothing is cached in that case. The factory runs
+// without holding the cache lock, and its context is canceled on timeout.
+func (c Cache[K, V]) MemorizeTimeout(key K, factoryFunc func(ctx context.Context) (V, error), ttl, timeout time.Duration) (V, error) {
+	return c.Memorize(key, withTimeout(factoryFunc, timeout), ttl)
 }
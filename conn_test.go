@@ -1,10 +1,25 @@
 package cache
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
 )
 
 func setupTestCache[K, V any](tb testing.TB) *Cache[K, V] {
@@ -27,6 +42,7 @@ func setupTestCache[K, V any](tb testing.TB) *Cache[K, V] {
 func TestCacheSetConfig(t *testing.T) {
 	tests := []struct {
 		name            string
+		before          []Option
 		options         []Option
 		wantErr         bool
 		expectedPolicy  EvictionPolicyType
@@ -55,6 +71,64 @@ func TestCacheSetConfig(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "Policy without max cost returns error",
+			options: []Option{
+				WithPolicy(PolicyLRU),
+			},
+			wantErr: true,
+		},
+		{
+			name: "Policy with zero max cost returns error",
+			options: []Option{
+				WithPolicy(PolicyLRU),
+				WithMaxCost(0),
+			},
+			wantErr: true,
+		},
+		{
+			name: "Policy with unlimited",
+			options: []Option{
+				WithPolicy(PolicyLRU),
+				WithUnlimited(),
+			},
+			wantErr:        false,
+			expectedPolicy: PolicyLRU,
+		},
+		{
+			name: "Inverted TTL bounds returns error",
+			options: []Option{
+				WithTTLBounds(time.Hour, time.Minute),
+			},
+			wantErr: true,
+		},
+		{
+			name:   "Rejected config keeps the previous limits",
+			before: []Option{WithPolicy(PolicyLRU), WithMaxCost(1024)},
+			options: []Option{
+				WithMaxCost(0),
+				WithPolicy(PolicySLRU),
+				WithSampleSize(8),
+			},
+			wantErr: true,
+		},
+		{
+			name:   "Failed option keeps the previous limits",
+			before: []Option{WithPolicy(PolicyLRU), WithMaxCost(1024)},
+			options: []Option{
+				WithMaxCost(4096),
+				WithPolicy(PolicyFIFO),
+				WithSLRURatio(1.5),
+			},
+			wantErr: true,
+		},
+		{
+			name: "Invalid SLRU ratio returns error",
+			options: []Option{
+				WithSLRURatio(1.5),
+			},
+			wantErr: true,
+		},
 		{
 			name: "Set only max cost",
 			options: []Option{
@@ -79,11 +153,27 @@ func TestCacheSetConfig(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			c := setupTestCache[string, string](t)
 
+			if err := c.SetConfig(tt.before...); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			prev := c.saveLimits()
+
 			err := c.SetConfig(tt.options...)
 			if (err != nil) != tt.wantErr {
 				t.Fatalf("SetConfig() error = %v, wantErr = %v", err, tt.wantErr)
 			}
 
+			if tt.wantErr {
+				if got := c.saveLimits(); got != prev {
+					t.Errorf("Expected limits %+v to be restored, got %+v", prev, got)
+				}
+
+				if _, ok := c.Store.Policy.evictionStrategies.(lruPolicy); prev.Type == PolicyLRU && !ok {
+					t.Errorf("Expected the LRU strategy to be restored, got %T", c.Store.Policy.evictionStrategies)
+				}
+			}
+
 			if !tt.wantErr {
 				if c.Store.Policy.Type != tt.expectedPolicy {
 					t.Errorf("Expected policy %v, got %v", tt.expectedPolicy, c.Store.Policy.Type)
@@ -181,187 +271,3231 @@ func TestCacheGetSet(t *testing.T) {
 	})
 }
 
-func TestCacheDelete(t *testing.T) {
+// countingWriter is an in-memory io.WriteSeeker that counts writes.
+type countingWriter struct {
+	mu     sync.Mutex
+	writes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.writes++
+
+	return len(p), nil
+}
+
+func (w *countingWriter) Seek(offset int64, whence int) (int64, error) {
+	return 0, nil
+}
+
+func (w *countingWriter) Writes() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.writes
+}
+
+type syncingWriter struct {
+	countingWriter
+	syncs atomic.Int32
+}
+
+func (w *syncingWriter) Sync() error {
+	w.syncs.Add(1)
+
+	return nil
+}
+
+func TestCacheSync(t *testing.T) {
 	t.Parallel()
 
-	t.Run("Exists", func(t *testing.T) {
-		t.Parallel()
+	tests := []struct {
+		name    string
+		options []Option
+		fn      func(c *cache) error
+		want    int32
+	}{
+		{name: "Flush", fn: (*cache).Flush, want: 0},
+		{name: "Sync", fn: (*cache).Sync, want: 1},
+		{name: "SyncOnSnapshot", options: []Option{WithSyncOnSnapshot()}, fn: (*cache).Flush, want: 1},
+	}
 
-		db := setupTestCache[string, string](t)
-		want := "Value"
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
 
-		if err := db.Set("Key", want, 0); err != nil {
+			c, err := open("", tt.options...)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			w := &syncingWriter{}
+			c.File = w
+
+			if err := tt.fn(c); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if w.Writes() == 0 {
+				t.Fatalf("expected the snapshot to be written")
+			}
+
+			if got := w.syncs.Load(); got != tt.want {
+				t.Fatalf("expected %d syncs, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCacheReset(t *testing.T) {
+	t.Parallel()
+
+	db, err := OpenMem[string, string](
+		WithPolicy(PolicyLRU),
+		WithMaxCost(1000),
+		WithMaxAge(time.Hour),
+		SetSnapshotTime(time.Minute),
+		SetCleanupTime(time.Minute),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
+	})
 
-		if err := db.Delete("Key"); err != nil {
+	if err := db.Set("Key", "Value", 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	db.setErr(errors.New("failure"))
+
+	if err := db.Reset(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if db.Store.Policy.Type != PolicyNone {
+		t.Errorf("expected policy %v, got %v", PolicyNone, db.Store.Policy.Type)
+	}
+
+	if db.Store.MaxCost != 0 || db.Store.MaxAge != 0 {
+		t.Errorf("expected no limits, got MaxCost %d and MaxAge %v", db.Store.MaxCost, db.Store.MaxAge)
+	}
+
+	if got := db.Store.SnapshotTicker.GetDuration(); got != 0 {
+		t.Errorf("expected SnapshotTime %v, got %v", 0, got)
+	}
+
+	if got := db.Store.CleanupTicker.GetDuration(); got != DefaultCleanupInterval {
+		t.Errorf("expected CleanupTime %v, got %v", DefaultCleanupInterval, got)
+	}
+
+	if db.Store.Length != 0 || db.Cost() != 0 {
+		t.Errorf("expected empty store, got length %d and cost %d", db.Store.Length, db.Cost())
+	}
+
+	if _, _, err := db.GetValue("Key"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected error: %v, got: %v", ErrKeyNotFound, err)
+	}
+
+	t.Run("Panic", func(t *testing.T) {
+		t.Parallel()
+
+		db, err := OpenMem[string, string](WithPolicy(PolicyLRU), WithMaxCost(1000))
+		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
 
-		if _, _, err := db.GetValue("Key"); !errors.Is(err, ErrKeyNotFound) {
-			t.Fatalf("expected error: %v, got: %v", ErrKeyNotFound, err)
+		t.Cleanup(func() {
+			if err := db.Close(); err != nil && !errors.Is(err, ErrPanic) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+
+		if err := db.Set("Key", "Value", 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
 		}
-	})
 
-	t.Run("Not Exists", func(t *testing.T) {
-		t.Parallel()
+		// The background worker has stopped, so the error must stay visible.
+		db.setErr(fmt.Errorf("%w: %v", ErrPanic, "boom"))
 
-		db := setupTestCache[string, string](t)
+		if err := db.Reset(); !errors.Is(err, ErrPanic) {
+			t.Fatalf("expected error: %v, got: %v", ErrPanic, err)
+		}
 
-		if err := db.Delete("Key"); !errors.Is(err, ErrKeyNotFound) {
-			t.Fatalf("expected error: %v, got: %v", ErrKeyNotFound, err)
+		if err := db.Error(); !errors.Is(err, ErrPanic) {
+			t.Fatalf("expected error: %v, got: %v", ErrPanic, err)
+		}
+
+		if db.Store.Length != 1 || db.Store.Policy.Type != PolicyLRU {
+			t.Fatalf("expected the cache to be unchanged, got length %d and %v", db.Store.Length, db.Store.Policy.Type)
 		}
 	})
 }
 
-func TestCacheUpdateInPlace(t *testing.T) {
-	t.Parallel()
+// TestCacheDefaultIntervals changes package state, so it must not run in parallel.
+func TestCacheDefaultIntervals(t *testing.T) {
+	cleanup, snapshot := DefaultCleanupInterval, DefaultSnapshotInterval
+	t.Cleanup(func() {
+		DefaultCleanupInterval, DefaultSnapshotInterval = cleanup, snapshot
+	})
 
-	t.Run("Exists", func(t *testing.T) {
-		t.Parallel()
+	DefaultCleanupInterval = 50 * time.Millisecond
+	DefaultSnapshotInterval = time.Minute
 
-		store := setupTestCache[string, string](t)
+	db, err := OpenMem[string, string]()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer db.Close()
 
-		want := "Value"
+	if got := db.Store.CleanupTicker.GetDuration(); got != DefaultCleanupInterval {
+		t.Errorf("expected CleanupTime %v, got %v", DefaultCleanupInterval, got)
+	}
 
-		if err := store.Set("Key", "Initial", 1*time.Hour); err != nil {
-			t.Fatalf("unexpected error: %v", err)
-		}
+	if got := db.Store.SnapshotTicker.GetDuration(); got != DefaultSnapshotInterval {
+		t.Errorf("expected SnapshotTime %v, got %v", DefaultSnapshotInterval, got)
+	}
 
-		processFunc := func(v string) (string, error) {
-			return want, nil
+	if err := db.Set("Key", "Value", 10*time.Millisecond); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	db.Store.Lock.RLock()
+	length := db.Store.Length
+	db.Store.Lock.RUnlock()
+
+	if length != 0 {
+		t.Errorf("expected expired entry to be cleaned up, got length %d", length)
+	}
+}
+
+func TestCacheHealthy(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Healthy", func(t *testing.T) {
+		t.Parallel()
+
+		db, err := OpenFile[string, string](filepath.Join(t.TempDir(), "cache.db"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
 		}
 
-		if err := store.UpdateInPlace("Key", processFunc, 1*time.Hour); err != nil {
+		if err := db.Healthy(); err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
 
-		got, _, err := store.GetValue("Key")
-		if err != nil {
+		if err := db.Close(); err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
 
-		if want != got {
-			t.Errorf("got %v, want %v", got, want)
+		if err := db.Healthy(); !errors.Is(err, ErrClosed) {
+			t.Fatalf("expected error: %v, got: %v", ErrClosed, err)
 		}
 	})
 
-	t.Run("Not Exists", func(t *testing.T) {
+	t.Run("Error", func(t *testing.T) {
 		t.Parallel()
 
-		store := setupTestCache[string, string](t)
-
-		want := "Value"
+		db := setupTestCache[string, string](t)
 
-		processFunc := func(v string) (string, error) {
-			return want, nil
-		}
+		want := errors.New("flush failed")
+		db.setErr(want)
 
-		if err := store.UpdateInPlace("Key", processFunc, 1*time.Hour); !errors.Is(err, ErrKeyNotFound) {
-			t.Fatalf("expected error: %v, got: %v", ErrKeyNotFound, err)
+		if err := db.Healthy(); !errors.Is(err, want) {
+			t.Fatalf("expected error: %v, got: %v", want, err)
 		}
 	})
 }
 
-func TestCacheMemoize(t *testing.T) {
+func TestCacheClearError(t *testing.T) {
 	t.Parallel()
 
-	t.Run("Cache Miss", func(t *testing.T) {
+	t.Run("Transient", func(t *testing.T) {
 		t.Parallel()
 
-		store := setupTestCache[string, string](t)
+		db := setupTestCache[string, string](t)
 
-		want := "Value"
+		db.setErr(errors.New("flush failed"))
 
-		factoryFunc := func() (string, error) {
-			return want, nil
+		if err := db.Set("Key", "Value", 0); err == nil {
+			t.Fatalf("expected error")
 		}
 
-		got, err := store.Memorize("Key", factoryFunc, 1*time.Hour)
-		if err != nil {
+		if err := db.ClearError(); err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
 
-		if got != "Value" {
-			t.Fatalf("expected: %v, got: %v", "Value", got)
-		}
-
-		got, _, err = store.GetValue("Key")
-		if err != nil {
+		if err := db.Set("Key", "Value", 0); err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
 
-		if want != got {
-			t.Errorf("got %v, want %v", got, want)
+		if _, _, err := db.GetValue("Key"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
 		}
 	})
 
-	t.Run("Cache Hit", func(t *testing.T) {
+	t.Run("Panic", func(t *testing.T) {
 		t.Parallel()
 
-		store := setupTestCache[string, string](t)
+		db := setupTestCache[string, string](t)
 
-		want := "NewValue"
+		db.setErr(fmt.Errorf("%w: %v", ErrPanic, "boom"))
 
-		if err := store.Set("Key", "Value", 1*time.Hour); err != nil {
-			t.Fatalf("unexpected error: %v", err)
+		if err := db.ClearError(); !errors.Is(err, ErrPanic) {
+			t.Fatalf("expected error: %v, got: %v", ErrPanic, err)
 		}
 
-		factoryFunc := func() (string, error) {
-			return want, nil
+		if err := db.Error(); !errors.Is(err, ErrPanic) {
+			t.Fatalf("expected error: %v, got: %v", ErrPanic, err)
 		}
+	})
+}
 
-		got, err := store.Memorize("Key", factoryFunc, 1*time.Hour)
-		if err != nil {
+func TestCacheSetMaxCost(t *testing.T) {
+	t.Parallel()
+
+	db, err := OpenRawMem(WithPolicy(PolicyFIFO), WithUnlimited())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
+	})
 
-		if got != "Value" {
-			t.Fatalf("expected: %v, got: %v", "Value", got)
+	for i := range 10 {
+		key := []byte(strconv.Itoa(i))
+		if err := db.Set(key, key, 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
 		}
-	})
-}
+	}
 
-func BenchmarkCacheGet(b *testing.B) {
-	for n := 1; n <= 100000; n *= 10 {
-		b.Run(strconv.Itoa(n), func(b *testing.B) {
-			db := setupTestCache[int, int](b)
-			for i := range n {
-				if err := db.Set(i, i, 0); err != nil {
-					b.Fatalf("unexpected error: %v", err)
-				}
-			}
+	if got := db.Cost(); got != 20 {
+		t.Fatalf("expected cost %d, got %d", 20, got)
+	}
 
-			b.ReportAllocs()
+	if err := db.SetMaxCost(8); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
 
-			for b.Loop() {
-				if _, _, err := db.GetValue(n - 1); err != nil {
-					b.Fatalf("unexpected error: %v", err)
-				}
-			}
-		})
+	if got := db.MaxCost(); got != 8 {
+		t.Fatalf("expected max cost %d, got %d", 8, got)
 	}
-}
 
-func BenchmarkCacheSet(b *testing.B) {
-	for n := 1; n <= 100000; n *= 10 {
-		b.Run(strconv.Itoa(n), func(b *testing.B) {
-			db := setupTestCache[int, int](b)
-			for i := range n - 1 {
-				if err := db.Set(i, i, 0); err != nil {
-					b.Fatalf("unexpected error: %v", err)
-				}
-			}
+	if got := db.Cost(); got > 8 {
+		t.Fatalf("expected cost at most %d, got %d", 8, got)
+	}
 
-			b.ReportAllocs()
+	// FIFO evicts the oldest entries first
+	if _, _, err := db.GetValue([]byte("0")); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected error: %v, got: %v", ErrKeyNotFound, err)
+	}
 
-			for b.Loop() {
-				if err := db.Set(n, n, 0); err != nil {
-					b.Fatalf("unexpected error: %v", err)
-				}
-			}
-		})
+	if _, _, err := db.GetValue([]byte("9")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
 	}
+
+	t.Run("Zero", func(t *testing.T) {
+		t.Parallel()
+
+		db, err := OpenRawMem(WithPolicy(PolicyLRU), WithMaxCost(100))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		t.Cleanup(func() {
+			if err := db.Close(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+
+		if err := db.SetMaxCost(0); !errors.Is(err, ErrNoMaxCost) {
+			t.Fatalf("expected error: %v, got: %v", ErrNoMaxCost, err)
+		}
+
+		if got := db.MaxCost(); got != 100 {
+			t.Fatalf("expected max cost %d, got %d", 100, got)
+		}
+	})
+}
+
+func TestCacheUnlimited(t *testing.T) {
+	t.Parallel()
+
+	db, err := OpenRawMem(WithPolicy(PolicyLRU), WithUnlimited())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	for i := range 100 {
+		key := []byte(strconv.Itoa(i))
+		if err := db.Set(key, key, 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	db.Store.Evict()
+
+	if db.Store.Length != 100 {
+		t.Fatalf("expected no evictions, got %d entries", db.Store.Length)
+	}
+
+	if _, err := OpenRawMem(WithPolicy(PolicyLRU)); !errors.Is(err, ErrNoMaxCost) {
+		t.Fatalf("expected error: %v, got %v", ErrNoMaxCost, err)
+	}
+}
+
+func TestCacheSnapshotDirty(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Idle", func(t *testing.T) {
+		t.Parallel()
+
+		c, err := open("", SetSnapshotTime(10*time.Millisecond))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		w := &countingWriter{}
+		c.File = w
+		c.start()
+
+		t.Cleanup(func() {
+			if err := c.Close(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+
+		time.Sleep(50 * time.Millisecond)
+
+		if got := w.Writes(); got != 0 {
+			t.Fatalf("expected no writes while idle, got %d", got)
+		}
+
+		if err := c.Set([]byte("Key"), []byte("Value"), 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		time.Sleep(50 * time.Millisecond)
+
+		written := w.Writes()
+		if written == 0 {
+			t.Fatalf("expected a write after a change")
+		}
+
+		time.Sleep(50 * time.Millisecond)
+
+		if got := w.Writes(); got != written {
+			t.Fatalf("expected no writes while idle, got %d", got-written)
+		}
+	})
+
+	t.Run("Forced", func(t *testing.T) {
+		t.Parallel()
+
+		c, err := open("", WithForceSnapshotInterval(10*time.Millisecond))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		w := &countingWriter{}
+		c.File = w
+		c.start()
+
+		t.Cleanup(func() {
+			if err := c.Close(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+
+		time.Sleep(50 * time.Millisecond)
+
+		if got := w.Writes(); got == 0 {
+			t.Fatalf("expected forced writes while idle")
+		}
+	})
+}
+
+// flakyWriter is an in-memory io.WriteSeeker whose first writes fail.
+type flakyWriter struct {
+	mu     sync.Mutex
+	fails  int
+	writes int
+}
+
+func (w *flakyWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.fails != 0 {
+		w.fails--
+		return 0, errors.New("no space left on device")
+	}
+
+	w.writes++
+
+	return len(p), nil
+}
+
+func (w *flakyWriter) Seek(offset int64, whence int) (int64, error) {
+	return 0, nil
+}
+
+func (w *flakyWriter) Writes() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.writes
+}
+
+func TestCacheConcurrentCleanup(t *testing.T) {
+	t.Parallel()
+
+	// Every expired entry takes 2ms to clean up, so a full pass takes about 400ms.
+	slow := WithOnEvict(func(key, value []byte, reason EvictionReason) {
+		time.Sleep(2 * time.Millisecond)
+	})
+
+	tests := []struct {
+		name    string
+		options []Option
+		want    bool
+	}{
+		{"Sequential", nil, false},
+		{"Concurrent", []Option{WithConcurrentCleanup(1)}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			options := append([]Option{slow, WithForceSnapshotInterval(10 * time.Millisecond)}, tt.options...)
+
+			c, err := open("", options...)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			for i := range 200 {
+				if err := c.Set([]byte(strconv.Itoa(i)), []byte("Value"), time.Millisecond); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			time.Sleep(10 * time.Millisecond)
+
+			w := &countingWriter{}
+			c.File = w
+			c.start()
+
+			t.Cleanup(func() {
+				if err := c.Close(); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			})
+
+			time.Sleep(100 * time.Millisecond)
+
+			if got := w.Writes() > 0; got != tt.want {
+				t.Fatalf("expected snapshots during cleanup: %v, got %d writes", tt.want, w.Writes())
+			}
+		})
+	}
+}
+
+func TestCacheSnapshotJitter(t *testing.T) {
+	t.Parallel()
+
+	start := func(t *testing.T, phase time.Duration) *countingWriter {
+		t.Helper()
+
+		c, err := open("", SetSnapshotTime(20*time.Millisecond), WithSnapshotJitter(time.Second))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		c.jitter = func(time.Duration) time.Duration {
+			return phase
+		}
+
+		w := &countingWriter{}
+		c.File = w
+
+		if err := c.Set([]byte("Key"), []byte("Value"), 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		c.start()
+
+		t.Cleanup(func() {
+			if err := c.Close(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+
+		return w
+	}
+
+	early := start(t, 0)
+	late := start(t, 200*time.Millisecond)
+
+	time.Sleep(100 * time.Millisecond)
+
+	if early.Writes() == 0 {
+		t.Fatalf("expected the cache without phase to have snapshotted")
+	}
+
+	if got := late.Writes(); got != 0 {
+		t.Fatalf("expected no snapshot before the phase passed, got %d writes", got)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	if late.Writes() == 0 {
+		t.Fatalf("expected a snapshot after the phase passed")
+	}
+}
+
+func TestCacheSnapshotIntervals(t *testing.T) {
+	t.Parallel()
+
+	for name, format := range map[string]SnapshotFormat{"Binary": FormatBinary, "Msgpack": FormatMsgpack} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(t.TempDir(), "cache.db")
+
+			db, err := OpenRawFile(path,
+				WithSnapshotFormat(format),
+				SetSnapshotTime(time.Hour),
+				WithForceSnapshotInterval(2*time.Hour),
+				SetCleanupTime(time.Minute),
+			)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if err := db.Close(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			db, err = OpenRawFile(path)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			t.Cleanup(func() {
+				if err := db.Close(); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			})
+
+			tests := []struct {
+				name string
+				got  time.Duration
+				want time.Duration
+			}{
+				{"Snapshot", db.Store.SnapshotTicker.GetDuration(), time.Hour},
+				{"Force", db.Store.ForceTicker.GetDuration(), 2 * time.Hour},
+				{"Cleanup", db.Store.CleanupTicker.GetDuration(), time.Minute},
+			}
+
+			for _, tt := range tests {
+				if tt.got != tt.want {
+					t.Errorf("%s: expected %v, got %v", tt.name, tt.want, tt.got)
+				}
+			}
+		})
+	}
+}
+
+func TestCacheSnapshotFailure(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Recovers", func(t *testing.T) {
+		t.Parallel()
+
+		c, err := open("", SetSnapshotTime(10*time.Millisecond), WithSnapshotFailureThreshold(5))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		w := &flakyWriter{fails: 2}
+		c.File = w
+		c.start()
+
+		t.Cleanup(func() {
+			if err := c.Close(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+
+		if err := c.Set([]byte("Key"), []byte("Value"), 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		time.Sleep(100 * time.Millisecond)
+
+		if err := c.Error(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if _, _, err := c.GetValue([]byte("Key")); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if w.Writes() == 0 {
+			t.Fatalf("expected the snapshot to be persisted eventually")
+		}
+
+		if err := c.SnapshotError(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("Escalates", func(t *testing.T) {
+		t.Parallel()
+
+		c, err := open("", SetSnapshotTime(10*time.Millisecond), WithSnapshotFailureThreshold(2))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		w := &flakyWriter{fails: 1000}
+		c.File = w
+		c.start()
+
+		if err := c.Set([]byte("Key"), []byte("Value"), 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		time.Sleep(100 * time.Millisecond)
+
+		if err := c.Error(); err == nil {
+			t.Fatalf("expected error after repeated failures")
+		}
+
+		if err := c.Close(); err == nil {
+			t.Fatalf("expected error on close")
+		}
+	})
+}
+
+func TestCacheDeferInitialSnapshot(t *testing.T) {
+	t.Parallel()
+
+	filename := filepath.Join(t.TempDir(), "cache.db")
+
+	db, err := OpenFile[string, string](filename, WithDeferInitialSnapshot(), SetSnapshotTime(10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	size := func() int64 {
+		t.Helper()
+
+		info, err := os.Stat(filename)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		return info.Size()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	if got := size(); got != 0 {
+		t.Fatalf("expected an empty file before the first write, got %d bytes", got)
+	}
+
+	if err := db.Set("Key", "Value", 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := db.Flush(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := size(); got == 0 {
+		t.Fatalf("expected a snapshot after the first write")
+	}
+}
+
+func TestCacheOpenTimeout(t *testing.T) {
+	t.Parallel()
+
+	filename := filepath.Join(t.TempDir(), "cache.db")
+
+	db, err := OpenFile[string, string](filename)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	if _, err := OpenFile[string, string](filename, WithOpenTimeout(50*time.Millisecond)); !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected error: %v, got: %v", ErrLocked, err)
+	}
+}
+
+func TestCacheHooks(t *testing.T) {
+	t.Parallel()
+
+	type setCall struct {
+		key, value string
+		ttl        time.Duration
+	}
+
+	type getCall struct {
+		key string
+		hit bool
+	}
+
+	var sets []setCall
+
+	var gets []getCall
+
+	db, err := OpenRawMem(
+		WithOnSet(func(key, value []byte, ttl time.Duration) {
+			sets = append(sets, setCall{string(key), string(value), ttl})
+		}),
+		WithOnGet(func(key []byte, hit bool) {
+			gets = append(gets, getCall{string(key), hit})
+		}),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	if err := db.Set([]byte("Key"), []byte("Value"), time.Hour); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, _, err := db.GetValue([]byte("Key")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, _, err := db.GetValue([]byte("Missing")); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected error: %v, got: %v", ErrKeyNotFound, err)
+	}
+
+	wantSets := []setCall{{"Key", "Value", time.Hour}}
+	if !slices.Equal(sets, wantSets) {
+		t.Errorf("expected %v, got %v", wantSets, sets)
+	}
+
+	wantGets := []getCall{{"Key", true}, {"Missing", false}}
+	if !slices.Equal(gets, wantGets) {
+		t.Errorf("expected %v, got %v", wantGets, gets)
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	t.Parallel()
+
+	db := setupTestCache[string, string](t)
+
+	if err := db.Set("Immortal", "Value", 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := db.Set("Timed", "Value", time.Hour); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		key     string
+		wantMin time.Duration
+		wantMax time.Duration
+		wantErr error
+	}{
+		{name: "Immortal", key: "Immortal"},
+		{name: "Timed", key: "Timed", wantMin: time.Hour - time.Minute, wantMax: time.Hour},
+		{name: "Missing", key: "Missing", wantErr: ErrKeyNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ttl, err := db.TTL(tt.key)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error: %v, got %v", tt.wantErr, err)
+			}
+
+			if ttl < tt.wantMin || ttl > tt.wantMax {
+				t.Fatalf("expected TTL between %v and %v, got %v", tt.wantMin, tt.wantMax, ttl)
+			}
+		})
+	}
+}
+
+func TestCacheIncrementWithTTL(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Window", func(t *testing.T) {
+		t.Parallel()
+
+		db := setupTestCache[string, int](t)
+
+		n, err := db.IncrementWithTTL("Key", 1, time.Minute)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if n != 1 {
+			t.Errorf("expected 1, got %d", n)
+		}
+
+		n, err = db.IncrementWithTTL("Key", 2, time.Hour)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if n != 3 {
+			t.Errorf("expected 3, got %d", n)
+		}
+
+		value, ttl, err := db.GetValue("Key")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if value != 3 {
+			t.Errorf("expected 3, got %d", value)
+		}
+
+		if ttl > time.Minute || ttl < time.Minute-time.Second {
+			t.Errorf("expected the first TTL of %v to be kept, got %v", time.Minute, ttl)
+		}
+	})
+
+	t.Run("Refresh", func(t *testing.T) {
+		t.Parallel()
+
+		db := setupTestCache[string, int](t)
+		if err := db.SetConfig(WithIncrementRefreshTTL()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if _, err := db.IncrementWithTTL("Key", 1, time.Minute); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if _, err := db.IncrementWithTTL("Key", 1, time.Hour); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		ttl, err := db.TTL("Key")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if ttl <= time.Minute {
+			t.Errorf("expected TTL to be reset to %v, got %v", time.Hour, ttl)
+		}
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		t.Parallel()
+
+		db := setupTestCache[string, int](t)
+
+		if _, err := db.IncrementWithTTL("Key", 5, 50*time.Millisecond); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		time.Sleep(100 * time.Millisecond)
+
+		n, err := db.IncrementWithTTL("Key", 1, time.Minute)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if n != 1 {
+			t.Errorf("expected a new window starting at 1, got %d", n)
+		}
+	})
+
+	t.Run("NotCounter", func(t *testing.T) {
+		t.Parallel()
+
+		db := setupTestCache[string, string](t)
+		if err := db.Set("Key", "Value", 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if _, err := db.IncrementWithTTL("Key", 1, 0); !errors.Is(err, ErrNotCounter) {
+			t.Fatalf("expected error: %v, got %v", ErrNotCounter, err)
+		}
+	})
+}
+
+func TestCacheGetRaw(t *testing.T) {
+	t.Parallel()
+
+	type record struct {
+		Name string
+		Tags []string
+	}
+
+	db := setupTestCache[string, record](t)
+
+	want := record{Name: "Name", Tags: []string{"A", "B"}}
+	if err := db.Set("Key", want, time.Hour); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, ttl, err := db.GetRaw("Key")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if ttl <= 0 || ttl > time.Hour {
+		t.Errorf("expected TTL of at most an hour, got %v", ttl)
+	}
+
+	var got record
+	if err := msgpack.Unmarshal(data, &got); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+
+	// The result is a copy, so changing it must not affect the cache.
+	clear(data)
+
+	value, _, err := db.GetValue("Key")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !reflect.DeepEqual(value, want) {
+		t.Errorf("expected %+v, got %+v", want, value)
+	}
+
+	if _, _, err := db.GetRaw("Missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected error: %v, got %v", ErrKeyNotFound, err)
+	}
+}
+
+func TestCacheEmptyKey(t *testing.T) {
+	t.Parallel()
+
+	formats := map[string]SnapshotFormat{"Binary": FormatBinary, "Msgpack": FormatMsgpack}
+
+	for name, format := range formats {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			db, err := OpenRawMem(WithSnapshotFormat(format))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			t.Cleanup(func() {
+				if err := db.Close(); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			})
+
+			if err := db.Set([]byte{}, []byte("Value"), 0); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if err := db.Set([]byte("Key"), []byte("Other"), 0); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			check := func(want string) {
+				t.Helper()
+
+				var got []byte
+				if _, err := db.Get(nil, &got); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				if string(got) != want {
+					t.Fatalf("expected %q, got %q", want, got)
+				}
+			}
+
+			check("Value")
+
+			data, err := db.SnapshotBytes()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if err := db.Delete([]byte{}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if _, err := db.Get([]byte{}, new([]byte)); !errors.Is(err, ErrKeyNotFound) {
+				t.Fatalf("expected error: %v, got %v", ErrKeyNotFound, err)
+			}
+
+			if err := db.LoadSnapshotBytes(data); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			check("Value")
+
+			entries, err := db.Snapshot()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(entries) != 2 {
+				t.Fatalf("expected 2 entries, got %d", len(entries))
+			}
+		})
+	}
+}
+
+func TestCacheGetBatch(t *testing.T) {
+	t.Parallel()
+
+	db := setupTestCache[string, int](t)
+
+	for i, key := range []string{"A", "C"} {
+		if err := db.Set(key, i+1, time.Hour); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	keys := []string{"C", "B", "A", "C", "D"}
+
+	got, err := db.GetBatch(keys)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []Result[int]{
+		{Value: 2, Found: true},
+		{},
+		{Value: 1, Found: true},
+		{Value: 2, Found: true},
+		{},
+	}
+
+	if len(got) != len(keys) {
+		t.Fatalf("expected %d results, got %d", len(keys), len(got))
+	}
+
+	for i, r := range got {
+		if r.Found != want[i].Found || r.Value != want[i].Value {
+			t.Errorf("result %d for %q: expected %+v, got %+v", i, keys[i], want[i], r)
+		}
+
+		if r.Found && (r.TTL <= 0 || r.TTL > time.Hour) {
+			t.Errorf("result %d for %q: expected TTL of at most an hour, got %v", i, keys[i], r.TTL)
+		}
+
+		if !r.Found && r.TTL != 0 {
+			t.Errorf("result %d for %q: expected no TTL for a miss, got %v", i, keys[i], r.TTL)
+		}
+	}
+}
+
+// countedValue counts how often it is encoded and decoded with msgpack.
+type countedValue struct {
+	Value string
+}
+
+var countedEncodes, countedDecodes atomic.Int64
+
+func (v countedValue) EncodeMsgpack(enc *msgpack.Encoder) error {
+	countedEncodes.Add(1)
+
+	return enc.EncodeString(v.Value)
+}
+
+func (v *countedValue) DecodeMsgpack(dec *msgpack.Decoder) error {
+	countedDecodes.Add(1)
+
+	s, err := dec.DecodeString()
+	v.Value = s
+
+	return err
+}
+
+func TestCacheSetIfVersion(t *testing.T) {
+	t.Parallel()
+
+	db := setupTestCache[string, string](t)
+
+	if ok, err := db.SetIfVersion("Key", "First", 0, 0); err != nil || !ok {
+		t.Fatalf("expected a new key to be stored with version 0, got %v, %v", ok, err)
+	}
+
+	value, version, _, err := db.GetWithVersion("Key")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if value != "First" || version == 0 {
+		t.Fatalf("expected %q with a version, got %q with version %d", "First", value, version)
+	}
+
+	tests := []struct {
+		name    string
+		version uint64
+		want    bool
+	}{
+		{"Stale", version - 1, false},
+		{"Ahead", version + 1, false},
+		{"New", 0, false},
+		{"Match", version, true},
+		{"Reused", version, false},
+	}
+
+	for _, tt := range tests {
+		ok, err := db.SetIfVersion("Key", tt.name, tt.version, 0)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+
+		if ok != tt.want {
+			t.Errorf("%s: expected stored %v, got %v", tt.name, tt.want, ok)
+		}
+	}
+
+	value, next, _, err := db.GetWithVersion("Key")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if value != "Match" || next <= version {
+		t.Fatalf("expected %q with a version above %d, got %q with version %d", "Match", version, value, next)
+	}
+
+	// A deleted and recreated key must not reuse an old version.
+	if err := db.Delete("Key"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := db.Set("Key", "Recreated", 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, recreated, _, _ := db.GetWithVersion("Key")
+	if recreated <= next {
+		t.Fatalf("expected a version above %d, got %d", next, recreated)
+	}
+
+	// Replacing the whole cache must not reuse a version either.
+	if err := db.ReplaceAll([]Entry[string, string]{{Key: "Key", Value: "Replaced"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if ok, err := db.SetIfVersion("Key", "Stale", recreated, 0); err != nil || ok {
+		t.Fatalf("expected a version read before ReplaceAll to be rejected, got %v, %v", ok, err)
+	}
+
+	if _, replaced, _, _ := db.GetWithVersion("Key"); replaced <= recreated {
+		t.Fatalf("expected a version above %d, got %d", recreated, replaced)
+	}
+
+	if _, _, _, err := db.GetWithVersion("Missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected error: %v, got %v", ErrKeyNotFound, err)
+	}
+}
+
+func TestCacheTouch(t *testing.T) {
+	t.Parallel()
+
+	db := setupTestCache[string, countedValue](t)
+
+	if err := db.Set("Key", countedValue{"Value"}, time.Minute); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	encodes, decodes := countedEncodes.Load(), countedDecodes.Load()
+
+	if err := db.Touch("Key", time.Hour); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := countedEncodes.Load() - encodes; got != 0 {
+		t.Errorf("expected no value encodes, got %d", got)
+	}
+
+	if got := countedDecodes.Load() - decodes; got != 0 {
+		t.Errorf("expected no value decodes, got %d", got)
+	}
+
+	ttl, err := db.TTL("Key")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if ttl <= time.Minute {
+		t.Errorf("expected TTL to be reset to %v, got %v", time.Hour, ttl)
+	}
+
+	value, _, err := db.GetValue("Key")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if value.Value != "Value" {
+		t.Errorf("expected %q, got %q", "Value", value.Value)
+	}
+
+	if err := db.Touch("Missing", time.Hour); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected error: %v, got %v", ErrKeyNotFound, err)
+	}
+}
+
+func BenchmarkCacheTouch(b *testing.B) {
+	db := setupTestCache[string, countedValue](b)
+
+	if err := db.Set("Key", countedValue{"Value"}, time.Minute); err != nil {
+		b.Fatalf("unexpected error: %v", err)
+	}
+
+	b.ReportAllocs()
+
+	for b.Loop() {
+		if err := db.Touch("Key", time.Minute); err != nil {
+			b.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func TestCacheGetMeta(t *testing.T) {
+	t.Parallel()
+
+	db, err := OpenMem[string, string](WithAccessTracking())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	before := time.Now()
+
+	if err := db.Set("Key", "Value", time.Hour); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	meta, err := db.GetMeta("Key")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if meta.CreatedAt.Before(before) || meta.CreatedAt.After(time.Now()) {
+		t.Errorf("expected creation time after %v, got %v", before, meta.CreatedAt)
+	}
+
+	if !meta.LastAccess.IsZero() || meta.AccessCount != 0 {
+		t.Errorf("expected no access before a read, got %v and %d", meta.LastAccess, meta.AccessCount)
+	}
+
+	if ttl := time.Until(meta.Expiration); ttl <= 0 || ttl > time.Hour {
+		t.Errorf("expected expiration within an hour, got %v", meta.Expiration)
+	}
+
+	accessed := time.Now()
+
+	for range 2 {
+		if _, _, err := db.GetValue("Key"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	meta, err = db.GetMeta("Key")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if meta.LastAccess.Before(accessed) || meta.LastAccess.After(time.Now()) {
+		t.Errorf("expected last access after %v, got %v", accessed, meta.LastAccess)
+	}
+
+	if meta.AccessCount != 2 {
+		t.Errorf("expected 2 accesses, got %d", meta.AccessCount)
+	}
+
+	for name, format := range map[string]SnapshotFormat{"Binary": FormatBinary, "Msgpack": FormatMsgpack} {
+		t.Run(name, func(t *testing.T) {
+			want := meta
+
+			if err := db.SetConfig(WithSnapshotFormat(format)); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			data, err := db.SnapshotBytes()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			got := setupTestCache[string, string](t)
+			if err := got.LoadSnapshotBytes(data); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			meta, err := got.GetMeta("Key")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !meta.LastAccess.Equal(want.LastAccess) || meta.AccessCount != want.AccessCount || !meta.CreatedAt.Equal(want.CreatedAt) {
+				t.Errorf("expected %+v after reload, got %+v", want, meta)
+			}
+		})
+	}
+
+	if _, err := db.GetMeta("Missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected error: %v, got %v", ErrKeyNotFound, err)
+	}
+}
+
+func TestCacheExpireMulti(t *testing.T) {
+	t.Parallel()
+
+	db := setupTestCache[string, string](t)
+
+	for _, key := range []string{"A", "B", "C", "D"} {
+		if err := db.Set(key, "Value", time.Hour); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	n, err := db.ExpireMulti([]string{"A", "C", "Missing"}, time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if n != 2 {
+		t.Errorf("expected 2 updated entries, got %d", n)
+	}
+
+	tests := []struct {
+		key  string
+		want time.Duration
+	}{
+		{"A", time.Minute},
+		{"B", time.Hour},
+		{"C", time.Minute},
+		{"D", time.Hour},
+	}
+
+	for _, tt := range tests {
+		ttl, err := db.TTL(tt.key)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if ttl > tt.want || ttl < tt.want-time.Second {
+			t.Errorf("expected TTL of %q near %v, got %v", tt.key, tt.want, ttl)
+		}
+	}
+}
+
+func TestCacheSnapshot(t *testing.T) {
+	t.Parallel()
+
+	db := setupTestCache[string, string](t)
+
+	want := map[string]string{"1": "One", "2": "Two", "3": "Three"}
+	for k, v := range want {
+		if err := db.Set(k, v, 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if err := db.Set("Expired", "Value", -time.Second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	entries, err := db.Snapshot()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got := map[string]string{}
+
+	for _, e := range entries {
+		// Writes do not block while iterating, unlike inside Range.
+		done := make(chan error, 1)
+		go func() {
+			done <- db.Set(e.Key, "Changed", 0)
+		}()
+
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Set blocked while iterating the snapshot")
+		}
+
+		if err := db.Set("New"+e.Key, "Value", 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		got[e.Key] = e.Value
+	}
+
+	if !maps.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestCacheEvictionOvershoot(t *testing.T) {
+	t.Parallel()
+
+	const maxCost = 1000
+
+	db, err := OpenRawMem(
+		WithPolicy(PolicyFIFO),
+		WithMaxCost(maxCost),
+		WithEvictionOvershoot(0.1),
+		SetCleanupTime(time.Hour),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	overshot := false
+
+	for i := range 1000 {
+		key := []byte(strconv.Itoa(i))
+		if err := db.Set(key, []byte("Value"), 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if cost := db.Cost(); cost > maxCost*11/10 {
+			t.Fatalf("cost %d exceeds the allowed overshoot", cost)
+		} else if cost > maxCost {
+			overshot = true
+		}
+	}
+
+	if !overshot {
+		t.Fatalf("expected cost to exceed max cost within the overshoot")
+	}
+}
+
+func TestCacheCanonicalKey(t *testing.T) {
+	t.Parallel()
+
+	type key struct {
+		Tenant string
+		Attrs  map[string]int
+	}
+
+	db := setupTestCache[key, string](t)
+
+	attrs := func(order []string) map[string]int {
+		m := map[string]int{}
+		for _, k := range order {
+			m[k] = len(k)
+		}
+
+		return m
+	}
+
+	order := []string{"a", "bb", "ccc", "dddd", "eeeee", "ffffff", "ggggggg", "hhhhhhhh"}
+
+	if err := db.Set(key{Tenant: "x", Attrs: attrs(order)}, "Value", 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Map iteration order is random, so repeated lookups with fresh maps
+	// would miss at some point if map keys were not sorted.
+	for range 20 {
+		slices.Reverse(order)
+
+		got, _, err := db.GetValue(key{Tenant: "x", Attrs: attrs(order)})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if got != "Value" {
+			t.Fatalf("expected Value, got %s", got)
+		}
+	}
+}
+
+func TestCacheKeyNormalizer(t *testing.T) {
+	t.Parallel()
+
+	db, err := OpenMem[string, string](WithKeyNormalizer(strings.ToLower))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	if err := db.Set("Key", "Value", 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got, _, err := db.GetValue("KEY")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got != "Value" {
+		t.Fatalf("expected Value, got %s", got)
+	}
+
+	wrong, err := OpenMem[int, string](WithKeyNormalizer(strings.ToLower))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := wrong.Close(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	if err := wrong.Set(1, "Value", 0); !errors.Is(err, ErrKeyNormalizerType) {
+		t.Fatalf("expected error: %v, got %v", ErrKeyNormalizerType, err)
+	}
+}
+
+func TestCacheKeyFunc(t *testing.T) {
+	t.Parallel()
+
+	type key struct {
+		Tenant    string
+		ID        int
+		RequestID string // RequestID changes on every call and is not part of the identity.
+	}
+
+	identity := func(k key) []byte {
+		return fmt.Appendf(nil, "%s/%d", k.Tenant, k.ID)
+	}
+
+	db, err := OpenMem[key, string](WithKeyFunc(identity))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	if err := db.Set(key{"Tenant", 1, "First"}, "Value", 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got, _, err := db.GetValue(key{"Tenant", 1, "Second"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got != "Value" {
+		t.Fatalf("expected Value, got %s", got)
+	}
+
+	if _, _, err := db.GetValue(key{"Tenant", 2, "First"}); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected error: %v, got %v", ErrKeyNotFound, err)
+	}
+
+	entries, err := db.GetMultiTTL([]key{{"Tenant", 1, "Third"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want := (key{"Tenant", 1, "Third"}); len(entries) != 1 || entries[0].Key != want {
+		t.Fatalf("expected the requested key %v, got %v", want, entries)
+	}
+
+	err = db.Range(func(key, string, time.Duration) bool { return true })
+	if !errors.Is(err, ErrKeyNotDecodable) {
+		t.Fatalf("expected error: %v, got %v", ErrKeyNotDecodable, err)
+	}
+
+	wrong, err := OpenMem[int, string](WithKeyFunc(identity))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := wrong.Close(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	if err := wrong.Set(1, "Value", 0); !errors.Is(err, ErrKeyFuncType) {
+		t.Fatalf("expected error: %v, got %v", ErrKeyFuncType, err)
+	}
+}
+
+func TestCacheCleanup(t *testing.T) {
+	t.Parallel()
+
+	db, err := OpenRawMem(SetCleanupTime(time.Hour))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	if removed, freed, err := db.Cleanup(); err != nil || removed != 0 || freed != 0 {
+		t.Fatalf("expected nothing removed, got %d entries and %d cost, %v", removed, freed, err)
+	}
+
+	entries := []struct {
+		key, value string
+		ttl        time.Duration
+	}{
+		{"Expired1", "Value", 10 * time.Millisecond},
+		{"Expired2", "LongerValue", 10 * time.Millisecond},
+		{"Live", "Value", time.Hour},
+		{"Immortal", "Value", 0},
+	}
+
+	var wantCost uint64
+
+	for _, e := range entries {
+		if err := db.Set([]byte(e.key), []byte(e.value), e.ttl); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if e.ttl != 0 && e.ttl < time.Second {
+			wantCost += uint64(len(e.key) + len(e.value))
+		}
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	before := db.Cost()
+
+	removed, freed, err := db.Cleanup()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if removed != 2 {
+		t.Errorf("expected %d entries removed, got %d", 2, removed)
+	}
+
+	if freed != wantCost {
+		t.Errorf("expected %d cost freed, got %d", wantCost, freed)
+	}
+
+	if after := db.Cost(); before-after != freed {
+		t.Errorf("expected the cost to drop by %d, got %d", freed, before-after)
+	}
+}
+
+func TestCacheWouldFit(t *testing.T) {
+	t.Parallel()
+
+	db, err := OpenMem[string, string](WithMaxCost(100))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	// Costs 11 of the 100 available.
+	if err := db.Set("A", "0123456789", 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// batch returns n entries costing 10 each.
+	batch := func(n int) ([]string, []string) {
+		var keys, values []string
+
+		for i := range n {
+			keys = append(keys, "K"+strconv.Itoa(i))
+			values = append(values, "01234567")
+		}
+
+		return keys, values
+	}
+
+	overwrite := func(n int) ([]string, []string) {
+		keys, values := batch(n)
+
+		return append(keys, "A"), append(values, "0")
+	}
+
+	repeated := func(n int) ([]string, []string) {
+		keys, values := batch(n)
+		for i := range keys {
+			keys[i] = "K"
+		}
+
+		return keys, values
+	}
+
+	tests := []struct {
+		name  string
+		batch func(n int) ([]string, []string)
+		n     int
+		want  bool
+	}{
+		{"Fits", batch, 5, true},
+		{"Exceeds", batch, 9, false},
+		{"Overwrite", overwrite, 9, true},
+		{"Repeated", repeated, 20, true},
+	}
+
+	for _, tt := range tests {
+		keys, values := tt.batch(tt.n)
+
+		got, err := db.WouldFit(keys, values)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+
+		if got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+
+	if _, err := db.WouldFit([]string{"Key"}, nil); !errors.Is(err, ErrBatchLength) {
+		t.Fatalf("expected error: %v, got %v", ErrBatchLength, err)
+	}
+
+	unlimited := setupTestCache[string, string](t)
+
+	keys, values := batch(100)
+	if ok, err := unlimited.WouldFit(keys, values); err != nil || !ok {
+		t.Fatalf("expected a batch to fit without a max cost, got %v, %v", ok, err)
+	}
+}
+
+func TestCacheScan(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		modify func(t *testing.T, db *Cache[string, int], call int)
+	}{
+		{"Unchanged", func(*testing.T, *Cache[string, int], int) {}},
+		{"Grow", func(t *testing.T, db *Cache[string, int], call int) {
+			if call >= 5 {
+				return
+			}
+
+			// Enough new keys on each of the first calls to resize the table a few times.
+			for i := range 1000 {
+				if err := db.Set("New:"+strconv.Itoa(call*1000+i), i, 0); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+		}},
+		{"Shrink", func(t *testing.T, db *Cache[string, int], call int) {
+			if call != 1 {
+				return
+			}
+
+			for i := range 1000 {
+				if err := db.Delete("Temp:" + strconv.Itoa(i)); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			if err := db.Compact(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			db := setupTestCache[string, int](t)
+
+			want := map[string]bool{}
+
+			for i := range 200 {
+				key := "Key:" + strconv.Itoa(i)
+				want[key] = true
+
+				if err := db.Set(key, i, 0); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			for i := range 1000 {
+				if err := db.Set("Temp:"+strconv.Itoa(i), i, 0); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			seen := map[string]bool{}
+
+			var cursor uint64
+
+			for call := 0; ; call++ {
+				keys, next, err := db.Scan(cursor, 10)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				for _, key := range keys {
+					seen[key] = true
+				}
+
+				if next == 0 {
+					break
+				}
+
+				if call > 10000 {
+					t.Fatalf("scan did not finish")
+				}
+
+				tt.modify(t, db, call)
+				cursor = next
+			}
+
+			for key := range want {
+				if !seen[key] {
+					t.Errorf("expected %q to be scanned", key)
+				}
+			}
+		})
+	}
+}
+
+func TestCacheScanMatch(t *testing.T) {
+	t.Parallel()
+
+	db := setupTestCache[string, string](t)
+
+	for _, key := range []string{"user:1", "user:2", "user:3", "tmp:1"} {
+		if err := db.Set(key, "Value", 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if err := db.Set("user:expired", "Value", 10*time.Millisecond); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"user:*", []string{"user:1", "user:2", "user:3"}},
+		{"*:1", []string{"tmp:1", "user:1"}},
+		{"user:[12]", []string{"user:1", "user:2"}},
+		{"none:*", nil},
+	}
+
+	for _, tt := range tests {
+		got, err := db.ScanMatch(tt.pattern)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.pattern, err)
+		}
+
+		slices.Sort(got)
+
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.pattern, tt.want, got)
+		}
+	}
+
+	if _, err := db.ScanMatch("user:["); !errors.Is(err, path.ErrBadPattern) {
+		t.Fatalf("expected error: %v, got %v", path.ErrBadPattern, err)
+	}
+}
+
+func TestCacheCloseTwice(t *testing.T) {
+	t.Parallel()
+
+	db, err := OpenFile[string, string](filepath.Join(t.TempDir(), "cache.db"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := db.Set("Key", "Value", 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("unexpected error on second close: %v", err)
+	}
+}
+
+func TestCacheCloseWithoutFlush(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "cache.db")
+
+	db, err := OpenFile[string, string](path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := db.Set("Saved", "Value", 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := db.Flush(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := db.Set("Discarded", "Value", 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := db.CloseWithoutFlush(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !bytes.Equal(got, want) {
+		t.Fatalf("expected the file to keep its contents from before close")
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("unexpected error on second close: %v", err)
+	}
+
+	db, err = OpenFile[string, string](path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	if _, _, err := db.GetValue("Saved"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, _, err := db.GetValue("Discarded"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected error: %v, got %v", ErrKeyNotFound, err)
+	}
+}
+
+func TestCacheClosed(t *testing.T) {
+	t.Parallel()
+
+	db, err := OpenMem[string, string]()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	raw, err := OpenRawMem()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := db.Set("Key", "Value", 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := raw.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Close and CloseWithoutFlush repeat the result of the first close, and
+	// Clear, Freeze, Unfreeze, Cost, MaxCost and Error have no error to return.
+	if cost := db.Cost(); cost != 0 {
+		t.Fatalf("expected cost 0 after Close, got %d", cost)
+	}
+
+	if err := db.Error(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected error: %v, got %v", ErrClosed, err)
+	}
+
+	factory := func() (string, error) { return "Value", nil }
+
+	tests := []struct {
+		name string
+		fn   func() error
+	}{
+		{"Get", func() error { var v string; _, err := db.Get("Key", &v); return err }},
+		{"GetValue", func() error { _, _, err := db.GetValue("Key"); return err }},
+		{"GetAllowStale", func() error { _, _, _, err := db.GetAllowStale("Key"); return err }},
+		{"GetMultiTTL", func() error { _, err := db.GetMultiTTL([]string{"Key"}); return err }},
+		{"GetBatch", func() error { _, err := db.GetBatch([]string{"Key"}); return err }},
+		{"HasMulti", func() error { _, err := db.HasMulti([]string{"Key"}); return err }},
+		{"TTL", func() error { _, err := db.TTL("Key"); return err }},
+		{"GetMeta", func() error { _, err := db.GetMeta("Key"); return err }},
+		{"Scan", func() error { _, _, err := db.Scan(0, 10); return err }},
+		{"Touch", func() error { return db.Touch("Key", 0) }},
+		{"GetWithVersion", func() error { _, _, _, err := db.GetWithVersion("Key"); return err }},
+		{"SetIfVersion", func() error { _, err := db.SetIfVersion("Key", "Value", 0, 0); return err }},
+		{"ExpireMulti", func() error { _, err := db.ExpireMulti([]string{"Key"}, 0); return err }},
+		{"IncrementWithTTL", func() error { _, err := db.IncrementWithTTL("Key", 1, 0); return err }},
+		{"Set", func() error { return db.Set("Key", "Value", 0) }},
+		{"SetIfNewer", func() error { _, err := db.SetIfNewer("Key", "Value", 1, 0); return err }},
+		{"SetTagged", func() error { return db.SetTagged("Key", "Value", 0, "Tag") }},
+		{"InvalidateTag", func() error { _, err := db.InvalidateTag("Tag"); return err }},
+		{"SetEntries", func() error { return db.SetEntries([]Entry[string, string]{{Key: "Key"}}) }},
+		{"ReplaceAll", func() error { return db.ReplaceAll(nil) }},
+		{"Delete", func() error { return db.Delete("Key") }},
+		{"UpdateInPlace", func() error {
+			return db.UpdateInPlace("Key", func(v string) (string, error) { return v, nil }, 0)
+		}},
+		{"Memorize", func() error { _, err := db.Memorize("Key", factory, 0); return err }},
+		{"MemorizeX", func() error { _, _, err := db.MemorizeX("Key", factory, 0); return err }},
+		{"MemorizeTimeout", func() error {
+			_, err := db.MemorizeTimeout("Key", func(context.Context) (string, error) { return factory() }, 0, time.Second)
+			return err
+		}},
+		{"Preload", func() error {
+			return db.Preload([]string{"Key"}, func(string) (string, error) { return "Value", nil }, 0, 1)
+		}},
+		{"Range", func() error { return db.Range(func(string, string, time.Duration) bool { return true }) }},
+		{"RangeContext", func() error {
+			return db.RangeContext(context.Background(), func(string, string, time.Duration) bool { return true })
+		}},
+		{"RangeLenient", func() error { return db.RangeLenient(func(string, string, time.Duration) bool { return true }) }},
+		{"ParallelRange", func() error { return db.ParallelRange(1, func(string, string) error { return nil }) }},
+		{"Snapshot", func() error { _, err := db.Snapshot(); return err }},
+		{"Expired", func() error { _, err := db.Expired(); return err }},
+		{"Transaction", func() error { return db.Transaction(func(*Tx[string, string]) error { return nil }) }},
+		{"Compact", db.Compact},
+		{"Drain", db.Drain},
+		{"Flush", db.Flush},
+		{"Sync", db.Sync},
+		{"Reset", db.Reset},
+		{"ClearError", db.ClearError},
+		{"Healthy", db.Healthy},
+		{"SnapshotBytes", func() error { _, err := db.SnapshotBytes(); return err }},
+		{"SetMaxCost", func() error { return db.SetMaxCost(10) }},
+		{"SetConfig", func() error { return db.SetConfig(WithPolicy(PolicyLRU)) }},
+		{"Cleanup", func() error { _, _, err := db.Cleanup(); return err }},
+		{"EvictN", func() error { _, err := db.EvictN(1); return err }},
+		{"WouldFit", func() error { _, err := db.WouldFit([]string{"Key"}, []string{"Value"}); return err }},
+		{"NextEvictionKey", func() error { _, _, err := raw.NextEvictionKey(); return err }},
+		{"DumpJSON", func() error { return raw.DumpJSON(io.Discard) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if err := tt.fn(); !errors.Is(err, ErrClosed) {
+				t.Fatalf("expected error: %v, got %v", ErrClosed, err)
+			}
+		})
+	}
+}
+
+func TestCacheFreeze(t *testing.T) {
+	t.Parallel()
+
+	db := setupTestCache[string, string](t)
+
+	db.Freeze()
+
+	done := make(chan error)
+	go func() {
+		done <- db.Set("Key", "Value", 0)
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("expected Set to block while frozen, returned %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	db.Unfreeze()
+
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got, _, err := db.GetValue("Key")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got != "Value" {
+		t.Fatalf("expected Value, got %s", got)
+	}
+}
+
+func TestCacheDrain(t *testing.T) {
+	t.Parallel()
+
+	got := map[string]string{}
+
+	db, err := OpenRawMem(
+		WithOnEvict(func(key, value []byte, reason EvictionReason) {
+			if reason != ReasonDrained {
+				t.Errorf("expected reason %v, got %v", ReasonDrained, reason)
+			}
+
+			got[string(key)] = string(value)
+		}),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := map[string]string{"1": "One", "2": "Two", "3": "Three"}
+	for k, v := range want {
+		if err := db.Set([]byte(k), []byte(v), 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if err := db.Drain(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !maps.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+
+	if db.Store.Length != 0 || db.Cost() != 0 {
+		t.Fatalf("expected store to be empty, got %d entries", db.Store.Length)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := db.Drain(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected error: %v, got %v", ErrClosed, err)
+	}
+}
+
+func TestCacheSetEntries(t *testing.T) {
+	t.Parallel()
+
+	db := setupTestCache[string, string](t)
+
+	entries := []Entry[string, string]{
+		{Key: "1", Value: "One", TTL: 1 * time.Hour},
+		{Key: "2", Value: "Two", TTL: 2 * time.Hour},
+		{Key: "3", Value: "Three", TTL: 0},
+	}
+
+	for i := range 20 {
+		entries = append(entries, Entry[string, string]{Key: "Extra" + strconv.Itoa(i), Value: "Value"})
+	}
+
+	if err := db.SetEntries(entries); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, e := range entries {
+		got, ttl, err := db.GetValue(e.Key)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if got != e.Value {
+			t.Errorf("expected %v, got %v", e.Value, got)
+		}
+
+		if ttl.Round(time.Second) != e.TTL {
+			t.Errorf("expected ttl %v, got %v", e.TTL, ttl)
+		}
+	}
+}
+
+func TestCacheReplaceAll(t *testing.T) {
+	t.Parallel()
+
+	db := setupTestCache[string, int](t)
+
+	dataset := func(generation int) []Entry[string, int] {
+		entries := []Entry[string, int]{
+			{Key: "Shared", Value: generation},
+			{Key: "Generation" + strconv.Itoa(generation), Value: generation},
+		}
+
+		for i := range 100 {
+			entries = append(entries, Entry[string, int]{Key: "Extra" + strconv.Itoa(i), Value: generation})
+		}
+
+		return entries
+	}
+
+	if err := db.ReplaceAll(dataset(0)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	done := make(chan struct{})
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			if _, _, err := db.GetValue("Shared"); err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+		}
+	}()
+
+	for generation := 1; generation <= 50; generation++ {
+		if err := db.ReplaceAll(dataset(generation)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	close(done)
+	wg.Wait()
+
+	if got, _, err := db.GetValue("Shared"); err != nil || got != 50 {
+		t.Fatalf("expected %v, got %v %v", 50, got, err)
+	}
+
+	if _, _, err := db.GetValue("Generation49"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected error: %v, got %v", ErrKeyNotFound, err)
+	}
+
+	if got := db.Store.Length; got != 102 {
+		t.Fatalf("expected 102 entries, got %d", got)
+	}
+}
+
+func TestCacheRange(t *testing.T) {
+	t.Parallel()
+
+	t.Run("All", func(t *testing.T) {
+		t.Parallel()
+
+		db := setupTestCache[string, int](t)
+
+		want := map[string]int{"1": 1, "2": 2, "3": 3}
+		for k, v := range want {
+			if err := db.Set(k, v, 0); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+
+		got := map[string]int{}
+
+		err := db.Range(func(key string, value int, ttl time.Duration) bool {
+			got[key] = value
+			return true
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if !maps.Equal(got, want) {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		t.Parallel()
+
+		db := setupTestCache[int, int](t)
+
+		for i := range 100 {
+			if err := db.Set(i, i, 0); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+
+		ctx, cancel := context.WithCancel(t.Context())
+		defer cancel()
+
+		count := 0
+
+		err := db.RangeContext(ctx, func(key int, value int, ttl time.Duration) bool {
+			count++
+			if count == 10 {
+				cancel()
+			}
+
+			return true
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected error: %v, got: %v", context.Canceled, err)
+		}
+
+		if count >= 100 {
+			t.Fatalf("expected iteration to stop early, visited %d entries", count)
+		}
+	})
+}
+
+func TestCacheBytesPassthrough(t *testing.T) {
+	t.Parallel()
+
+	db := setupTestCache[string, []byte](t)
+
+	tests := []struct {
+		name  string
+		key   string
+		value []byte
+	}{
+		{"Empty", "", []byte{}},
+		{"Text", "Key", []byte("Value")},
+		{"Binary", "\x00\xff", []byte{0xc1, 0x00, 0xff}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value := bytes.Clone(tt.value)
+			if err := db.Set(tt.key, value, 0); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// The cache must not alias the caller's slice.
+			if len(value) > 0 {
+				value[0]++
+			}
+
+			got, _, err := db.GetValue(tt.key)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !bytes.Equal(got, tt.value) {
+				t.Errorf("expected %v, got %v", tt.value, got)
+			}
+
+			keyData, err := db.encodeKey(tt.key)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if string(keyData) != tt.key {
+				t.Errorf("expected key to be stored as is, got %v", keyData)
+			}
+		})
+	}
+}
+
+// writeLegacySnapshot writes entries to filename as a snapshot of the revision
+// before rawRevision, which encoded string keys and values with msgpack.
+func writeLegacySnapshot(tb testing.TB, filename string, format SnapshotFormat, entries map[string]string) {
+	tb.Helper()
+
+	s := setupTestStore(tb)
+
+	for k, v := range entries {
+		key, err := msgpack.Marshal(k)
+		if err != nil {
+			tb.Fatalf("unexpected error: %v", err)
+		}
+
+		value, err := msgpack.Marshal(v)
+		if err != nil {
+			tb.Fatalf("unexpected error: %v", err)
+		}
+
+		if err := s.Set(key, value, 0); err != nil {
+			tb.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	view, err := s.view()
+	if err != nil {
+		tb.Fatalf("unexpected error: %v", err)
+	}
+
+	var buf bytes.Buffer
+
+	e := newEncoder(&buf)
+	e.rev = rawRevision - 1
+
+	if _, err := e.w.Write(append(slices.Clone(snapshotMagic), byte(format), e.rev)); err != nil {
+		tb.Fatalf("unexpected error: %v", err)
+	}
+
+	if format == FormatMsgpack {
+		err = e.EncodeMsgpack(view)
+	} else {
+		err = e.EncodeStore(view)
+	}
+
+	if err != nil {
+		tb.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := e.Flush(); err != nil {
+		tb.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := os.WriteFile(filename, buf.Bytes(), 0o666); err != nil {
+		tb.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestCacheLegacySnapshot(t *testing.T) {
+	t.Parallel()
+
+	entries := map[string]string{"Key": "Value", "": "Empty", "Other": ""}
+
+	for name, format := range map[string]SnapshotFormat{"Binary": FormatBinary, "Msgpack": FormatMsgpack} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			filename := filepath.Join(t.TempDir(), "cache.db")
+			writeLegacySnapshot(t, filename, format, entries)
+
+			db, err := OpenFile[string, string](filename)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			t.Cleanup(func() {
+				if err := db.Close(); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			})
+
+			for key, want := range entries {
+				got, _, err := db.GetValue(key)
+				if err != nil {
+					t.Fatalf("key %q: unexpected error: %v", key, err)
+				}
+
+				if got != want {
+					t.Errorf("expected %q, got %q", want, got)
+				}
+			}
+		})
+	}
+
+	// Other types were encoded with msgpack before as well and are left alone.
+	t.Run("Other Types", func(t *testing.T) {
+		t.Parallel()
+
+		filename := filepath.Join(t.TempDir(), "cache.db")
+		writeLegacySnapshot(t, filename, FormatBinary, entries)
+
+		db, err := OpenFile[any, any](filename)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		t.Cleanup(func() {
+			if err := db.Close(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+
+		got, _, err := db.GetValue("Key")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if got != "Value" {
+			t.Errorf("expected %q, got %v", "Value", got)
+		}
+	})
+}
+
+func TestCacheRangeLenient(t *testing.T) {
+	t.Parallel()
+
+	db := setupTestCache[string, int](t)
+
+	want := map[string]int{"1": 1, "2": 2, "3": 3}
+	for k, v := range want {
+		if err := db.Set(k, v, 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	badKey, err := marshal("Bad")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// 0xc1 is never used by msgpack, so the value cannot be decoded.
+	if err := db.cache.Set(badKey, []byte{0xc1}, 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got := map[string]int{}
+
+	err = db.RangeLenient(func(key string, value int, ttl time.Duration) bool {
+		got[key] = value
+		return true
+	})
+
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) {
+		t.Fatalf("expected a decode error, got %v", err)
+	}
+
+	if !bytes.Equal(decodeErr.Key, badKey) {
+		t.Fatalf("expected key %v, got %v", badKey, decodeErr.Key)
+	}
+
+	if !maps.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestCacheGetAllowStale(t *testing.T) {
+	t.Parallel()
+
+	db := setupTestCache[string, string](t)
+
+	if err := db.Set("Fresh", "One", time.Hour); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := db.Set("Expired", "Two", 50*time.Millisecond); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	tests := []struct {
+		name      string
+		key       string
+		want      string
+		wantStale bool
+		wantErr   error
+	}{
+		{name: "Fresh", key: "Fresh", want: "One"},
+		{name: "Expired", key: "Expired", want: "Two", wantStale: true},
+		{name: "Missing", key: "Missing", wantErr: ErrKeyNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ttl, stale, err := db.GetAllowStale(tt.key)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error: %v, got %v", tt.wantErr, err)
+			}
+
+			if got != tt.want || stale != tt.wantStale {
+				t.Fatalf("expected %q stale %v, got %q stale %v", tt.want, tt.wantStale, got, stale)
+			}
+
+			if stale && ttl >= 0 {
+				t.Fatalf("expected a negative TTL for a stale entry, got %v", ttl)
+			}
+		})
+	}
+
+	// Reading a stale entry does not remove it.
+	if _, _, err := db.GetValue("Expired"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected error: %v, got %v", ErrKeyNotFound, err)
+	}
+
+	if db.Store.Length != 2 {
+		t.Fatalf("expected 2 entries, got %d", db.Store.Length)
+	}
+}
+
+func TestCacheGetMultiTTL(t *testing.T) {
+	t.Parallel()
+
+	db := setupTestCache[string, string](t)
+
+	if err := db.Set("Immortal", "One", 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := db.Set("Timed", "Two", time.Hour); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := db.Set("Expired", "Three", -time.Second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got, err := db.GetMultiTTL([]string{"Timed", "Missing", "Expired", "Immortal"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(got))
+	}
+
+	if got[0].Key != "Timed" || got[0].Value != "Two" || got[0].TTL <= time.Hour-time.Minute || got[0].TTL > time.Hour {
+		t.Fatalf("unexpected entry %+v", got[0])
+	}
+
+	if got[1].Key != "Immortal" || got[1].Value != "One" || got[1].TTL != 0 {
+		t.Fatalf("unexpected entry %+v", got[1])
+	}
+}
+
+func TestCacheHasMulti(t *testing.T) {
+	t.Parallel()
+
+	db := setupTestCache[string, string](t)
+
+	if err := db.Set("Present", "Value", 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := db.Set("Expired", "Value", -time.Second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got, err := db.HasMulti([]string{"Present", "Absent", "Expired"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []bool{true, false, false}
+	if !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestCacheParallelRange(t *testing.T) {
+	t.Parallel()
+
+	db := setupTestCache[int, int](t)
+
+	want := 0
+
+	for i := range 1000 {
+		if err := db.Set(i, i, 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		want += i
+	}
+
+	t.Run("Sum", func(t *testing.T) {
+		t.Parallel()
+
+		var got atomic.Int64
+
+		err := db.ParallelRange(4, func(key, value int) error {
+			got.Add(int64(value))
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if got.Load() != int64(want) {
+			t.Fatalf("expected %v, got %v", want, got.Load())
+		}
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		t.Parallel()
+
+		errStop := errors.New("stop")
+
+		err := db.ParallelRange(4, func(key, value int) error {
+			if key == 500 {
+				return errStop
+			}
+
+			return nil
+		})
+		if !errors.Is(err, errStop) {
+			t.Fatalf("expected error: %v, got %v", errStop, err)
+		}
+	})
+}
+
+func TestCacheExpired(t *testing.T) {
+	t.Parallel()
+
+	db := setupTestCache[string, int](t)
+
+	if err := db.Set("Short", 1, 100*time.Millisecond); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := db.Set("Long", 2, 1*time.Hour); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	got, err := db.Expired()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []KeyValue[string, int]{{Key: "Short", Value: 1}}
+	if !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestCacheDelete(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Exists", func(t *testing.T) {
+		t.Parallel()
+
+		db := setupTestCache[string, string](t)
+		want := "Value"
+
+		if err := db.Set("Key", want, 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if err := db.Delete("Key"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if _, _, err := db.GetValue("Key"); !errors.Is(err, ErrKeyNotFound) {
+			t.Fatalf("expected error: %v, got: %v", ErrKeyNotFound, err)
+		}
+	})
+
+	t.Run("Not Exists", func(t *testing.T) {
+		t.Parallel()
+
+		db := setupTestCache[string, string](t)
+
+		if err := db.Delete("Key"); !errors.Is(err, ErrKeyNotFound) {
+			t.Fatalf("expected error: %v, got: %v", ErrKeyNotFound, err)
+		}
+	})
+}
+
+func TestCacheUpdateInPlace(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Exists", func(t *testing.T) {
+		t.Parallel()
+
+		store := setupTestCache[string, string](t)
+
+		want := "Value"
+
+		if err := store.Set("Key", "Initial", 1*time.Hour); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		processFunc := func(v string) (string, error) {
+			return want, nil
+		}
+
+		if err := store.UpdateInPlace("Key", processFunc, 1*time.Hour); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		got, _, err := store.GetValue("Key")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if want != got {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Not Exists", func(t *testing.T) {
+		t.Parallel()
+
+		store := setupTestCache[string, string](t)
+
+		want := "Value"
+
+		processFunc := func(v string) (string, error) {
+			return want, nil
+		}
+
+		if err := store.UpdateInPlace("Key", processFunc, 1*time.Hour); !errors.Is(err, ErrKeyNotFound) {
+			t.Fatalf("expected error: %v, got: %v", ErrKeyNotFound, err)
+		}
+	})
+}
+
+func TestCacheMemoize(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Cache Miss", func(t *testing.T) {
+		t.Parallel()
+
+		store := setupTestCache[string, string](t)
+
+		want := "Value"
+
+		factoryFunc := func() (string, error) {
+			return want, nil
+		}
+
+		got, err := store.Memorize("Key", factoryFunc, 1*time.Hour)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if got != "Value" {
+			t.Fatalf("expected: %v, got: %v", "Value", got)
+		}
+
+		got, _, err = store.GetValue("Key")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if want != got {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Cache Hit", func(t *testing.T) {
+		t.Parallel()
+
+		store := setupTestCache[string, string](t)
+
+		want := "NewValue"
+
+		if err := store.Set("Key", "Value", 1*time.Hour); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		factoryFunc := func() (string, error) {
+			return want, nil
+		}
+
+		got, err := store.Memorize("Key", factoryFunc, 1*time.Hour)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if got != "Value" {
+			t.Fatalf("expected: %v, got: %v", "Value", got)
+		}
+	})
+}
+
+func TestCacheMemorizeTimeout(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Timeout", func(t *testing.T) {
+		t.Parallel()
+
+		db := setupTestCache[string, string](t)
+
+		const timeout = 200 * time.Millisecond
+
+		start := time.Now()
+		written := make(chan time.Duration, 1)
+		stopped := make(chan error, 1)
+
+		factoryFunc := func(ctx context.Context) (string, error) {
+			// The cache is not locked while the factory runs.
+			if err := db.Set("Other", "Value", 0); err != nil {
+				return "", err
+			}
+
+			written <- time.Since(start)
+
+			<-ctx.Done()
+			stopped <- ctx.Err()
+
+			return "Value", nil
+		}
+
+		if _, err := db.MemorizeTimeout("Key", factoryFunc, time.Hour, timeout); !errors.Is(err, ErrFactoryTimeout) {
+			t.Fatalf("expected error: %v, got: %v", ErrFactoryTimeout, err)
+		}
+
+		if elapsed := <-written; elapsed >= timeout {
+			t.Fatalf("expected the write to finish before the timeout, took %v", elapsed)
+		}
+
+		if err := <-stopped; !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected error: %v, got: %v", context.DeadlineExceeded, err)
+		}
+
+		if _, _, err := db.GetValue("Key"); !errors.Is(err, ErrKeyNotFound) {
+			t.Fatalf("expected error: %v, got: %v", ErrKeyNotFound, err)
+		}
+	})
+
+	t.Run("In Time", func(t *testing.T) {
+		t.Parallel()
+
+		db := setupTestCache[string, string](t)
+
+		factoryFunc := func(context.Context) (string, error) {
+			return "Value", nil
+		}
+
+		got, err := db.MemorizeTimeout("Key", factoryFunc, time.Hour, time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if got != "Value" {
+			t.Fatalf("expected %v, got %v", "Value", got)
+		}
+	})
+}
+
+func TestCacheMemorizeX(t *testing.T) {
+	t.Parallel()
+
+	db := setupTestCache[string, string](t)
+
+	if err := db.Set("Hit", "Cached", 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		key          string
+		want         string
+		wantComputed bool
+	}{
+		{name: "Hit", key: "Hit", want: "Cached", wantComputed: false},
+		{name: "Miss", key: "Miss", want: "Computed", wantComputed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, computed, err := db.MemorizeX(tt.key, func() (string, error) {
+				return "Computed", nil
+			}, 0)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got != tt.want {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+
+			if computed != tt.wantComputed {
+				t.Fatalf("expected computed %v, got %v", tt.wantComputed, computed)
+			}
+		})
+	}
+}
+
+func TestCachePreload(t *testing.T) {
+	t.Parallel()
+
+	db := setupTestCache[string, string](t)
+
+	if err := db.Set("2", "Cached", 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var mu sync.Mutex
+
+	calls := map[string]int{}
+
+	keys := []string{"1", "2", "3", "4", "5"}
+
+	err := db.Preload(keys, func(key string) (string, error) {
+		mu.Lock()
+		calls[key]++
+		mu.Unlock()
+
+		return "Value" + key, nil
+	}, time.Hour, 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := map[string]int{"1": 1, "3": 1, "4": 1, "5": 1}
+	if !maps.Equal(calls, want) {
+		t.Fatalf("expected factory calls %v, got %v", want, calls)
+	}
+
+	for _, key := range keys {
+		got, _, err := db.GetValue(key)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if key == "2" && got != "Cached" || key != "2" && got != "Value"+key {
+			t.Fatalf("unexpected value %s for key %s", got, key)
+		}
+	}
+
+	errFactory := errors.New("factory failed")
+
+	err = db.Preload([]string{"6", "7"}, func(key string) (string, error) {
+		return "", errFactory
+	}, time.Hour, 2)
+	if !errors.Is(err, errFactory) {
+		t.Fatalf("expected error: %v, got %v", errFactory, err)
+	}
+}
+
+func BenchmarkCacheGet(b *testing.B) {
+	for n := 1; n <= 100000; n *= 10 {
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			db := setupTestCache[int, int](b)
+			for i := range n {
+				if err := db.Set(i, i, 0); err != nil {
+					b.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			b.ReportAllocs()
+
+			for b.Loop() {
+				if _, _, err := db.GetValue(n - 1); err != nil {
+					b.Fatalf("unexpected error: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkCacheSet(b *testing.B) {
+	for n := 1; n <= 100000; n *= 10 {
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			db := setupTestCache[int, int](b)
+			for i := range n - 1 {
+				if err := db.Set(i, i, 0); err != nil {
+					b.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			b.ReportAllocs()
+
+			for b.Loop() {
+				if err := db.Set(n, n, 0); err != nil {
+					b.Fatalf("unexpected error: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// msgpackString and msgpackBytes are encoded with msgpack like any other named type,
+// for comparing against the pass through of plain strings and byte slices.
+type (
+	msgpackString string
+	msgpackBytes  []byte
+)
+
+func BenchmarkCacheBytes(b *testing.B) {
+	b.Run("Msgpack", func(b *testing.B) {
+		benchmarkCacheBytes[msgpackString, msgpackBytes](b)
+	})
+
+	b.Run("Passthrough", func(b *testing.B) {
+		benchmarkCacheBytes[string, []byte](b)
+	})
+}
+
+func benchmarkCacheBytes[K ~string, V ~[]byte](b *testing.B) {
+	key, value := K("Key"), V(bytes.Repeat([]byte("Value"), 64))
+
+	b.Run("Set", func(b *testing.B) {
+		db := setupTestCache[K, V](b)
+
+		b.ReportAllocs()
+
+		for b.Loop() {
+			if err := db.Set(key, value, 0); err != nil {
+				b.Fatalf("unexpected error: %v", err)
+			}
+		}
+	})
+
+	b.Run("Get", func(b *testing.B) {
+		db := setupTestCache[K, V](b)
+		if err := db.Set(key, value, 0); err != nil {
+			b.Fatalf("unexpected error: %v", err)
+		}
+
+		b.ReportAllocs()
+
+		for b.Loop() {
+			if _, _, err := db.GetValue(key); err != nil {
+				b.Fatalf("unexpected error: %v", err)
+			}
+		}
+	})
 }
 
 func BenchmarkCacheDelete(b *testing.B) {
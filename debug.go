@@ -0,0 +1,16 @@
+//go:build cachedebug
+
+package cache
+
+// debug enables internal consistency checks that panic instead of recovering.
+// Build with the cachedebug tag to enable it.
+const debug = true
+
+// VerifyEvictList checks the eviction list of the cache for broken links and a length
+// that does not match the number of entries. It is only built with the cachedebug tag.
+func (c *cache) VerifyEvictList() error {
+	c.Store.Lock.RLock()
+	defer c.Store.Lock.RUnlock()
+
+	return c.Store.verifyEvictList()
+}
@@ -2,20 +2,91 @@ package cache
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"math"
+	"sync/atomic"
 	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"go.sudomsg.com/cache/internal/pausedtimer"
+)
+
+// SnapshotFormat selects how snapshots are encoded.
+type SnapshotFormat byte
+
+const (
+	// FormatBinary is the compact native snapshot format.
+	FormatBinary SnapshotFormat = iota
+	// FormatMsgpack encodes the whole store as a single msgpack document for interoperability.
+	FormatMsgpack
 )
 
+// snapshotMagic starts every snapshot header and is followed by a format byte
+// and a revision byte. Snapshots without it are read as FormatBinary at revision 0.
+var snapshotMagic = []byte("GOCACHE")
+
+// snapshotRevision is the current revision of the snapshot layout.
+// Revision 1 adds the node version, revision 2 the maintenance intervals
+// revision 3 the node tags, revision 4 the node generation, revision 5
+// the custom policy name, revision 6 the node access metadata and revision 7
+// the policy settings, the generation counter, the SLRU segment and sampled LRU
+// clock of each node and the sub-second part of expirations. Revision 8 keeps the
+// layout but stores string and []byte keys and values of Cache without msgpack.
+// Revision 9 adds whether the store is unlimited.
+const snapshotRevision byte = 9
+
+// rawRevision is the first revision storing string and []byte keys and values as is.
+const rawRevision byte = 8
+
+var ErrInvalidFormat = errors.New("invalid snapshot format") // ErrInvalidFormat is returned for an unknown snapshot format.
+
+var ErrDuplicateKey = errors.New("duplicate key in snapshot") // ErrDuplicateKey is wrapped by DuplicateKeyError.
+
+// DuplicateKeyError is returned after loading a snapshot that holds several entries
+// for the same key, for example because two snapshots were concatenated. Only the
+// last entry for each key is kept, so the loaded store is consistent and usable.
+type DuplicateKeyError struct {
+	Count int // Count is the number of entries that were replaced by a later one.
+}
+
+func (e *DuplicateKeyError) Error() string {
+	return fmt.Sprintf("%v: %d entries replaced", ErrDuplicateKey, e.Count)
+}
+
+func (e *DuplicateKeyError) Unwrap() error {
+	return ErrDuplicateKey
+}
+
+// duplicateKeyError returns a DuplicateKeyError if count is not zero.
+func duplicateKeyError(count int) error {
+	if count == 0 {
+		return nil
+	}
+
+	return &DuplicateKeyError{Count: count}
+}
+
 type encoder struct {
 	w   *bufio.Writer
 	buf []byte
+	rev byte
 }
 
 func newEncoder(w io.Writer) *encoder {
+	return newEncoderSize(w, 0)
+}
+
+// newEncoderSize creates an encoder buffering size bytes, or the bufio default if size is not positive.
+func newEncoderSize(w io.Writer, size int) *encoder {
 	return &encoder{
-		w:   bufio.NewWriter(w),
+		w:   bufio.NewWriterSize(w, size),
 		buf: make([]byte, 8),
+		rev: snapshotRevision,
 	}
 }
 
@@ -57,6 +128,51 @@ func (e *encoder) EncodeNode(n *node) error {
 		return err
 	}
 
+	if e.rev >= 1 {
+		if err := e.EncodeUint64(n.Version); err != nil {
+			return err
+		}
+	}
+
+	if e.rev >= 3 {
+		if err := e.EncodeUint64(uint64(len(n.Tags))); err != nil {
+			return err
+		}
+
+		for _, tag := range n.Tags {
+			if err := e.EncodeBytes([]byte(tag)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if e.rev >= 4 {
+		if err := e.EncodeUint64(n.Generation); err != nil {
+			return err
+		}
+	}
+
+	if e.rev >= 6 {
+		for _, val := range []uint64{uint64(n.CreatedAt), uint64(n.AccessedAt), n.Hits} {
+			if err := e.EncodeUint64(val); err != nil {
+				return err
+			}
+		}
+	}
+
+	if e.rev >= 7 {
+		var protected uint64
+		if n.Protected {
+			protected = 1
+		}
+
+		for _, val := range []uint64{uint64(n.Expiration.Nanosecond()), protected, n.LastAccess} {
+			if err := e.EncodeUint64(val); err != nil {
+				return err
+			}
+		}
+	}
+
 	if err := e.EncodeBytes(n.Key); err != nil {
 		return err
 	}
@@ -68,37 +184,204 @@ func (e *encoder) EncodeNode(n *node) error {
 	return nil
 }
 
-func (e *encoder) EncodeStore(s *store) error {
-	if err := e.EncodeUint64(s.MaxCost); err != nil {
+func (e *encoder) EncodeStore(v *snapshotView) error {
+	if err := e.EncodeUint64(v.MaxCost); err != nil {
 		return err
 	}
 
-	if err := e.EncodeUint64(uint64(s.Policy.Type)); err != nil {
+	if err := e.EncodeUint64(uint64(v.Policy)); err != nil {
 		return err
 	}
 
-	if err := e.EncodeUint64(s.Length); err != nil {
+	if e.rev >= 5 {
+		if err := e.EncodeBytes([]byte(v.PolicyName)); err != nil {
+			return err
+		}
+	}
+
+	if e.rev >= 7 {
+		for _, val := range []uint64{math.Float64bits(v.SLRURatio), uint64(v.SampleSize), v.Clock, v.Generation} {
+			if err := e.EncodeUint64(val); err != nil {
+				return err
+			}
+		}
+	}
+
+	if e.rev >= 9 {
+		var unlimited uint64
+		if v.Unlimited {
+			unlimited = 1
+		}
+
+		if err := e.EncodeUint64(unlimited); err != nil {
+			return err
+		}
+	}
+
+	if e.rev >= 2 {
+		for _, interval := range v.Intervals {
+			if err := e.EncodeUint64(uint64(interval)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := e.EncodeUint64(uint64(len(v.Nodes))); err != nil {
 		return err
 	}
 
+	for i := range v.Nodes {
+		n := v.Nodes[i]
+
+		value, err := v.value(&n)
+		if err != nil {
+			return err
+		}
+
+		n.Value = value
+
+		if err := e.EncodeNode(&n); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// snapshotView is the state of a store captured for a snapshot. Nodes are copies,
+// so the snapshot can be written without holding the store lock while later writes
+// and deletes leave it unchanged. Spilled values stay references into Blobs and are
+// read one at a time while encoding; Blobs is pinned until release so their files
+// outlive the entries.
+type snapshotView struct {
+	MaxCost    uint64
+	Unlimited  bool
+	Policy     EvictionPolicyType
+	PolicyName string
+	SLRURatio  float64
+	SampleSize int
+	Clock      uint64 // Clock is the logical access clock of the sampled LRU policy.
+	Generation uint64
+	Intervals  [3]time.Duration // Snapshot, forced snapshot and cleanup intervals.
+	Nodes      []node
+	Blobs      *blobStore
+	Filter     func(key, value []byte, ttl time.Duration) bool
+}
+
+// view captures the store for a snapshot in eviction order. Values and tags are never
+// modified in place, so the copies share them with the store. No blob files are read,
+// so the lock is held only for the copy. The caller must hold the store lock and call
+// release once the view is written.
+func (s *store) view() (*snapshotView, error) {
+	s.EvictLock.RLock()
+	defer s.EvictLock.RUnlock()
+
+	view := &snapshotView{
+		MaxCost:    s.MaxCost,
+		Unlimited:  s.Unlimited,
+		Policy:     s.Policy.Type,
+		PolicyName: s.Policy.Name,
+		SLRURatio:  s.Policy.SLRURatio,
+		SampleSize: s.Policy.SampleSize,
+		Clock:      atomic.LoadUint64(&s.Policy.Clock),
+		Generation: s.Generation,
+		Intervals: [3]time.Duration{
+			s.SnapshotTicker.GetDuration(),
+			s.ForceTicker.GetDuration(),
+			s.CleanupTicker.GetDuration(),
+		},
+		Nodes:  make([]node, 0, s.Length),
+		Filter: s.SnapshotFilter,
+	}
+
+	if s.Blobs != nil {
+		view.Blobs = s.Blobs
+		view.Blobs.Pin()
+	}
+
 	for v := s.EvictList.EvictNext; v != &s.EvictList; v = v.EvictNext {
-		if err := e.EncodeNode(v); err != nil {
+		value := v.Value
+		if v.InArena {
+			value = s.Arena.Bytes(v.Slot)
+		}
+
+		view.Nodes = append(view.Nodes, node{
+			Hash:       v.Hash,
+			Key:        v.Key,
+			Value:      value,
+			Blob:       v.Blob,
+			Expiration: v.Expiration,
+			Access:     v.Access,
+			Protected:  v.Protected,
+			LastAccess: atomic.LoadUint64(&v.LastAccess),
+			Version:    v.Version,
+			Generation: v.Generation,
+			Tags:       v.Tags,
+			CreatedAt:  v.CreatedAt,
+			AccessedAt: atomic.LoadInt64(&v.AccessedAt),
+			Hits:       atomic.LoadUint64(&v.Hits),
+		})
+	}
+
+	return view, nil
+}
+
+// value returns the value of a captured node, reading it from the blob store if it
+// was spilled. Spilled values are written inline so the snapshot is self contained.
+func (v *snapshotView) value(n *node) ([]byte, error) {
+	if n.Blob {
+		return v.Blobs.Load(n.Value)
+	}
+
+	return n.Value, nil
+}
+
+// filter leaves out the nodes rejected by Filter.
+func (v *snapshotView) filter() error {
+	if v.Filter == nil {
+		return nil
+	}
+
+	nodes := v.Nodes[:0:0]
+
+	for i := range v.Nodes {
+		n := &v.Nodes[i]
+
+		value, err := v.value(n)
+		if err != nil {
 			return err
 		}
+
+		if v.Filter(n.Key, value, n.TTL()) {
+			nodes = append(nodes, *n)
+		}
 	}
 
+	v.Nodes = nodes
+	v.Filter = nil
+
 	return nil
 }
 
+// release unpins the blob store once the view is written.
+func (v *snapshotView) release() {
+	if v.Blobs != nil {
+		v.Blobs.Unpin()
+		v.Blobs = nil
+	}
+}
+
 type decoder struct {
 	r   *bufio.Reader
 	buf []byte
+	rev byte
 }
 
 func newDecoder(r io.Reader) *decoder {
 	return &decoder{
 		r:   bufio.NewReader(r),
 		buf: make([]byte, 8),
+		rev: snapshotRevision,
 	}
 }
 
@@ -161,6 +444,78 @@ func (d *decoder) DecodeNodes() (*node, error) {
 
 	n.Access = access
 
+	if d.rev >= 1 {
+		n.Version, err = d.DecodeUint64()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if d.rev >= 3 {
+		count, err := d.DecodeUint64()
+		if err != nil {
+			return nil, err
+		}
+
+		for range count {
+			tag, err := d.DecodeBytes()
+			if err != nil {
+				return nil, err
+			}
+
+			n.Tags = append(n.Tags, string(tag))
+		}
+	}
+
+	if d.rev >= 4 {
+		n.Generation, err = d.DecodeUint64()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if d.rev >= 6 {
+		created, err := d.DecodeUint64()
+		if err != nil {
+			return nil, err
+		}
+
+		accessed, err := d.DecodeUint64()
+		if err != nil {
+			return nil, err
+		}
+
+		n.CreatedAt, n.AccessedAt = int64(created), int64(accessed)
+
+		n.Hits, err = d.DecodeUint64()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if d.rev >= 7 {
+		nsec, err := d.DecodeUint64()
+		if err != nil {
+			return nil, err
+		}
+
+		if !n.Expiration.IsZero() {
+			n.Expiration = n.Expiration.Add(time.Duration(nsec))
+		}
+
+		protected, err := d.DecodeUint64()
+		if err != nil {
+			return nil, err
+		}
+
+		n.Protected = protected != 0
+
+		n.LastAccess, err = d.DecodeUint64()
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	n.Key, err = d.DecodeBytes()
 	if err != nil {
 		return nil, err
@@ -180,15 +535,82 @@ func (d *decoder) DecodeStore(s *store) error {
 		return err
 	}
 
-	s.MaxCost = maxCost
-
 	policy, err := d.DecodeUint64()
 	if err != nil {
 		return err
 	}
 
-	if err := s.Policy.SetPolicy(EvictionPolicyType(policy)); err != nil {
-		return err
+	var name []byte
+	if d.rev >= 5 {
+		name, err = d.DecodeBytes()
+		if err != nil {
+			return err
+		}
+	}
+
+	var settings [4]uint64 // SLRU ratio, sample size, clock and generation.
+	if d.rev >= 7 {
+		for i := range settings {
+			settings[i], err = d.DecodeUint64()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Snapshots from earlier revisions keep the configured setting.
+	unlimited := s.Unlimited
+	if d.rev >= 9 {
+		flag, err := d.DecodeUint64()
+		if err != nil {
+			return err
+		}
+
+		unlimited = flag != 0
+	}
+
+	if missingMaxCost(EvictionPolicyType(policy), maxCost, unlimited) {
+		return ErrNoMaxCost
+	}
+
+	s.MaxCost = maxCost
+	s.Unlimited = unlimited
+
+	// The policy settings are restored before the policy, which reads them. Unset
+	// settings leave the configured ones, like snapshots from earlier revisions.
+	if d.rev >= 7 {
+		if settings[0] != 0 {
+			s.Policy.SLRURatio = math.Float64frombits(settings[0])
+		}
+
+		if settings[1] != 0 {
+			s.Policy.SampleSize = int(settings[1])
+		}
+
+		s.Policy.Clock = settings[2]
+		s.Generation = settings[3]
+	}
+
+	// An unknown custom policy is reported after the entries are loaded.
+	unknown := s.Policy.restorePolicy(EvictionPolicyType(policy), string(name))
+	if unknown != nil && !errors.Is(unknown, ErrUnknownPolicy) {
+		return unknown
+	}
+
+	// The intervals only replace the running ones once every entry is loaded.
+	var intervals *[3]time.Duration
+
+	if d.rev >= 2 {
+		intervals = &[3]time.Duration{}
+
+		for i := range intervals {
+			interval, err := d.DecodeUint64()
+			if err != nil {
+				return err
+			}
+
+			intervals[i] = time.Duration(interval)
+		}
 	}
 
 	length, err := d.DecodeUint64()
@@ -196,44 +618,343 @@ func (d *decoder) DecodeStore(s *store) error {
 		return err
 	}
 
-	s.Length = length
+	duplicates := 0
 
-	k := initialBucketSize
-	for float64(s.Length)/float64(k) > float64(loadFactor) {
-		k = k * 2
+	s.Bucket = newBuckets(s.capBuckets(bucketSize(length, int(initialBucketSize))))
+	for range length {
+		v, err := d.DecodeNodes()
+		if err != nil {
+			return err
+		}
+
+		if err := d.upgrade(s, v); err != nil {
+			return err
+		}
+
+		replaced, err := s.restore(v)
+		if err != nil {
+			return err
+		}
+
+		if replaced {
+			duplicates++
+		}
 	}
 
-	s.Bucket = make([]node, k)
-	for range s.Length {
-		v, err := d.DecodeNodes()
+	if intervals != nil {
+		s.resetIntervals(*intervals)
+	}
+
+	return errors.Join(unknown, duplicateKeyError(duplicates))
+}
+
+// resetIntervals restarts the snapshot, forced snapshot and cleanup tickers with the
+// intervals of a loaded snapshot. The tickers guard their intervals, so they are reset
+// while the background worker keeps waiting on them.
+func (s *store) resetIntervals(intervals [3]time.Duration) {
+	for i, t := range []*pausedtimer.PauseTimer{s.SnapshotTicker, s.ForceTicker, s.CleanupTicker} {
+		t.Reset(intervals[i])
+	}
+}
+
+// restore links a node loaded from a snapshot at the back of the eviction list.
+// An earlier node with the same key is removed, and replaced reports whether there was one.
+// The hash read from the snapshot is not trusted but recomputed with the current
+// hasher, so a snapshot written with another hasher or holding corrupted hashes still
+// places every key in the right bucket.
+func (s *store) restore(v *node) (bool, error) {
+	old, idx, hash := s.lookup(v.Key)
+	v.Hash = hash
+
+	if err := s.setValue(v, v.Value); err != nil {
+		return false, err
+	}
+
+	if old != nil {
+		deleteNode(s, old)
+	}
+
+	bucket := &s.Bucket[idx]
+
+	v.HashPrev = bucket
+	v.HashNext = v.HashPrev.HashNext
+	v.HashNext.HashPrev = v
+	v.HashPrev.HashNext = v
+
+	v.EvictNext = &s.EvictList
+	v.EvictPrev = v.EvictNext.EvictPrev
+	v.EvictNext.EvictPrev = v
+	v.EvictPrev.EvictNext = v
+	s.Policy.restored(v)
+
+	s.Cost = s.Cost + s.cost(v)
+	s.Length = s.Length + 1
+	s.stamp(v)
+	s.Generation = max(s.Generation, v.Generation)
+
+	tags := v.Tags
+	v.Tags = nil
+	s.setTags(v, tags)
+
+	if s.Wheel != nil {
+		s.Wheel.Add(v)
+	}
+
+	return old != nil, nil
+}
+
+// msgpackSnapshot is the document written by FormatMsgpack.
+type msgpackSnapshot struct {
+	MaxCost    uint64                 `msgpack:"max_cost"`
+	Unlimited  bool                   `msgpack:"unlimited,omitempty"`
+	Policy     EvictionPolicyType     `msgpack:"policy"`
+	PolicyName string                 `msgpack:"policy_name,omitempty"`
+	SLRURatio  float64                `msgpack:"slru_ratio,omitempty"`
+	SampleSize int                    `msgpack:"sample_size,omitempty"`
+	Clock      uint64                 `msgpack:"clock,omitempty"`
+	Generation uint64                 `msgpack:"generation,omitempty"`
+	Intervals  *msgpackIntervals      `msgpack:"intervals,omitempty"`
+	Entries    []msgpackSnapshotEntry `msgpack:"entries"`
+}
+
+// msgpackIntervals holds the maintenance intervals of a msgpack snapshot.
+type msgpackIntervals struct {
+	Snapshot time.Duration `msgpack:"snapshot"`
+	Force    time.Duration `msgpack:"force"`
+	Cleanup  time.Duration `msgpack:"cleanup"`
+}
+
+// msgpackSnapshotEntry is an entry of a msgpack snapshot, in eviction order.
+type msgpackSnapshotEntry struct {
+	Key        []byte       `msgpack:"key"`
+	Value      msgpackValue `msgpack:"value"`
+	Expiration time.Time    `msgpack:"expiration,omitempty"`
+	Access     uint64       `msgpack:"access"`
+	Protected  bool         `msgpack:"protected,omitempty"`
+	LastAccess uint64       `msgpack:"last_access,omitempty"`
+	Version    uint64       `msgpack:"version,omitempty"`
+	Generation uint64       `msgpack:"generation,omitempty"`
+	Tags       []string     `msgpack:"tags,omitempty"`
+	CreatedAt  int64        `msgpack:"created_at,omitempty"`
+	AccessedAt int64        `msgpack:"accessed_at,omitempty"`
+	Hits       uint64       `msgpack:"hits,omitempty"`
+}
+
+// msgpackValue is the value of a msgpack snapshot entry. A spilled value is read from
+// Blobs only when its entry is encoded, so they are not all held in memory at once.
+type msgpackValue struct {
+	Bytes []byte
+	Blobs *blobStore // Blobs is set when Bytes is a reference to a spilled value.
+}
+
+func (v msgpackValue) EncodeMsgpack(enc *msgpack.Encoder) error {
+	value := v.Bytes
+	if v.Blobs != nil {
+		var err error
+		if value, err = v.Blobs.Load(v.Bytes); err != nil {
+			return err
+		}
+	}
+
+	return enc.EncodeBytes(value)
+}
+
+func (v *msgpackValue) DecodeMsgpack(dec *msgpack.Decoder) error {
+	value, err := dec.DecodeBytes()
+	if err != nil {
+		return err
+	}
+
+	v.Bytes = value
+
+	return nil
+}
+
+// EncodeMsgpack writes the store as a single msgpack document.
+func (e *encoder) EncodeMsgpack(view *snapshotView) error {
+	snapshot := msgpackSnapshot{
+		MaxCost:    view.MaxCost,
+		Unlimited:  view.Unlimited,
+		Policy:     view.Policy,
+		PolicyName: view.PolicyName,
+		SLRURatio:  view.SLRURatio,
+		SampleSize: view.SampleSize,
+		Clock:      view.Clock,
+		Generation: view.Generation,
+		Intervals: &msgpackIntervals{
+			Snapshot: view.Intervals[0],
+			Force:    view.Intervals[1],
+			Cleanup:  view.Intervals[2],
+		},
+		Entries: make([]msgpackSnapshotEntry, 0, len(view.Nodes)),
+	}
+
+	for _, v := range view.Nodes {
+		value := msgpackValue{Bytes: v.Value}
+		if v.Blob {
+			value.Blobs = view.Blobs
+		}
+
+		snapshot.Entries = append(snapshot.Entries, msgpackSnapshotEntry{
+			Key:        v.Key,
+			Value:      value,
+			Expiration: v.Expiration,
+			Access:     v.Access,
+			Protected:  v.Protected,
+			LastAccess: v.LastAccess,
+			Version:    v.Version,
+			Generation: v.Generation,
+			Tags:       v.Tags,
+			CreatedAt:  v.CreatedAt,
+			AccessedAt: v.AccessedAt,
+			Hits:       v.Hits,
+		})
+	}
+
+	return msgpack.NewEncoder(e.w).Encode(&snapshot)
+}
+
+// DecodeMsgpack reads a store written by EncodeMsgpack.
+func (d *decoder) DecodeMsgpack(s *store) error {
+	var snapshot msgpackSnapshot
+	if err := msgpack.NewDecoder(d.r).Decode(&snapshot); err != nil {
+		return err
+	}
+
+	// Snapshots from earlier revisions keep the configured setting.
+	unlimited := s.Unlimited
+	if d.rev >= 9 {
+		unlimited = snapshot.Unlimited
+	}
+
+	if missingMaxCost(snapshot.Policy, snapshot.MaxCost, unlimited) {
+		return ErrNoMaxCost
+	}
+
+	s.MaxCost = snapshot.MaxCost
+	s.Unlimited = unlimited
+	s.Policy.Clock = snapshot.Clock
+	s.Generation = snapshot.Generation
+
+	// Unset policy settings, as in snapshots from earlier versions, leave the configured ones.
+	if snapshot.SLRURatio != 0 {
+		s.Policy.SLRURatio = snapshot.SLRURatio
+	}
+
+	if snapshot.SampleSize != 0 {
+		s.Policy.SampleSize = snapshot.SampleSize
+	}
+
+	unknown := s.Policy.restorePolicy(snapshot.Policy, snapshot.PolicyName)
+	if unknown != nil && !errors.Is(unknown, ErrUnknownPolicy) {
+		return unknown
+	}
+
+	var intervals *[3]time.Duration
+
+	if i := snapshot.Intervals; i != nil {
+		intervals = &[3]time.Duration{i.Snapshot, i.Force, i.Cleanup}
+	}
+
+	duplicates := 0
+
+	s.Bucket = newBuckets(s.capBuckets(bucketSize(uint64(len(snapshot.Entries)), int(initialBucketSize))))
+
+	for _, e := range snapshot.Entries {
+		v := &node{
+			Hash:       s.hash(e.Key),
+			Key:        e.Key,
+			Value:      e.Value.Bytes,
+			Expiration: e.Expiration,
+			Access:     e.Access,
+			Protected:  e.Protected,
+			LastAccess: e.LastAccess,
+			Version:    e.Version,
+			Generation: e.Generation,
+			Tags:       e.Tags,
+			CreatedAt:  e.CreatedAt,
+			AccessedAt: e.AccessedAt,
+			Hits:       e.Hits,
+		}
+
+		if err := d.upgrade(s, v); err != nil {
+			return err
+		}
+
+		replaced, err := s.restore(v)
 		if err != nil {
 			return err
 		}
 
-		idx := v.Hash % uint64(len(s.Bucket))
+		if replaced {
+			duplicates++
+		}
+	}
 
-		bucket := &s.Bucket[idx]
-		lazyInitBucket(bucket)
+	if intervals != nil {
+		s.resetIntervals(*intervals)
+	}
 
-		v.HashPrev = bucket
-		v.HashNext = v.HashPrev.HashNext
-		v.HashNext.HashPrev = v
-		v.HashPrev.HashNext = v
+	return errors.Join(unknown, duplicateKeyError(duplicates))
+}
 
-		v.EvictNext = &s.EvictList
-		v.EvictPrev = v.EvictNext.EvictPrev
-		v.EvictNext.EvictPrev = v
-		v.EvictPrev.EvictNext = v
+// upgrade converts a node read from a snapshot older than rawRevision with the
+// store's Upgrade function, if any.
+func (d *decoder) upgrade(s *store, v *node) error {
+	if d.rev >= rawRevision || s.Upgrade == nil {
+		return nil
+	}
 
-		s.Cost = s.Cost + v.Cost()
+	key, value, err := s.Upgrade(v.Key, v.Value)
+	if err != nil {
+		return err
 	}
 
+	v.Key, v.Value = key, value
+
 	return nil
 }
 
+// Snapshot writes the store to w. The lock is only held while the store is copied,
+// so reads and writes continue while the snapshot is encoded and written, and the
+// snapshot reflects the store at the time of the copy. Snapshots are serialized
+// with each other, so an older copy never overwrites a newer one.
 func (s *store) Snapshot(w io.Writer) error {
+	s.SnapshotLock.Lock()
+	defer s.SnapshotLock.Unlock()
+
 	s.Lock.RLock()
-	defer s.Lock.RUnlock()
+
+	view, err := s.view()
+	if err == nil {
+		// Writes made from now on are not part of this snapshot.
+		s.Dirty.Store(false)
+	}
+
+	format, size := s.Format, s.SnapshotBufferSize
+
+	s.Lock.RUnlock()
+
+	if err != nil {
+		return err
+	}
+
+	defer view.release()
+
+	if err := writeSnapshot(w, view, format, size); err != nil {
+		s.Dirty.Store(true)
+
+		return err
+	}
+
+	return nil
+}
+
+// writeSnapshot encodes a captured view to w.
+func writeSnapshot(w io.Writer, view *snapshotView, format SnapshotFormat, size int) error {
+	if err := view.filter(); err != nil {
+		return err
+	}
 
 	if seeker, ok := w.(io.Seeker); ok {
 		if _, err := seeker.Seek(0, io.SeekStart); err != nil {
@@ -241,9 +962,31 @@ func (s *store) Snapshot(w io.Writer) error {
 		}
 	}
 
-	wr := newEncoder(w)
+	wr := newEncoderSize(w, size)
+
+	if _, err := wr.w.Write(snapshotMagic); err != nil {
+		return err
+	}
+
+	if err := wr.w.WriteByte(byte(format)); err != nil {
+		return err
+	}
+
+	if err := wr.w.WriteByte(wr.rev); err != nil {
+		return err
+	}
+
+	var err error
+
+	switch format {
+	case FormatBinary:
+		err = wr.EncodeStore(view)
+	case FormatMsgpack:
+		err = wr.EncodeMsgpack(view)
+	default:
+		err = ErrInvalidFormat
+	}
 
-	err := wr.EncodeStore(s)
 	if err != nil {
 		return err
 	}
@@ -251,6 +994,7 @@ func (s *store) Snapshot(w io.Writer) error {
 	return wr.Flush()
 }
 
+// LoadSnapshot reads a snapshot from the start of r, seeking to it if r is an io.Seeker.
 func (s *store) LoadSnapshot(r io.Reader) error {
 	if seeker, ok := r.(io.Seeker); ok {
 		if _, err := seeker.Seek(0, io.SeekStart); err != nil {
@@ -258,7 +1002,70 @@ func (s *store) LoadSnapshot(r io.Reader) error {
 		}
 	}
 
+	return s.LoadSnapshotReader(r)
+}
+
+// LoadSnapshotReader reads a snapshot from the current position of r.
+// Unlike LoadSnapshot it never seeks, so r may be a pipe, a network connection
+// or a decompressing reader.
+func (s *store) LoadSnapshotReader(r io.Reader) error {
 	d := newDecoder(r)
 
-	return d.DecodeStore(s)
+	format := FormatBinary
+	d.rev = 0
+
+	header, err := d.r.Peek(len(snapshotMagic) + 2)
+	if err == nil && bytes.Equal(header[:len(snapshotMagic)], snapshotMagic) {
+		format = SnapshotFormat(header[len(snapshotMagic)])
+		d.rev = header[len(snapshotMagic)+1]
+
+		if d.rev > snapshotRevision {
+			return ErrInvalidFormat
+		}
+
+		if _, err := d.r.Discard(len(header)); err != nil {
+			return err
+		}
+	}
+
+	switch format {
+	case FormatBinary:
+		return d.DecodeStore(s)
+	case FormatMsgpack:
+		return d.DecodeMsgpack(s)
+	default:
+		return ErrInvalidFormat
+	}
+}
+
+// dumpEntry is the JSON representation of a node used by DumpJSON.
+type dumpEntry struct {
+	Key        []byte    `json:"key"`
+	Value      []byte    `json:"value"`
+	Expiration time.Time `json:"expiration,omitzero"`
+	Access     uint64    `json:"access"`
+}
+
+// DumpJSON writes the entries of the store as a JSON array for inspection.
+// The output is not meant to be loaded back.
+func (s *store) DumpJSON(w io.Writer) error {
+	s.Lock.RLock()
+	defer s.Lock.RUnlock()
+
+	entries := make([]dumpEntry, 0, s.Length)
+	for v := s.EvictList.EvictNext; v != &s.EvictList; v = v.EvictNext {
+		value, err := s.value(v)
+		if err != nil {
+			return err
+		}
+
+		entries = append(entries, dumpEntry{
+			Key:        v.Key,
+			Value:      value,
+			Expiration: v.Expiration,
+			Access:     v.Access,
+		})
+	}
+
+	return json.NewEncoder(w).Encode(entries)
 }
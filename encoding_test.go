@@ -3,10 +3,17 @@ package cache
 import (
 	"bytes"
 	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
 	"os"
+	"slices"
 	"strconv"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
 )
 
 func TestDecodeUint64Error(t *testing.T) {
@@ -199,6 +206,7 @@ func TestEncodeDecodeNode(t *testing.T) {
 				Hash:       1234567890,
 				Expiration: time.Now(),
 				Access:     987654321,
+				Version:    42,
 				Key:        []byte("testKey"),
 				Value:      []byte("testValue"),
 			},
@@ -241,6 +249,10 @@ func TestEncodeDecodeNode(t *testing.T) {
 				t.Errorf("expected %v, got %v", tt.value.Access, decodedValue.Access)
 			}
 
+			if tt.value.Version != decodedValue.Version {
+				t.Errorf("expected %v, got %v", tt.value.Version, decodedValue.Version)
+			}
+
 			if !bytes.Equal(tt.value.Key, decodedValue.Key) {
 				t.Errorf("expected %v, got %v", tt.value.Key, decodedValue.Key)
 			}
@@ -354,6 +366,785 @@ func TestStoreSnapshot(t *testing.T) {
 	}
 }
 
+func TestStoreSnapshotFormat(t *testing.T) {
+	t.Parallel()
+
+	for name, format := range map[string]SnapshotFormat{"Binary": FormatBinary, "Msgpack": FormatMsgpack} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			want := setupTestStore(t)
+			want.Format = format
+			want.MaxCost = 100
+
+			if err := want.Policy.SetPolicy(PolicyLRU); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			want.Set([]byte("1"), []byte("One"), time.Hour)
+			want.Set([]byte("2"), []byte("Two"), 0)
+
+			var buf bytes.Buffer
+			if err := want.Snapshot(&buf); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got := SnapshotFormat(buf.Bytes()[len(snapshotMagic)]); got != format {
+				t.Fatalf("expected format %v in header, got %v", format, got)
+			}
+
+			got := setupTestStore(t)
+			if err := got.LoadSnapshot(bytes.NewReader(buf.Bytes())); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got.MaxCost != want.MaxCost || got.Unlimited != want.Unlimited || got.Policy.Type != want.Policy.Type || got.Length != want.Length {
+				t.Fatalf("expected %v %v %v, got %v %v %v",
+					want.MaxCost, want.Policy.Type, want.Length, got.MaxCost, got.Policy.Type, got.Length)
+			}
+
+			for k, v := range map[string]string{"1": "One", "2": "Two"} {
+				gotVal, _, ok := got.Get([]byte(k))
+				if !ok {
+					t.Fatalf("expected key %s to exist", k)
+				}
+
+				if string(gotVal) != v {
+					t.Fatalf("expected %v, got %v", v, string(gotVal))
+				}
+			}
+		})
+	}
+
+	t.Run("Msgpack Interop", func(t *testing.T) {
+		t.Parallel()
+
+		want := setupTestStore(t)
+		want.Format = FormatMsgpack
+		want.Set([]byte("Key"), []byte("Value"), 0)
+
+		var buf bytes.Buffer
+		if err := want.Snapshot(&buf); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		var doc map[string]any
+		if err := msgpack.Unmarshal(buf.Bytes()[len(snapshotMagic)+2:], &doc); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		entries, ok := doc["entries"].([]any)
+		if !ok || len(entries) != 1 {
+			t.Fatalf("expected one entry, got %v", doc["entries"])
+		}
+
+		entry, ok := entries[0].(map[string]any)
+		if !ok {
+			t.Fatalf("expected entry to be a map, got %T", entries[0])
+		}
+
+		if !bytes.Equal(entry["key"].([]byte), []byte("Key")) {
+			t.Fatalf("expected key %q, got %v", "Key", entry["key"])
+		}
+	})
+
+	t.Run("Headerless", func(t *testing.T) {
+		t.Parallel()
+
+		want := setupTestStore(t)
+		want.Set([]byte("Key"), []byte("Value"), 0)
+
+		var buf bytes.Buffer
+
+		e := newEncoder(&buf)
+		e.rev = 0
+
+		view, err := want.view()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if err := e.EncodeStore(view); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if err := e.Flush(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		got := setupTestStore(t)
+		if err := got.LoadSnapshot(bytes.NewReader(buf.Bytes())); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if _, _, ok := got.Get([]byte("Key")); !ok {
+			t.Fatalf("expected key to exist")
+		}
+	})
+}
+
+func TestStoreSnapshotUnlimited(t *testing.T) {
+	t.Parallel()
+
+	for name, format := range map[string]SnapshotFormat{"Binary": FormatBinary, "Msgpack": FormatMsgpack} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			want := setupTestStore(t)
+			want.Format = format
+			want.Unlimited = true
+
+			if err := want.Policy.SetPolicy(PolicyLRU); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if err := want.Set([]byte("Key"), []byte("Value"), 0); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var buf bytes.Buffer
+			if err := want.Snapshot(&buf); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			got := setupTestStore(t)
+			if err := got.LoadSnapshot(bytes.NewReader(buf.Bytes())); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !got.Unlimited || got.Policy.Type != PolicyLRU {
+				t.Fatalf("expected an unlimited %v store, got unlimited %v with %v", PolicyLRU, got.Unlimited, got.Policy.Type)
+			}
+
+			// A policy without a limit, as left by SetMaxCost(0) before it was rejected.
+			want.Unlimited = false
+
+			buf.Reset()
+			if err := want.Snapshot(&buf); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			got = setupTestStore(t)
+			got.MaxCost = 100
+
+			if err := got.LoadSnapshot(bytes.NewReader(buf.Bytes())); !errors.Is(err, ErrNoMaxCost) {
+				t.Fatalf("expected error: %v, got %v", ErrNoMaxCost, err)
+			}
+
+			if got.MaxCost != 100 || got.Policy.Type != PolicyNone || got.Length != 0 {
+				t.Fatalf("expected the store to be unchanged, got max cost %d, %v and %d entries", got.MaxCost, got.Policy.Type, got.Length)
+			}
+		})
+	}
+}
+
+func TestStoreSnapshotRevisions(t *testing.T) {
+	t.Parallel()
+
+	for rev := range snapshotRevision + 1 {
+		t.Run(strconv.Itoa(int(rev)), func(t *testing.T) {
+			t.Parallel()
+
+			want := setupTestStore(t)
+			if err := want.SetTagged([]byte("Key"), []byte("Value"), time.Hour, []string{"Tag"}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var buf bytes.Buffer
+
+			e := newEncoder(&buf)
+			e.rev = rev
+
+			// Revision 0 files predate the header.
+			if rev > 0 {
+				if _, err := e.w.Write(append(slices.Clone(snapshotMagic), byte(FormatBinary), rev)); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			view, err := want.view()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if err := e.EncodeStore(view); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if err := e.Flush(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			got := setupTestStore(t)
+			if err := got.LoadSnapshot(bytes.NewReader(buf.Bytes())); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			value, ttl, ok := got.Get([]byte("Key"))
+			if !ok {
+				t.Fatalf("expected key to exist")
+			}
+
+			if !bytes.Equal(value, []byte("Value")) {
+				t.Errorf("expected value %q, got %q", "Value", value)
+			}
+
+			if ttl <= 0 || ttl > time.Hour {
+				t.Errorf("expected TTL of at most an hour, got %v", ttl)
+			}
+
+			wantTagged := 0
+			if rev >= 3 {
+				wantTagged = 1
+			}
+
+			if n := got.InvalidateTag("Tag"); n != wantTagged {
+				t.Errorf("expected %d tagged entries, got %d", wantTagged, n)
+			}
+		})
+	}
+}
+
+func TestStoreSnapshotVersion(t *testing.T) {
+	t.Parallel()
+
+	for name, format := range map[string]SnapshotFormat{"Binary": FormatBinary, "Msgpack": FormatMsgpack} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			want := setupTestStore(t)
+			want.Format = format
+
+			if _, err := want.SetIfNewer([]byte("Key"), []byte("Value"), 7, 0); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var buf bytes.Buffer
+			if err := want.Snapshot(&buf); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			got := setupTestStore(t)
+			if err := got.LoadSnapshot(bytes.NewReader(buf.Bytes())); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if ok, _ := got.SetIfNewer([]byte("Key"), []byte("Stale"), 7, 0); ok {
+				t.Fatalf("expected version to survive the snapshot")
+			}
+
+			if ok, _ := got.SetIfNewer([]byte("Key"), []byte("Fresh"), 8, 0); !ok {
+				t.Fatalf("expected newer version to apply")
+			}
+		})
+	}
+}
+
+func TestStoreSnapshotWrongHash(t *testing.T) {
+	t.Parallel()
+
+	hashers := map[string]func(key []byte) uint64{
+		"Default": nil,
+		"Custom": func(key []byte) uint64 {
+			return uint64(len(key)) * 31
+		},
+	}
+
+	for name, hasher := range hashers {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			want := setupTestStore(t)
+
+			for i := range 100 {
+				want.Set([]byte(strconv.Itoa(i)), []byte("Value"), 0)
+			}
+
+			// Corrupt every stored hash, as a snapshot from another hasher would have.
+			for v := want.EvictList.EvictNext; v != &want.EvictList; v = v.EvictNext {
+				v.Hash = ^v.Hash
+			}
+
+			var buf bytes.Buffer
+			if err := want.Snapshot(&buf); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			got := setupTestStore(t)
+			if hasher != nil {
+				got.setHasher(hasher)
+			}
+
+			if err := got.LoadSnapshot(bytes.NewReader(buf.Bytes())); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// Growing the table rehashes with the stored node hashes.
+			for i := 100; i < 1000; i++ {
+				got.Set([]byte(strconv.Itoa(i)), []byte("Value"), 0)
+			}
+
+			for i := range 1000 {
+				if _, _, ok := got.Get([]byte(strconv.Itoa(i))); !ok {
+					t.Fatalf("expected key %d to be found", i)
+				}
+			}
+		})
+	}
+}
+
+func TestStoreSnapshotDuplicateKey(t *testing.T) {
+	t.Parallel()
+
+	for name, format := range map[string]SnapshotFormat{"Binary": FormatBinary, "Msgpack": FormatMsgpack} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			want := setupTestStore(t)
+			want.Format = format
+			want.Set([]byte("Key"), []byte("First"), 0)
+			want.Set([]byte("Other"), []byte("Second"), 0)
+
+			// Rename the last entry in eviction order so the snapshot holds a key twice.
+			first, last := want.EvictList.EvictNext, want.EvictList.EvictPrev
+			last.Key = first.Key
+
+			var buf bytes.Buffer
+			if err := want.Snapshot(&buf); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			got := setupTestStore(t)
+
+			err := got.LoadSnapshot(bytes.NewReader(buf.Bytes()))
+
+			var dup *DuplicateKeyError
+			if !errors.As(err, &dup) {
+				t.Fatalf("expected error: %v, got %v", ErrDuplicateKey, err)
+			}
+
+			if dup.Count != 1 {
+				t.Errorf("expected 1 duplicate, got %d", dup.Count)
+			}
+
+			if got.Length != 1 {
+				t.Errorf("expected length 1, got %d", got.Length)
+			}
+
+			if v := got.EvictList.EvictNext; got.Cost != got.cost(v) {
+				t.Errorf("expected cost %d, got %d", got.cost(v), got.Cost)
+			}
+
+			value, _, ok := got.Get(first.Key)
+			if !ok {
+				t.Fatalf("expected key to exist")
+			}
+
+			if !bytes.Equal(value, last.Value) {
+				t.Errorf("expected value %q, got %q", last.Value, value)
+			}
+		})
+	}
+}
+
+func TestStoreSnapshotFilter(t *testing.T) {
+	t.Parallel()
+
+	for name, format := range map[string]SnapshotFormat{"Binary": FormatBinary, "Msgpack": FormatMsgpack} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			want := setupTestStore(t)
+			want.Format = format
+			want.SnapshotFilter = func(key, value []byte, ttl time.Duration) bool {
+				return ttl == 0
+			}
+
+			for i := range 10 {
+				ttl := time.Duration(0)
+				if i%2 == 1 {
+					ttl = time.Hour
+				}
+
+				want.Set([]byte(strconv.Itoa(i)), []byte("Value"), ttl)
+			}
+
+			var buf bytes.Buffer
+			if err := want.Snapshot(&buf); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			got := setupTestStore(t)
+			if err := got.LoadSnapshot(bytes.NewReader(buf.Bytes())); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got.Length != 5 {
+				t.Errorf("expected 5 entries, got %d", got.Length)
+			}
+
+			for i := range 10 {
+				_, _, ok := got.Get([]byte(strconv.Itoa(i)))
+				if want := i%2 == 0; ok != want {
+					t.Errorf("expected key %d to exist: %v, got %v", i, want, ok)
+				}
+			}
+		})
+	}
+}
+
+// slowWriter is an io.Writer that sleeps on every write and signals the first one.
+type slowWriter struct {
+	bytes.Buffer
+	delay   time.Duration
+	started chan struct{}
+	once    sync.Once
+}
+
+func (w *slowWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() { close(w.started) })
+	time.Sleep(w.delay)
+
+	return w.Buffer.Write(p)
+}
+
+func TestStoreSnapshotConcurrentWrites(t *testing.T) {
+	t.Parallel()
+
+	want := setupTestStore(t)
+	want.SnapshotBufferSize = 64
+
+	for i := range 100 {
+		key := []byte(strconv.Itoa(i))
+		want.Set(key, key, 0)
+	}
+
+	w := &slowWriter{delay: 5 * time.Millisecond, started: make(chan struct{})}
+	done := make(chan error, 1)
+
+	go func() {
+		done <- want.Snapshot(w)
+	}()
+
+	<-w.started
+
+	// The store was copied before the first write, so these must not wait for the snapshot.
+	for i := range 200 {
+		key := []byte(strconv.Itoa(i))
+
+		start := time.Now()
+		if err := want.Set(key, []byte("Changed"), 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+			t.Fatalf("expected Set not to wait for the snapshot, took %v", elapsed)
+		}
+
+		if i%2 == 0 {
+			want.Delete(key)
+		}
+	}
+
+	select {
+	case <-done:
+		t.Fatalf("expected the snapshot to still be running")
+	default:
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !want.Dirty.Load() {
+		t.Errorf("expected writes during the snapshot to keep the store dirty")
+	}
+
+	got := setupTestStore(t)
+	if err := got.LoadSnapshot(bytes.NewReader(w.Bytes())); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got.Length != 100 {
+		t.Fatalf("expected 100 entries, got %d", got.Length)
+	}
+
+	for i := range 100 {
+		key := []byte(strconv.Itoa(i))
+
+		value, _, ok := got.Get(key)
+		if !ok {
+			t.Fatalf("expected key %d to exist", i)
+		}
+
+		if !bytes.Equal(value, key) {
+			t.Fatalf("expected value %q, got %q", key, value)
+		}
+	}
+}
+
+func TestStoreSnapshotGeneration(t *testing.T) {
+	t.Parallel()
+
+	for name, format := range map[string]SnapshotFormat{"Binary": FormatBinary, "Msgpack": FormatMsgpack} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			want := setupTestStore(t)
+			want.Format = format
+
+			for range 3 {
+				want.Set([]byte("Key"), []byte("Value"), 0)
+			}
+
+			_, _, generation, _ := want.GetWithVersion([]byte("Key"))
+
+			var buf bytes.Buffer
+			if err := want.Snapshot(&buf); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			got := setupTestStore(t)
+			if err := got.LoadSnapshot(bytes.NewReader(buf.Bytes())); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if _, _, g, _ := got.GetWithVersion([]byte("Key")); g != generation {
+				t.Fatalf("expected generation %d, got %d", generation, g)
+			}
+
+			if ok, _ := got.SetIfVersion([]byte("Key"), []byte("Changed"), generation, 0); !ok {
+				t.Fatalf("expected the loaded generation to match")
+			}
+
+			got.Set([]byte("Other"), []byte("Value"), 0)
+
+			if _, _, g, _ := got.GetWithVersion([]byte("Other")); g <= generation {
+				t.Fatalf("expected new generations to continue after %d, got %d", generation, g)
+			}
+		})
+	}
+}
+
+func TestStoreSnapshotBucketSize(t *testing.T) {
+	t.Parallel()
+
+	for _, n := range []int{0, 1, 7, 8, 9, 100, 1000} {
+		t.Run(strconv.Itoa(n), func(t *testing.T) {
+			t.Parallel()
+
+			want := setupTestStore(t)
+
+			for i := range n {
+				key := binary.LittleEndian.AppendUint64(nil, uint64(i))
+				want.Set(key, key, 0)
+			}
+
+			var buf bytes.Buffer
+			if err := want.Snapshot(&buf); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			got := setupTestStore(t)
+			if err := got.LoadSnapshot(bytes.NewReader(buf.Bytes())); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(got.Bucket) != len(want.Bucket) {
+				t.Errorf("expected %d buckets, got %d", len(want.Bucket), len(got.Bucket))
+			}
+		})
+	}
+}
+
+func TestCacheLoadSnapshotReader(t *testing.T) {
+	t.Parallel()
+
+	want := setupTestStore(t)
+
+	for _, k := range []string{"1", "2", "3"} {
+		if err := want.Set([]byte(k), []byte("Value"+k), 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	db, err := OpenRawMem()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	if err := db.Set([]byte("Stale"), []byte("Value"), 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// A pipe cannot seek, so the snapshot has to be decoded as a stream.
+	pr, pw := io.Pipe()
+
+	go func() {
+		pw.CloseWithError(want.Snapshot(pw))
+	}()
+
+	if err := db.LoadSnapshotReader(pr); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, _, err := db.GetValue([]byte("Stale")); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected error: %v, got %v", ErrKeyNotFound, err)
+	}
+
+	for _, k := range []string{"1", "2", "3"} {
+		got, _, err := db.GetValue([]byte(k))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if string(got) != "Value"+k {
+			t.Fatalf("expected Value%s, got %s", k, got)
+		}
+	}
+}
+
+func TestCacheLoadSnapshotIntervals(t *testing.T) {
+	t.Parallel()
+
+	want := setupTestStore(t)
+	want.SnapshotTicker.Reset(time.Hour)
+
+	if err := want.Set([]byte("Key"), []byte("Value"), 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := want.Snapshot(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The background worker keeps running while snapshots are loaded.
+	db, err := OpenRawMem(SetSnapshotTime(time.Millisecond))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	// A snapshot that fails to load leaves the running intervals alone.
+	if err := db.LoadSnapshotReader(bytes.NewReader(buf.Bytes()[:buf.Len()-1])); err == nil {
+		t.Fatalf("expected error loading a truncated snapshot")
+	}
+
+	if got := db.Store.SnapshotTicker.GetDuration(); got != time.Millisecond {
+		t.Fatalf("expected interval %v, got %v", time.Millisecond, got)
+	}
+
+	if err := db.LoadSnapshotReader(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := db.Store.SnapshotTicker.GetDuration(); got != time.Hour {
+		t.Fatalf("expected interval %v, got %v", time.Hour, got)
+	}
+}
+
+func TestCacheSnapshotBytes(t *testing.T) {
+	t.Parallel()
+
+	want := setupTestCache[string, int](t)
+
+	for i := range 100 {
+		if err := want.Set(strconv.Itoa(i), i, time.Hour); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	data, err := want.SnapshotBytes()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got := setupTestCache[string, int](t)
+	if err := got.LoadSnapshotBytes(data); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got.Store.Length != want.Store.Length {
+		t.Fatalf("expected %d entries, got %d", want.Store.Length, got.Store.Length)
+	}
+
+	for i := range 100 {
+		value, ttl, err := got.GetValue(strconv.Itoa(i))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if value != i {
+			t.Fatalf("expected %d, got %d", i, value)
+		}
+
+		if ttl <= 0 || ttl > time.Hour {
+			t.Fatalf("expected TTL of at most an hour, got %v", ttl)
+		}
+	}
+}
+
+func TestCacheDumpJSON(t *testing.T) {
+	t.Parallel()
+
+	db, err := OpenRawMem()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	want := map[string]string{
+		"1": "One",
+		"2": "Two",
+	}
+
+	for k, v := range want {
+		if err := db.Set([]byte(k), []byte(v), 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := db.DumpJSON(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var got []struct {
+		Key   []byte `json:"key"`
+		Value []byte `json:"value"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("expected %d entries, got %d", len(want), len(got))
+	}
+
+	for _, e := range got {
+		if v, ok := want[string(e.Key)]; !ok || v != string(e.Value) {
+			t.Errorf("unexpected entry %q: %q", e.Key, e.Value)
+		}
+	}
+}
+
 func createTestFile(tb testing.TB, pattern string) *os.File {
 	tb.Helper()
 
@@ -407,6 +1198,42 @@ func BenchmarkStoreSnapshot(b *testing.B) {
 	}
 }
 
+func BenchmarkStoreSnapshotBufferSize(b *testing.B) {
+	file := createTestFile(b, "benchmark_test_")
+
+	want := setupTestStore(b)
+
+	for i := range 100000 {
+		buf := make([]byte, 8)
+		binary.LittleEndian.PutUint64(buf, uint64(i))
+		want.Set(buf, buf, 0)
+	}
+
+	for _, size := range []int{0, 1 << 20} {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			want.SnapshotBufferSize = size
+
+			if err := want.Snapshot(file); err != nil {
+				b.Fatalf("unexpected error: %v", err)
+			}
+
+			fileInfo, err := file.Stat()
+			if err != nil {
+				b.Fatalf("unexpected error: %v", err)
+			}
+
+			b.SetBytes(fileInfo.Size())
+			b.ReportAllocs()
+
+			for b.Loop() {
+				if err := want.Snapshot(file); err != nil {
+					b.Fatalf("unexpected error: %v", err)
+				}
+			}
+		})
+	}
+}
+
 func BenchmarkStoreLoadSnapshot(b *testing.B) {
 	file := createTestFile(b, "benchmark_test_")
 
@@ -442,3 +1269,106 @@ func BenchmarkStoreLoadSnapshot(b *testing.B) {
 		})
 	}
 }
+
+// FuzzStoreSnapshot checks that a snapshot reconstructs the store exactly. The input
+// selects the policy and a sequence of operations of four bytes each.
+func FuzzStoreSnapshot(f *testing.F) {
+	f.Add([]byte{byte(PolicyLRU), 0, 1, 5, 0, 1, 1, 0, 0, 2, 2, 3, 9})
+	f.Add([]byte{byte(PolicySLRU), 0, 1, 5, 0, 0, 2, 5, 1, 1, 1, 0, 0, 1, 2, 0, 0, 3, 1, 0, 0})
+	f.Add([]byte{byte(PolicyLRUSample), 4, 1, 5, 200, 1, 1, 0, 0, 0, 3, 7, 1})
+	f.Add([]byte{byte(PolicyLFU), 0, 1, 2, 0, 1, 1, 0, 0, 1, 1, 0, 0, 0, 2, 2, 0, 1, 2, 0, 0})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if len(data) == 0 {
+			return
+		}
+
+		want := setupTestStore(t)
+		want.TrackAccess = true
+		want.Unlimited = true
+		want.Policy.SLRURatio = 0.5
+		want.Policy.SampleSize = 3
+
+		if err := want.Policy.SetPolicy(EvictionPolicyType(data[0] % byte(PolicyCustom))); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		for ops := data[1:]; len(ops) >= 4; ops = ops[4:] {
+			key := []byte{'K', ops[1] % 16}
+			value := bytes.Repeat([]byte{ops[2]}, int(ops[2]%32))
+
+			// Entries live for at least an hour, so none expire during the test.
+			var ttl time.Duration
+			if ops[3] != 0 {
+				ttl = time.Hour + time.Duration(ops[3])*time.Millisecond
+			}
+
+			switch ops[0] % 5 {
+			case 0:
+				if err := want.Set(key, value, ttl); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			case 1:
+				want.Get(key)
+			case 2:
+				if err := want.SetTagged(key, value, ttl, []string{"Tag" + strconv.Itoa(int(ops[3]%3))}); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			case 3:
+				want.Delete(key)
+			case 4:
+				if _, err := want.SetIfNewer(key, value, uint64(ops[3]), ttl); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+		}
+
+		for name, format := range map[string]SnapshotFormat{"Binary": FormatBinary, "Msgpack": FormatMsgpack} {
+			want.Format = format
+
+			var buf bytes.Buffer
+			if err := want.Snapshot(&buf); err != nil {
+				t.Fatalf("%s: unexpected error: %v", name, err)
+			}
+
+			got := setupTestStore(t)
+			if err := got.LoadSnapshot(bytes.NewReader(buf.Bytes())); err != nil {
+				t.Fatalf("%s: unexpected error: %v", name, err)
+			}
+
+			if got.MaxCost != want.MaxCost || got.Unlimited != want.Unlimited || got.Policy.Type != want.Policy.Type || got.Policy.SLRURatio != want.Policy.SLRURatio ||
+				got.Policy.SampleSize != want.Policy.SampleSize || got.Policy.Clock != want.Policy.Clock || got.Generation != want.Generation {
+				t.Fatalf("%s: expected store settings %+v, got %+v", name, want.Policy, got.Policy)
+			}
+
+			if got.Length != want.Length || got.Cost != want.Cost {
+				t.Fatalf("%s: expected %d entries of cost %d, got %d of cost %d", name, want.Length, want.Cost, got.Length, got.Cost)
+			}
+
+			for w, g := want.EvictList.EvictNext, got.EvictList.EvictNext; w != &want.EvictList; w, g = w.EvictNext, g.EvictNext {
+				if g == &got.EvictList {
+					t.Fatalf("%s: expected more entries in eviction order", name)
+				}
+
+				if !bytes.Equal(g.Key, w.Key) || !bytes.Equal(g.Value, w.Value) || g.Hash != w.Hash || !g.Expiration.Equal(w.Expiration) ||
+					g.Access != w.Access || g.Protected != w.Protected || g.LastAccess != w.LastAccess ||
+					g.Version != w.Version || g.Generation != w.Generation || !slices.Equal(g.Tags, w.Tags) ||
+					g.CreatedAt != w.CreatedAt || g.AccessedAt != w.AccessedAt || g.Hits != w.Hits {
+					t.Fatalf("%s: expected entry %+v, got %+v", name, *w, *g)
+				}
+			}
+
+			// A reloaded store writes the same snapshot again.
+			got.Format = format
+
+			var again bytes.Buffer
+			if err := got.Snapshot(&again); err != nil {
+				t.Fatalf("%s: unexpected error: %v", name, err)
+			}
+
+			if !bytes.Equal(again.Bytes(), buf.Bytes()) {
+				t.Fatalf("%s: expected the snapshot of the reloaded store to be unchanged", name)
+			}
+		}
+	})
+}
@@ -1,8 +1,14 @@
 package cache
 
 import (
+	"cmp"
 	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // EvictionPolicyType defines the type of eviction policy.
@@ -15,8 +21,19 @@ const (
 	PolicyLRU
 	PolicyLFU
 	PolicyLTR
+	PolicySLRU
+	PolicyLRUSample
+	PolicyLRUTTL
+	// PolicyCustom indicates a policy registered with RegisterPolicy, selected with WithCustomPolicy.
+	PolicyCustom
 )
 
+// defaultSLRURatio is the share of entries kept in the protected segment of PolicySLRU.
+const defaultSLRURatio float64 = 0.8
+
+// defaultSampleSize is the number of entries inspected per eviction by PolicyLRUSample.
+const defaultSampleSize int = 5
+
 // evictionStrategies interface defines the methods for eviction strategies.
 type evictionStrategies interface {
 	OnInsert(n *node)
@@ -28,9 +45,65 @@ type evictionStrategies interface {
 // evictionPolicy struct holds the eviction strategy and its type.
 type evictionPolicy struct {
 	evictionStrategies
-	Type     EvictionPolicyType
-	Sentinel *node
-	ListLock *sync.RWMutex
+	Type       EvictionPolicyType
+	Name       string // Name is the registered name of a PolicyCustom strategy.
+	Sentinel   *node
+	ListLock   *sync.RWMutex
+	Bucket     *[]node
+	SLRURatio  float64
+	SampleSize int
+	Clock      uint64
+	Segment    slruSegment // Segment is the protected segment of PolicySLRU.
+}
+
+var errCorruptEvictList = errors.New("corrupt eviction list") // errCorruptEvictList is returned by verifyEvictList.
+
+// verifyEvictList checks that the eviction list is circular, that every link is
+// mirrored by the opposite one and that it holds exactly Length nodes, walking it in
+// both directions. It is meant for tests and debugging relink bugs in the policies.
+// The caller must hold the store lock.
+func (s *store) verifyEvictList() error {
+	s.EvictLock.RLock()
+	defer s.EvictLock.RUnlock()
+
+	sentinel := &s.EvictList
+
+	for _, dir := range []struct {
+		name string
+		next func(*node) *node
+		prev func(*node) *node
+	}{
+		{"forward", func(v *node) *node { return v.EvictNext }, func(v *node) *node { return v.EvictPrev }},
+		{"backward", func(v *node) *node { return v.EvictPrev }, func(v *node) *node { return v.EvictNext }},
+	} {
+		var count uint64
+
+		for v := sentinel; ; v = dir.next(v) {
+			next := dir.next(v)
+
+			switch {
+			case next == nil:
+				return fmt.Errorf("%w: nil link after %d nodes %s", errCorruptEvictList, count, dir.name)
+			case dir.prev(next) != v:
+				return fmt.Errorf("%w: unmirrored link after %d nodes %s", errCorruptEvictList, count, dir.name)
+			}
+
+			if next == sentinel {
+				break
+			}
+
+			// Stop at Length+1 so a cycle that skips the sentinel cannot loop forever.
+			if count++; count > s.Length {
+				return fmt.Errorf("%w: more than %d nodes %s", errCorruptEvictList, s.Length, dir.name)
+			}
+		}
+
+		if count != s.Length {
+			return fmt.Errorf("%w: %d nodes %s, want %d", errCorruptEvictList, count, dir.name, s.Length)
+		}
+	}
+
+	return nil
 }
 
 // pushEvict adds a node to the eviction list.
@@ -43,8 +116,98 @@ func pushEvict(node, sentinnel *node) {
 
 var ErrInvalidPolicy = errors.New("invalid policy")
 
+var ErrUnknownPolicy = errors.New("unknown custom policy") // ErrUnknownPolicy is wrapped by UnknownPolicyError.
+
+// UnknownPolicyError is returned when a custom policy is selected by a name that was
+// not registered. When loading a snapshot the cache falls back to PolicyNone and
+// returns it once the entries are loaded, so the cache is still usable.
+type UnknownPolicyError struct {
+	Name string // Name is the policy name that was not registered.
+}
+
+func (e *UnknownPolicyError) Error() string {
+	return fmt.Sprintf("%v: %q", ErrUnknownPolicy, e.Name)
+}
+
+func (e *UnknownPolicyError) Unwrap() error {
+	return ErrUnknownPolicy
+}
+
+// Node is the view of a cache entry given to an EvictionStrategy. Nodes are comparable,
+// so a strategy can keep them as map keys, and stay valid until OnRemove.
+type Node struct {
+	n *node
+}
+
+// Key returns the encoded key of the entry, which must not be modified.
+func (n Node) Key() []byte {
+	return n.n.Key
+}
+
+// Cost returns the cost of the key and value of the entry.
+func (n Node) Cost() uint64 {
+	return n.n.Cost()
+}
+
+// Access returns the number of times the entry was read since it was inserted.
+func (n Node) Access() uint64 {
+	return n.n.Access
+}
+
+// Expiration returns when the entry expires, or the zero time if it does not.
+func (n Node) Expiration() time.Time {
+	return n.n.Expiration
+}
+
+// EvictionStrategy is a custom eviction policy registered with RegisterPolicy. The
+// cache reports every entry it inserts, updates, reads and removes, and asks Evict for
+// the entry to remove next. Calls are serialized by the cache, so a strategy needs no
+// locking of its own, but it must not call back into the cache. Entries loaded from a
+// snapshot are inserted most recently inserted first.
+type EvictionStrategy interface {
+	OnInsert(n Node)
+	OnUpdate(n Node)     // OnUpdate is called when the value or expiration of an entry changes.
+	OnAccess(n Node)     // OnAccess is called after Access of the entry was incremented.
+	OnRemove(n Node)     // OnRemove is called for every removed entry, evicted or not.
+	Evict() (Node, bool) // Evict returns the entry to evict next, or false to evict none.
+}
+
+// policyFactory builds a new instance of a custom strategy for a cache.
+type policyFactory func() EvictionStrategy
+
+var (
+	policiesLock sync.RWMutex
+	policies     = map[string]policyFactory{}
+)
+
+// RegisterPolicy registers a custom eviction strategy under name, to be selected
+// with WithCustomPolicy. Each cache selecting it calls factory for a strategy of its own.
+// Registering a name again replaces the factory for caches that select it later.
+func RegisterPolicy(name string, factory func() EvictionStrategy) error {
+	if name == "" || factory == nil {
+		return ErrInvalidPolicy
+	}
+
+	policiesLock.Lock()
+	defer policiesLock.Unlock()
+
+	policies[name] = factory
+
+	return nil
+}
+
+var ErrInvalidSampleSize = errors.New("sample size must be positive") // ErrInvalidSampleSize is returned when the sample size is not positive.
+
+var ErrInvalidRatio = errors.New("ratio must be between 0 and 1") // ErrInvalidRatio is returned when a segment ratio is out of range.
+
 // SetPolicy sets the eviction policy based on the given type.
+// Entries already in the eviction list are reordered for the new policy.
+// PolicyCustom selects the custom strategy named by Name.
 func (e *evictionPolicy) SetPolicy(y EvictionPolicyType) error {
+	if y == PolicyCustom {
+		return e.SetCustomPolicy(e.Name)
+	}
+
 	store := map[EvictionPolicyType]func() evictionStrategies{
 		PolicyNone: func() evictionStrategies {
 			return fifoPolicy{List: e.Sentinel, ShouldEvict: false, Lock: e.ListLock}
@@ -61,6 +224,25 @@ func (e *evictionPolicy) SetPolicy(y EvictionPolicyType) error {
 		PolicyLTR: func() evictionStrategies {
 			return ltrPolicy{List: e.Sentinel, EvictZero: true, Lock: e.ListLock}
 		},
+		PolicySLRU: func() evictionStrategies {
+			ratio := e.SLRURatio
+			if ratio == 0 {
+				ratio = defaultSLRURatio
+			}
+
+			return slruPolicy{List: e.Sentinel, Segment: &e.Segment, Ratio: ratio, Lock: e.ListLock}
+		},
+		PolicyLRUSample: func() evictionStrategies {
+			samples := e.SampleSize
+			if samples == 0 {
+				samples = defaultSampleSize
+			}
+
+			return lruSamplePolicy{List: e.Sentinel, Bucket: e.Bucket, Clock: &e.Clock, Samples: samples, Lock: e.ListLock}
+		},
+		PolicyLRUTTL: func() evictionStrategies {
+			return lruTTLPolicy{lruPolicy{List: e.Sentinel, Lock: e.ListLock}}
+		},
 	}
 
 	factory, ok := store[y]
@@ -68,12 +250,162 @@ func (e *evictionPolicy) SetPolicy(y EvictionPolicyType) error {
 		return ErrInvalidPolicy
 	}
 
+	prev := e.Type
+
 	e.evictionStrategies = factory()
 	e.Type = y
+	e.Name = ""
+
+	if prev != y {
+		e.rebuild()
+	}
 
 	return nil
 }
 
+// SetCustomPolicy sets the eviction policy to the strategy registered under name.
+func (e *evictionPolicy) SetCustomPolicy(name string) error {
+	policiesLock.RLock()
+	factory, ok := policies[name]
+	policiesLock.RUnlock()
+
+	if !ok {
+		return &UnknownPolicyError{Name: name}
+	}
+
+	prev, prevName := e.Type, e.Name
+
+	// Selecting the same strategy again keeps its state.
+	if _, ok := e.evictionStrategies.(customPolicy); ok && prev == PolicyCustom && prevName == name {
+		return nil
+	}
+
+	e.evictionStrategies = customPolicy{List: e.Sentinel, Lock: e.ListLock, Mu: &sync.Mutex{}, Strategy: factory()}
+	e.Type = PolicyCustom
+	e.Name = name
+
+	e.rebuild()
+
+	return nil
+}
+
+// restorePolicy sets the policy saved in a snapshot. A custom policy that is not
+// registered falls back to PolicyNone, returning an UnknownPolicyError.
+func (e *evictionPolicy) restorePolicy(y EvictionPolicyType, name string) error {
+	if y != PolicyCustom {
+		return e.SetPolicy(y)
+	}
+
+	err := e.SetCustomPolicy(name)
+	if !errors.Is(err, ErrUnknownPolicy) {
+		return err
+	}
+
+	if err := e.SetPolicy(PolicyNone); err != nil {
+		return err
+	}
+
+	return err
+}
+
+// unlink tells the policy about a node the store is about to remove from the list.
+// The caller must hold the store lock.
+func (e *evictionPolicy) unlink(n *node) {
+	switch s := e.evictionStrategies.(type) {
+	case slruPolicy:
+		s.unlink(n)
+	case customPolicy:
+		s.Mu.Lock()
+		defer s.Mu.Unlock()
+
+		s.Strategy.OnRemove(Node{n})
+	}
+}
+
+// restored tells the policy about a node a snapshot appended at the back of the list.
+// The caller must hold the store lock.
+func (e *evictionPolicy) restored(n *node) {
+	switch s := e.evictionStrategies.(type) {
+	case slruPolicy:
+		s.restored(n)
+	case customPolicy:
+		s.Mu.Lock()
+		defer s.Mu.Unlock()
+
+		s.Strategy.OnInsert(Node{n})
+	}
+}
+
+// renew starts a custom strategy over with the nodes in the list, oldest first, after
+// the store replaced the whole list without going through the policy.
+// The caller must hold the store lock.
+func (e *evictionPolicy) renew() {
+	s, ok := e.evictionStrategies.(customPolicy)
+	if !ok {
+		return
+	}
+
+	policiesLock.RLock()
+	factory, ok := policies[e.Name]
+	policiesLock.RUnlock()
+
+	if !ok {
+		return
+	}
+
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+
+	s.Strategy = factory()
+	e.evictionStrategies = s
+
+	for v := e.Sentinel.EvictPrev; v != e.Sentinel; v = v.EvictPrev {
+		s.Strategy.OnInsert(Node{v})
+	}
+}
+
+// rebuild relinks the nodes in the eviction list through the current policy.
+// Access counts are only kept when switching to LFU, which orders the nodes by them,
+// so that stale counts do not carry over to a later switch back to LFU.
+func (e *evictionPolicy) rebuild() {
+	if e.Sentinel == nil || e.Sentinel.EvictNext == nil {
+		return
+	}
+
+	e.ListLock.Lock()
+
+	var nodes []*node
+
+	for v := e.Sentinel.EvictPrev; v != e.Sentinel; {
+		n := v.EvictPrev
+
+		nodes = append(nodes, v)
+		v.UnlinkEvict()
+
+		v = n
+	}
+
+	e.Segment = slruSegment{Last: e.Sentinel}
+
+	e.ListLock.Unlock()
+
+	if e.Type == PolicyLFU {
+		slices.SortStableFunc(nodes, func(a, b *node) int {
+			return cmp.Compare(a.Access, b.Access)
+		})
+	}
+
+	// Nodes are pushed from the eviction end forward, preserving the previous order.
+	for _, v := range nodes {
+		if e.Type != PolicyLFU {
+			v.Access = 0
+		}
+
+		v.Protected = false
+		e.OnInsert(v)
+	}
+}
+
 type evictOrderedPolicy interface {
 	evictionStrategies
 	getEvict() *node
@@ -309,3 +641,295 @@ func (s ltrPolicy) Evict() *node {
 func (s ltrPolicy) getEvict() *node {
 	return s.List
 }
+
+// slruSegment tracks the protected segment of PolicySLRU, so the border with the
+// probationary segment is found without walking the list.
+type slruSegment struct {
+	Last      *node  // Last is the last protected node, or the sentinel if there is none.
+	Protected uint64 // Protected is the number of protected nodes.
+	Total     uint64 // Total is the number of nodes in both segments.
+}
+
+// unlink updates the protected segment for a node the store is about to remove.
+func (s slruPolicy) unlink(n *node) {
+	if s.Segment.Last == n {
+		s.Segment.Last = n.EvictPrev
+	}
+
+	if n.Protected {
+		s.Segment.Protected--
+	}
+
+	s.Segment.Total--
+}
+
+// restored updates the protected segment for a node a snapshot appended at the back of
+// the list. A protected node only extends the segment if nothing but protected nodes
+// precede it, so a snapshot out of order cannot split the segment.
+func (s slruPolicy) restored(n *node) {
+	s.Segment.Total++
+
+	if !n.Protected {
+		return
+	}
+
+	if s.Segment.Last != n.EvictPrev {
+		n.Protected = false
+		return
+	}
+
+	s.Segment.Last = n
+	s.Segment.Protected++
+}
+
+// slruPolicy struct represents the Segmented LRU eviction policy.
+// The protected segment is kept at the front of the eviction list and the
+// probationary segment behind it, so eviction always drains probation first.
+type slruPolicy struct {
+	List    *node
+	Lock    *sync.RWMutex
+	Segment *slruSegment
+	Ratio   float64
+}
+
+// OnInsert adds a node to the front of the probationary segment.
+func (s slruPolicy) OnInsert(n *node) {
+	s.Lock.Lock()
+	defer s.Lock.Unlock()
+
+	n.Protected = false
+	s.Segment.Total++
+
+	pushEvict(n, s.Segment.Last)
+}
+
+// OnUpdate promotes the node to the protected segment.
+func (s slruPolicy) OnUpdate(n *node) {
+	s.OnAccess(n)
+}
+
+// OnAccess moves the node to the front of the protected segment and
+// demotes the overflow of the protected segment back to probation.
+func (s slruPolicy) OnAccess(n *node) {
+	s.Lock.Lock()
+	defer s.Lock.Unlock()
+
+	if s.Segment.Last == n {
+		s.Segment.Last = n.EvictPrev
+	}
+
+	n.EvictNext.EvictPrev = n.EvictPrev
+	n.EvictPrev.EvictNext = n.EvictNext
+
+	pushEvict(n, s.List)
+
+	if s.Segment.Last == s.List {
+		s.Segment.Last = n
+	}
+
+	if n.Protected {
+		return
+	}
+
+	n.Protected = true
+	s.Segment.Protected++
+
+	s.demote()
+}
+
+// demote moves the least recently used protected nodes into the probationary
+// segment until the protected segment fits its share of the list.
+func (s slruPolicy) demote() {
+	limit := uint64(s.Ratio * float64(s.Segment.Total))
+
+	for s.Segment.Protected > limit && s.Segment.Last != s.List {
+		// The last protected node borders the probationary segment, so
+		// demoting it only requires clearing the marker.
+		s.Segment.Last.Protected = false
+		s.Segment.Last = s.Segment.Last.EvictPrev
+		s.Segment.Protected--
+	}
+}
+
+// Evict returns the least recently used probationary node, falling back to
+// the protected segment when probation is empty.
+func (s slruPolicy) Evict() *node {
+	if s.List.EvictPrev != s.List {
+		return s.List.EvictPrev
+	} else {
+		return nil
+	}
+}
+
+func (s slruPolicy) getEvict() *node {
+	return s.List
+}
+
+// customPolicy adapts an EvictionStrategy registered with RegisterPolicy. The nodes
+// stay in the eviction list in insertion order, so snapshots keep every entry, while
+// the strategy picks the victims. Mu serializes the calls into the strategy, since
+// Evict is also called by peeks holding only the read lock.
+type customPolicy struct {
+	List     *node
+	Lock     *sync.RWMutex
+	Mu       *sync.Mutex
+	Strategy EvictionStrategy
+}
+
+// OnInsert adds a node to the front of the eviction list.
+func (s customPolicy) OnInsert(n *node) {
+	s.Lock.Lock()
+	defer s.Lock.Unlock()
+
+	pushEvict(n, s.List)
+
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+
+	s.Strategy.OnInsert(Node{n})
+}
+
+// OnUpdate reports the changed node to the strategy.
+func (s customPolicy) OnUpdate(n *node) {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+
+	s.Strategy.OnUpdate(Node{n})
+}
+
+// OnAccess counts the access and reports it to the strategy.
+func (s customPolicy) OnAccess(n *node) {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+
+	n.Access++
+	s.Strategy.OnAccess(Node{n})
+}
+
+// Evict returns the node the strategy picks. A node that is no longer in the list,
+// because the strategy kept it past OnRemove, is never returned.
+func (s customPolicy) Evict() *node {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+
+	n, ok := s.Strategy.Evict()
+	if !ok || n.n == nil || n.n.EvictNext == nil {
+		return nil
+	}
+
+	return n.n
+}
+
+func (s customPolicy) getEvict() *node {
+	return s.List
+}
+
+// lruSamplePolicy struct represents an approximate LRU eviction policy.
+// Accesses only record a logical timestamp on the node, and eviction picks the least
+// recently used entry out of a random sample of the hash buckets.
+type lruSamplePolicy struct {
+	List    *node
+	Lock    *sync.RWMutex
+	Bucket  *[]node
+	Clock   *uint64
+	Samples int
+}
+
+// touch stamps the node with the next tick of the logical access clock.
+func (s lruSamplePolicy) touch(n *node) {
+	atomic.StoreUint64(&n.LastAccess, atomic.AddUint64(s.Clock, 1))
+}
+
+// OnInsert adds a node to the eviction list and records its access time.
+func (s lruSamplePolicy) OnInsert(n *node) {
+	s.Lock.Lock()
+	defer s.Lock.Unlock()
+
+	s.touch(n)
+	pushEvict(n, s.List)
+}
+
+// OnUpdate records the access time of the node.
+func (s lruSamplePolicy) OnUpdate(n *node) {
+	s.touch(n)
+}
+
+// OnAccess records the access time of the node without moving it.
+func (s lruSamplePolicy) OnAccess(n *node) {
+	s.touch(n)
+}
+
+// Evict returns the least recently used node out of a sample of entries.
+// Each entry of the sample is drawn from its own random bucket, so keys that hash
+// close together are not sampled together. A sample as large as the cache would
+// cover every entry, so the list is scanned instead.
+func (s lruSamplePolicy) Evict() *node {
+	if s.List.EvictPrev == s.List {
+		return nil
+	}
+
+	var victim *node
+
+	pick := func(v *node) {
+		if victim == nil || atomic.LoadUint64(&v.LastAccess) < atomic.LoadUint64(&victim.LastAccess) {
+			victim = v
+		}
+	}
+
+	end := s.List.EvictNext
+	for seen := 0; end != s.List && seen < s.Samples; seen++ {
+		end = end.EvictNext
+	}
+
+	if end == s.List {
+		for v := s.List.EvictNext; v != s.List; v = v.EvictNext {
+			pick(v)
+		}
+
+		return victim
+	}
+
+	bucket := *s.Bucket
+
+	for range s.Samples {
+		pick(sampleBucket(bucket, rand.IntN(len(bucket))))
+	}
+
+	return victim
+}
+
+// sampleBucket returns a random entry of the first non-empty bucket from start on,
+// wrapping around. The table must hold at least one entry.
+func sampleBucket(bucket []node, start int) *node {
+	for i := range bucket {
+		sentinel := &bucket[(start+i)%len(bucket)]
+		if sentinel.HashNext == sentinel {
+			continue
+		}
+
+		n := 0
+		for v := sentinel.HashNext; v != sentinel; v = v.HashNext {
+			n++
+		}
+
+		v := sentinel.HashNext
+		for range rand.IntN(n) {
+			v = v.HashNext
+		}
+
+		return v
+	}
+
+	return nil
+}
+
+func (s lruSamplePolicy) getEvict() *node {
+	return s.List
+}
+
+// lruTTLPolicy struct represents an LRU eviction policy that evicts expired entries first.
+// The store removes the expired entries before asking it for a victim, so the
+// policy itself only keeps the LRU order.
+type lruTTLPolicy struct {
+	lruPolicy
+}
@@ -0,0 +1,131 @@
+package cache_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"go.sudomsg.com/cache"
+)
+
+// largestStrategy evicts the entry with the largest cost, breaking ties by key.
+type largestStrategy struct {
+	nodes map[cache.Node]struct{}
+}
+
+func (s *largestStrategy) OnInsert(n cache.Node) { s.nodes[n] = struct{}{} }
+func (s *largestStrategy) OnUpdate(cache.Node)   {}
+func (s *largestStrategy) OnAccess(cache.Node)   {}
+func (s *largestStrategy) OnRemove(n cache.Node) { delete(s.nodes, n) }
+
+func (s *largestStrategy) Evict() (cache.Node, bool) {
+	var (
+		largest cache.Node
+		found   bool
+	)
+
+	for n := range s.nodes {
+		if !found || n.Cost() > largest.Cost() || n.Cost() == largest.Cost() && bytes.Compare(n.Key(), largest.Key()) < 0 {
+			largest, found = n, true
+		}
+	}
+
+	return largest, found
+}
+
+func TestCustomEvictionStrategy(t *testing.T) {
+	t.Parallel()
+
+	err := cache.RegisterPolicy("TestCustomEvictionStrategy", func() cache.EvictionStrategy {
+		return &largestStrategy{nodes: map[cache.Node]struct{}{}}
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	open := func(t *testing.T) cache.CacheRaw {
+		t.Helper()
+
+		db, err := cache.OpenRawMem(cache.WithCustomPolicy("TestCustomEvictionStrategy"), cache.WithMaxCost(22), cache.WithSyncMaintenance())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		t.Cleanup(func() {
+			if err := db.Close(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+
+		return db
+	}
+
+	exists := func(t *testing.T, db cache.CacheRaw, key string) bool {
+		t.Helper()
+
+		var value []byte
+
+		_, err := db.Get([]byte(key), &value)
+		if err != nil && !errors.Is(err, cache.ErrKeyNotFound) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		return err == nil
+	}
+
+	db := open(t)
+
+	// Mid costs 10 and Big 13, so setting Big evicts it as the largest entry.
+	values := map[string]string{"A": "1", "Mid": "0123456", "Big": "0123456789", "B": "1", "C": "1"}
+	for _, key := range []string{"A", "Mid", "Big", "B", "C"} {
+		if err := db.Set([]byte(key), []byte(values[key]), 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if exists(t, db, "Big") {
+		t.Fatalf("expected the largest entry to be evicted")
+	}
+
+	for _, key := range []string{"A", "Mid", "B", "C"} {
+		if !exists(t, db, key) {
+			t.Fatalf("expected %s to be kept", key)
+		}
+	}
+
+	// A deleted entry is reported to the strategy and never picked again.
+	if err := db.Delete([]byte("C")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := db.SnapshotBytes()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Run("Snapshot", func(t *testing.T) {
+		t.Parallel()
+
+		loaded := open(t)
+
+		if err := loaded.LoadSnapshotBytes(data); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// The loaded entries are reported to the new strategy, so Mid is evicted
+		// rather than the entry just set.
+		if err := loaded.Set([]byte("E"), []byte("01234567"), 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if exists(t, loaded, "Mid") {
+			t.Fatalf("expected the largest loaded entry to be evicted")
+		}
+
+		for _, key := range []string{"A", "B", "E"} {
+			if !exists(t, loaded, key) {
+				t.Fatalf("expected %s to be kept", key)
+			}
+		}
+	})
+}
@@ -1,6 +1,10 @@
 package cache
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand/v2"
 	"strconv"
 	"sync"
 	"testing"
@@ -29,6 +33,12 @@ func createPolicy(tb testing.TB, policyType EvictionPolicyType, flag bool) evict
 		return &lruPolicy{List: createSentinel(tb), Lock: &sync.RWMutex{}}
 	case PolicyLFU:
 		return &lfuPolicy{List: createSentinel(tb), Lock: &sync.RWMutex{}}
+	case PolicyLRUTTL:
+		return &lruTTLPolicy{lruPolicy{List: createSentinel(tb), Lock: &sync.RWMutex{}}}
+	case PolicySLRU:
+		list := createSentinel(tb)
+
+		return &slruPolicy{List: list, Segment: &slruSegment{Last: list}, Ratio: defaultSLRURatio, Lock: &sync.RWMutex{}}
 	}
 
 	tb.Fatalf("unknown policy type: %v", policyType)
@@ -513,6 +523,51 @@ func TestPolicyEvict(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:       "LRUTTL",
+			policyType: PolicyLRUTTL,
+			tests: []test{
+				{
+					// Expired entries are removed by the store before eviction.
+					name:       "Expired Left To Store",
+					numOfNodes: 3,
+					actions: func(policy evictOrderedPolicy, nodes []*node) {
+						nodes[1].Expiration = time.Now().Add(-1 * time.Minute)
+
+						policy.OnInsert(nodes[0])
+						policy.OnInsert(nodes[1])
+						policy.OnInsert(nodes[2])
+						policy.OnAccess(nodes[1])
+					},
+					expected: func(nodes []*node) *node {
+						return nodes[0]
+					},
+				},
+				{
+					name:       "Fallback LRU",
+					numOfNodes: 3,
+					actions: func(policy evictOrderedPolicy, nodes []*node) {
+						nodes[1].Expiration = time.Now().Add(1 * time.Hour)
+
+						policy.OnInsert(nodes[0])
+						policy.OnInsert(nodes[1])
+						policy.OnInsert(nodes[2])
+						policy.OnAccess(nodes[0])
+					},
+					expected: func(nodes []*node) *node {
+						return nodes[1]
+					},
+				},
+				{
+					name:       "Empty List",
+					numOfNodes: 0,
+					actions:    func(policy evictOrderedPolicy, nodes []*node) {},
+					expected: func(nodes []*node) *node {
+						return nil
+					},
+				},
+			},
+		},
 	}
 
 	for _, ts := range tests {
@@ -583,6 +638,18 @@ func TestSetPolicy(t *testing.T) {
 			expectedType: PolicyLTR,
 			expectedErr:  nil,
 		},
+		{
+			name:         "PolicySLRU",
+			policyType:   PolicySLRU,
+			expectedType: PolicySLRU,
+			expectedErr:  nil,
+		},
+		{
+			name:         "PolicyLRUTTL",
+			policyType:   PolicyLRUTTL,
+			expectedType: PolicyLRUTTL,
+			expectedErr:  nil,
+		},
 		{
 			name:         "InvalidPolicy",
 			policyType:   EvictionPolicyType(999), // Invalid policy type
@@ -612,6 +679,67 @@ func TestSetPolicy(t *testing.T) {
 	}
 }
 
+func TestSetPolicyMigration(t *testing.T) {
+	t.Parallel()
+
+	store := setupTestStore(t)
+
+	expectNext := func(t *testing.T, want string) {
+		t.Helper()
+
+		got, ok := store.NextEviction()
+		if !ok || string(got) != want {
+			t.Fatalf("expected %v to be evicted next, got %v", want, string(got))
+		}
+	}
+
+	if err := store.Policy.SetPolicy(PolicyLFU); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, k := range []string{"1", "2", "3"} {
+		store.Set([]byte(k), []byte(k), 0)
+	}
+
+	for range 5 {
+		store.Get([]byte("1"))
+	}
+
+	store.Get([]byte("2"))
+
+	next, _ := store.NextEviction()
+
+	if err := store.Policy.SetPolicy(PolicyLRU); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for v := store.EvictList.EvictNext; v != &store.EvictList; v = v.EvictNext {
+		if v.Access != 0 {
+			t.Fatalf("expected access of %s to be reset, got %d", v.Key, v.Access)
+		}
+	}
+
+	// Switching keeps the eviction order of the previous policy.
+	expectNext(t, string(next))
+
+	store.Get([]byte("3"))
+	store.Get([]byte("2"))
+	expectNext(t, "1")
+
+	if err := store.Policy.SetPolicy(PolicyLFU); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The order from LRU is kept and the earlier LFU accesses of 1 do not count.
+	expectNext(t, "1")
+
+	store.Get([]byte("1"))
+
+	if v, _, _ := store.lookup([]byte("1")); v.Access != 1 {
+		t.Fatalf("expected access count 1, got %d", v.Access)
+	}
+}
+
 func TestSetPolicyMultipleTimes(t *testing.T) {
 	t.Parallel()
 
@@ -670,3 +798,327 @@ func TestSetPolicyMultipleTimes(t *testing.T) {
 		t.Errorf("expected policy type %v, got %v", PolicyNone, policy.Type)
 	}
 }
+
+func TestSLRUPromotion(t *testing.T) {
+	t.Parallel()
+
+	policy := createPolicy(t, PolicySLRU, false)
+
+	n0 := &node{Key: []byte("0")}
+	n1 := &node{Key: []byte("1")}
+	n2 := &node{Key: []byte("2")}
+
+	policy.OnInsert(n0)
+	policy.OnInsert(n1)
+	policy.OnInsert(n2)
+
+	// New entries are probationary, so the order is plain insertion order.
+	checkOrder(t, policy, []*node{n2, n1, n0})
+
+	policy.OnAccess(n0)
+
+	if !n0.Protected {
+		t.Fatalf("expected node 0 to be protected")
+	}
+
+	checkOrder(t, policy, []*node{n0, n2, n1})
+
+	// A new insert lands behind the protected segment.
+	n3 := &node{Key: []byte("3")}
+	policy.OnInsert(n3)
+
+	checkOrder(t, policy, []*node{n0, n3, n2, n1})
+
+	policy.OnAccess(n1)
+	policy.OnAccess(n2)
+	policy.OnAccess(n3)
+
+	// 4 entries at a ratio of 0.8 leaves room for 3 protected entries,
+	// so the least recently promoted one is demoted back to probation.
+	checkOrder(t, policy, []*node{n3, n2, n1, n0})
+
+	if n0.Protected {
+		t.Fatalf("expected node 0 to be demoted")
+	}
+
+	if got := policy.Evict(); got != n0 {
+		t.Fatalf("expected %#v, got %#v", n0, got)
+	}
+}
+
+// checkSegment verifies that the tracked SLRU segment matches the eviction list.
+func checkSegment(tb testing.TB, s *store) {
+	tb.Helper()
+
+	last := &s.EvictList
+
+	var protected, total uint64
+
+	for v := s.EvictList.EvictNext; v != &s.EvictList; v = v.EvictNext {
+		if v.Protected {
+			if last != v.EvictPrev {
+				tb.Fatalf("protected node %s follows a probationary node", v.Key)
+			}
+
+			last = v
+			protected++
+		}
+
+		total++
+	}
+
+	seg := s.Policy.Segment
+	if seg.Last != last || seg.Protected != protected || seg.Total != total {
+		tb.Fatalf("expected segment ending at %s with %d of %d nodes, got %s with %d of %d",
+			last.Key, protected, total, seg.Last.Key, seg.Protected, seg.Total)
+	}
+}
+
+func TestSLRUSegmentTracking(t *testing.T) {
+	t.Parallel()
+
+	store := setupTestStore(t)
+	store.Unlimited = true
+
+	if err := store.Policy.SetPolicy(PolicySLRU); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i := range 10 {
+		if err := store.Set([]byte(strconv.Itoa(i)), []byte("Value"), 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	for i := range 5 {
+		store.Get([]byte(strconv.Itoa(i)))
+	}
+
+	checkSegment(t, store)
+
+	// Removing the border of the segment, a protected and a probationary node.
+	for _, key := range []string{"0", "3", "7"} {
+		if !store.Delete([]byte(key)) {
+			t.Fatalf("expected key %s to be deleted", key)
+		}
+
+		checkSegment(t, store)
+	}
+
+	var buf bytes.Buffer
+	if err := store.Snapshot(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	loaded := setupTestStore(t)
+	if err := loaded.LoadSnapshot(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	checkSegment(t, loaded)
+
+	if err := store.ReplaceAll([]Entry[[]byte, []byte]{{Key: []byte("a"), Value: []byte("Value")}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	checkSegment(t, store)
+
+	store.Get([]byte("a"))
+	checkSegment(t, store)
+
+	store.Clear()
+	checkSegment(t, store)
+}
+
+func TestStoreVerifyEvictList(t *testing.T) {
+	t.Parallel()
+
+	policies := []EvictionPolicyType{
+		PolicyNone, PolicyFIFO, PolicyLRU, PolicyLFU, PolicyLTR, PolicySLRU, PolicyLRUSample, PolicyLRUTTL,
+	}
+
+	for _, policy := range policies {
+		t.Run(fmt.Sprint(policy), func(t *testing.T) {
+			t.Parallel()
+
+			store := setupTestStore(t)
+			if err := store.Policy.SetPolicy(policy); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if policy != PolicyNone {
+				store.MaxCost = 200
+			}
+
+			r := rand.New(rand.NewPCG(1, uint64(policy)))
+
+			for step := range 2000 {
+				key := []byte(strconv.Itoa(r.IntN(32)))
+
+				var op string
+
+				switch r.IntN(5) {
+				case 0, 1:
+					op = "Set"
+					store.Set(key, key, time.Duration(r.IntN(3))*time.Hour)
+				case 2:
+					op = "Get"
+					store.Get(key)
+				case 3:
+					op = "Delete"
+					store.Delete(key)
+				case 4:
+					op = "Evict"
+					store.Evict()
+				}
+
+				if err := store.verifyEvictList(); err != nil {
+					t.Fatalf("step %d (%s %s): %v", step, op, key, err)
+				}
+			}
+		})
+	}
+}
+
+func TestStoreVerifyEvictListCorrupt(t *testing.T) {
+	t.Parallel()
+
+	store := setupTestStore(t)
+
+	for i := range 3 {
+		store.Set([]byte(strconv.Itoa(i)), []byte("Value"), 0)
+	}
+
+	if err := store.verifyEvictList(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Skip the middle node going forward only.
+	first := store.EvictList.EvictNext
+	first.EvictNext = first.EvictNext.EvictNext
+
+	if err := store.verifyEvictList(); !errors.Is(err, errCorruptEvictList) {
+		t.Fatalf("expected error: %v, got %v", errCorruptEvictList, err)
+	}
+}
+
+// keepStrategy never evicts, leaving the order of the eviction list to the cache.
+type keepStrategy struct{}
+
+func (keepStrategy) OnInsert(Node)       {}
+func (keepStrategy) OnUpdate(Node)       {}
+func (keepStrategy) OnAccess(Node)       {}
+func (keepStrategy) OnRemove(Node)       {}
+func (keepStrategy) Evict() (Node, bool) { return Node{}, false }
+
+func TestCustomPolicy(t *testing.T) {
+	t.Parallel()
+
+	err := RegisterPolicy("TestCustomPolicy", func() EvictionStrategy {
+		return keepStrategy{}
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := RegisterPolicy("TestCustomPolicyNil", nil); !errors.Is(err, ErrInvalidPolicy) {
+		t.Fatalf("expected error: %v, got %v", ErrInvalidPolicy, err)
+	}
+
+	db, err := OpenRawMem(WithCustomPolicy("TestCustomPolicy"), WithMaxCost(4), WithSyncMaintenance())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	for _, key := range []string{"A", "B", "C"} {
+		if err := db.Set([]byte(key), []byte("1"), 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if err := db.Store.verifyEvictList(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := db.Store.Length; got != 3 {
+		t.Fatalf("expected a strategy evicting none to keep 3 entries, got %d", got)
+	}
+
+	data, err := db.SnapshotBytes()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Run("Snapshot", func(t *testing.T) {
+		t.Parallel()
+
+		loaded, err := OpenRawMem()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		t.Cleanup(func() {
+			if err := loaded.Close(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+
+		if err := loaded.LoadSnapshotBytes(data); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if policy := loaded.Store.Policy; policy.Type != PolicyCustom || policy.Name != "TestCustomPolicy" {
+			t.Fatalf("expected policy %q, got %v %q", "TestCustomPolicy", policy.Type, policy.Name)
+		}
+	})
+
+	t.Run("Unregistered", func(t *testing.T) {
+		t.Parallel()
+
+		store := setupTestStore(t)
+		store.Policy.Name = "TestCustomPolicyUnregistered"
+		store.Policy.Type = PolicyCustom
+		store.Unlimited = true
+		store.Set([]byte("Key"), []byte("Value"), 0)
+
+		var buf bytes.Buffer
+		if err := store.Snapshot(&buf); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		loaded, err := OpenRawMem()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		t.Cleanup(func() {
+			if err := loaded.Close(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+
+		var unknown *UnknownPolicyError
+		if err := loaded.LoadSnapshotBytes(buf.Bytes()); !errors.As(err, &unknown) || unknown.Name != "TestCustomPolicyUnregistered" {
+			t.Fatalf("expected error: %v, got %v", ErrUnknownPolicy, err)
+		}
+
+		if policy := loaded.Store.Policy.Type; policy != PolicyNone {
+			t.Fatalf("expected fallback to %v, got %v", PolicyNone, policy)
+		}
+
+		var value []byte
+		if _, err := loaded.Get([]byte("Key"), &value); err != nil {
+			t.Fatalf("expected the entries to be loaded, got %v", err)
+		}
+	})
+
+	if err := WithCustomPolicy("TestCustomPolicyMissing")(&cache{}); !errors.Is(err, ErrUnknownPolicy) {
+		t.Fatalf("expected error: %v, got %v", ErrUnknownPolicy, err)
+	}
+}
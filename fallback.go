@@ -0,0 +1,107 @@
+package cache
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrFallbackType = errors.New("fallback cache has different key or value types") // ErrFallbackType is returned when the fallback does not match the cache types.
+
+// WithFallback makes the cache read through to next on a miss. Hits in next are stored in
+// this cache with the given TTL, or with their remaining TTL in next if ttl is 0.
+// If writeThrough is set, every Set is also applied to next.
+// The key and value types of next must match those of the cache.
+func WithFallback[K, V any](next Cacher[K, V], ttl time.Duration, writeThrough bool) Option {
+	return func(d *cache) error {
+		d.Fallback = next
+		d.FallbackTTL = ttl
+		d.WriteThrough = writeThrough
+
+		return nil
+	}
+}
+
+// fallback returns the fallback cache, if one is configured.
+func fallback[K, V any](c *cache) (Cacher[K, V], error) {
+	if c.Fallback == nil {
+		return nil, nil
+	}
+
+	next, ok := c.Fallback.(Cacher[K, V])
+	if !ok {
+		return nil, ErrFallbackType
+	}
+
+	return next, nil
+}
+
+// readThrough looks up a missed key in the fallback cache and promotes a hit with set.
+func readThrough[K, V any](c *cache, key K, set func(K, V, time.Duration) error) (V, time.Duration, error) {
+	next, err := fallback[K, V](c)
+	if err != nil {
+		return zero[V](), 0, err
+	}
+
+	if next == nil {
+		return zero[V](), 0, ErrKeyNotFound
+	}
+
+	value, ttl, err := next.GetValue(key)
+	if err != nil {
+		return zero[V](), 0, err
+	}
+
+	if c.FallbackTTL != 0 {
+		ttl = c.FallbackTTL
+	}
+
+	if err := set(key, value, ttl); err != nil {
+		return zero[V](), 0, err
+	}
+
+	return value, ttl, nil
+}
+
+// writeThrough applies a Set to the fallback cache if write-through is enabled.
+func writeThrough[K, V any](c *cache, key K, value V, ttl time.Duration) error {
+	if !c.WriteThrough {
+		return nil
+	}
+
+	next, err := fallback[K, V](c)
+	if err != nil || next == nil {
+		return err
+	}
+
+	return next.Set(key, value, ttl)
+}
+
+// Get retrieves a value from the cache by key and returns its TTL,
+// reading through to the fallback cache on a miss.
+func (c CacheRaw) Get(key []byte, value *[]byte) (time.Duration, error) {
+	v, ttl, err := c.GetValue(key)
+	*value = v
+
+	return ttl, err
+}
+
+// GetValue retrieves a value from the cache by key and returns the value and its TTL,
+// reading through to the fallback cache on a miss.
+func (c CacheRaw) GetValue(key []byte) ([]byte, time.Duration, error) {
+	v, ttl, err := c.cache.GetValue(key)
+	if errors.Is(err, ErrKeyNotFound) {
+		return readThrough(c.cache, key, c.cache.Set)
+	}
+
+	return v, ttl, err
+}
+
+// Set adds a key-value pair to the cache with a specified TTL,
+// and to the fallback cache if write-through is enabled.
+func (c CacheRaw) Set(key, value []byte, ttl time.Duration) error {
+	if err := c.cache.Set(key, value, ttl); err != nil {
+		return err
+	}
+
+	return writeThrough(c.cache, key, value, ttl)
+}
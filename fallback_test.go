@@ -0,0 +1,144 @@
+package cache
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCacheFallback(t *testing.T) {
+	t.Parallel()
+
+	openCache := func(t *testing.T, options ...Option) Cache[string, string] {
+		t.Helper()
+
+		db, err := OpenMem[string, string](options...)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		t.Cleanup(func() {
+			if err := db.Close(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+
+		return db
+	}
+
+	t.Run("Read Through", func(t *testing.T) {
+		t.Parallel()
+
+		l2 := openCache(t)
+		l1 := openCache(t, WithFallback[string, string](l2, time.Minute, false))
+
+		if err := l2.Set("Key", "Value", time.Hour); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		got, ttl, err := l1.GetValue("Key")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if got != "Value" || ttl != time.Minute {
+			t.Fatalf("expected %v %v, got %v %v", "Value", time.Minute, got, ttl)
+		}
+
+		if err := l2.Delete("Key"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// The hit was promoted, so it no longer needs the fallback.
+		if got, _, err := l1.GetValue("Key"); err != nil || got != "Value" {
+			t.Fatalf("expected promoted value, got %v %v", got, err)
+		}
+
+		if _, _, err := l1.GetValue("Missing"); !errors.Is(err, ErrKeyNotFound) {
+			t.Fatalf("expected error: %v, got %v", ErrKeyNotFound, err)
+		}
+	})
+
+	t.Run("Write Through", func(t *testing.T) {
+		t.Parallel()
+
+		l2 := openCache(t)
+		l1 := openCache(t, WithFallback[string, string](l2, 0, true))
+
+		if err := l1.Set("Key", "Value", time.Hour); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		got, ttl, err := l2.GetValue("Key")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if got != "Value" || ttl <= 0 || ttl > time.Hour {
+			t.Fatalf("expected %v within %v, got %v %v", "Value", time.Hour, got, ttl)
+		}
+	})
+
+	t.Run("Raw", func(t *testing.T) {
+		t.Parallel()
+
+		l2, err := OpenRawMem()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		t.Cleanup(func() {
+			if err := l2.Close(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+
+		l1, err := OpenRawMem(WithFallback[[]byte, []byte](l2, 0, true))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		t.Cleanup(func() {
+			if err := l1.Close(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+
+		if err := l2.Set([]byte("Read"), []byte("Value"), 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if got, _, err := l1.GetValue([]byte("Read")); err != nil || string(got) != "Value" {
+			t.Fatalf("expected value from fallback, got %v %v", string(got), err)
+		}
+
+		if err := l1.Set([]byte("Write"), []byte("Value"), 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if _, _, err := l2.GetValue([]byte("Write")); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("Type Mismatch", func(t *testing.T) {
+		t.Parallel()
+
+		l2 := openCache(t)
+
+		db, err := OpenMem[string, int](WithFallback[string, string](l2, 0, false))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		t.Cleanup(func() {
+			if err := db.Close(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+
+		if _, _, err := db.GetValue("Key"); !errors.Is(err, ErrFallbackType) {
+			t.Fatalf("expected error: %v, got %v", ErrFallbackType, err)
+		}
+	})
+}
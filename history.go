@@ -0,0 +1,56 @@
+package cache
+
+import "time"
+
+// EvictionReason describes why an entry was removed by the cache.
+type EvictionReason int
+
+const (
+	// ReasonEvicted indicates the entry was removed by the eviction policy.
+	ReasonEvicted EvictionReason = iota
+	// ReasonExpired indicates the entry was removed by cleanup after its TTL passed.
+	ReasonExpired
+	// ReasonDrained indicates the entry was removed by Drain.
+	ReasonDrained
+)
+
+// EvictionRecord is an entry of the eviction history.
+type EvictionRecord struct {
+	Key    []byte
+	Reason EvictionReason
+	Time   time.Time
+}
+
+// historyRing keeps the most recent eviction records in a fixed size ring buffer.
+type historyRing struct {
+	Records []EvictionRecord
+	Next    int
+	Full    bool
+}
+
+// newHistoryRing creates a ring holding up to n records.
+func newHistoryRing(n int) *historyRing {
+	return &historyRing{Records: make([]EvictionRecord, n)}
+}
+
+// Add records the removal of a key, overwriting the oldest record once full.
+func (h *historyRing) Add(key []byte, reason EvictionReason) {
+	h.Records[h.Next] = EvictionRecord{Key: key, Reason: reason, Time: time.Now()}
+
+	h.Next = (h.Next + 1) % len(h.Records)
+	if h.Next == 0 {
+		h.Full = true
+	}
+}
+
+// List returns the records from oldest to newest.
+func (h *historyRing) List() []EvictionRecord {
+	if !h.Full {
+		return append([]EvictionRecord(nil), h.Records[:h.Next]...)
+	}
+
+	ret := make([]EvictionRecord, 0, len(h.Records))
+	ret = append(ret, h.Records[h.Next:]...)
+
+	return append(ret, h.Records[:h.Next]...)
+}
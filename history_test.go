@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestHistoryRing(t *testing.T) {
+	t.Parallel()
+
+	ring := newHistoryRing(3)
+
+	if got := ring.List(); len(got) != 0 {
+		t.Fatalf("expected empty history, got %v", got)
+	}
+
+	for i := range 5 {
+		ring.Add([]byte(strconv.Itoa(i)), ReasonEvicted)
+	}
+
+	got := ring.List()
+	want := []string{"2", "3", "4"}
+
+	if len(got) != len(want) {
+		t.Fatalf("expected %d records, got %d", len(want), len(got))
+	}
+
+	for i, k := range want {
+		if string(got[i].Key) != k {
+			t.Errorf("expected key %s at %d, got %s", k, i, got[i].Key)
+		}
+	}
+}
+
+func TestStoreEvictionHistory(t *testing.T) {
+	t.Parallel()
+
+	store := setupTestStore(t)
+	store.History = newHistoryRing(2)
+
+	if err := store.Policy.SetPolicy(PolicyFIFO); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	store.Set([]byte("1"), []byte("1"), 0)
+	store.Set([]byte("2"), []byte("2"), time.Nanosecond)
+	store.Set([]byte("3"), []byte("3"), 0)
+	store.Set([]byte("4"), []byte("4"), 0)
+
+	time.Sleep(time.Millisecond)
+	store.Cleanup()
+
+	store.MaxCost = 2
+	store.Evict()
+
+	got := store.EvictionHistory()
+	want := []EvictionRecord{
+		{Key: []byte("1"), Reason: ReasonEvicted},
+		{Key: []byte("3"), Reason: ReasonEvicted},
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("expected %d records, got %d", len(want), len(got))
+	}
+
+	for i := range want {
+		if string(got[i].Key) != string(want[i].Key) || got[i].Reason != want[i].Reason {
+			t.Errorf("expected %s (%v), got %s (%v)", want[i].Key, want[i].Reason, got[i].Key, got[i].Reason)
+		}
+	}
+
+	store.History = newHistoryRing(2)
+	store.Set([]byte("5"), []byte("5"), time.Nanosecond)
+
+	time.Sleep(time.Millisecond)
+	store.Cleanup()
+
+	if got := store.EvictionHistory(); len(got) != 1 || got[0].Reason != ReasonExpired {
+		t.Fatalf("expected one expiry record, got %v", got)
+	}
+}
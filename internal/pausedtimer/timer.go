@@ -2,14 +2,17 @@ package pausedtimer
 
 import (
 	"math"
+	"sync"
 	"time"
 )
 
 // PauseTimer is a struct that wraps a time.Ticker and provides additional functionality
 // to pause and resume the ticker.
 // If the duration is 0, the timer is created in a stopped state.
+// Reset, Resume and GetDuration are safe for concurrent use.
 type PauseTimer struct {
 	*time.Ticker
+	mu       sync.Mutex
 	duration time.Duration
 }
 
@@ -37,6 +40,13 @@ func NewStopped(d time.Duration) *PauseTimer {
 // Reset sets the timer to the specified duration and starts it.
 // If the duration is 0, the timer is stopped.
 func (t *PauseTimer) Reset(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.reset(d)
+}
+
+func (t *PauseTimer) reset(d time.Duration) {
 	t.duration = d
 	if t.duration == 0 {
 		t.Stop()
@@ -47,10 +57,16 @@ func (t *PauseTimer) Reset(d time.Duration) {
 
 // Resume resumes the timer with its last set duration.
 func (t *PauseTimer) Resume() {
-	t.Reset(t.GetDuration())
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.reset(t.duration)
 }
 
 // GetDuration returns the current duration of the timer.
 func (t *PauseTimer) GetDuration() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
 	return t.duration
 }
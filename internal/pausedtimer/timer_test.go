@@ -1,6 +1,7 @@
 package pausedtimer
 
 import (
+	"sync"
 	"testing"
 	"time"
 )
@@ -97,3 +98,33 @@ func TestPauseTimerGetDuration(t *testing.T) {
 		t.Errorf("expected duration %v, got %v", d, timer.GetDuration())
 	}
 }
+
+func TestPauseTimerConcurrentReset(t *testing.T) {
+	t.Parallel()
+
+	timer := NewStopped(time.Second)
+
+	var wg sync.WaitGroup
+
+	for i := range 10 {
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+
+			timer.Reset(time.Duration(i+1) * time.Second)
+		}()
+
+		go func() {
+			defer wg.Done()
+
+			timer.Resume()
+		}()
+	}
+
+	wg.Wait()
+
+	if d := timer.GetDuration(); d < time.Second || d > 10*time.Second {
+		t.Errorf("expected a duration set by Reset, got %v", d)
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !cachedebug
+
+package cache
+
+// debug enables internal consistency checks that panic instead of recovering.
+// Build with the cachedebug tag to enable it.
+const debug = false
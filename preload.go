@@ -0,0 +1,79 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Preload warms up the cache by running factory for every key that is not present,
+// on up to concurrency goroutines, and storing the results with ttl.
+// Factories run without holding the cache lock, so unlike Memorize they do not block
+// other operations. A key stored by someone else in the meantime is kept.
+// The errors of all keys that failed are returned joined.
+func (c Cache[K, V]) Preload(keys []K, factory func(K) (V, error), ttl time.Duration, concurrency int) error {
+	return preload(c, keys, factory, ttl, concurrency)
+}
+
+// Preload warms up the cache by running factory for every key that is not present,
+// like Cache.Preload.
+func (c CacheRaw) Preload(keys [][]byte, factory func([]byte) ([]byte, error), ttl time.Duration, concurrency int) error {
+	return preload(c, keys, factory, ttl, concurrency)
+}
+
+// preload implements Preload on top of HasMulti and Memorize.
+func preload[K, V any](c Cacher[K, V], keys []K, factory func(K) (V, error), ttl time.Duration, concurrency int) error {
+	present, err := c.HasMulti(keys)
+	if err != nil {
+		return err
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+
+	jobs := make(chan K)
+
+	for range max(concurrency, 1) {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for key := range jobs {
+				if err := preloadKey(c, key, factory, ttl); err != nil {
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for i, key := range keys {
+		if !present[i] {
+			jobs <- key
+		}
+	}
+
+	close(jobs)
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// preloadKey runs factory for key and stores the result unless the key was set meanwhile.
+func preloadKey[K, V any](c Cacher[K, V], key K, factory func(K) (V, error), ttl time.Duration) error {
+	value, err := factory(key)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.Memorize(key, func() (V, error) {
+		return value, nil
+	}, ttl)
+
+	return err
+}
@@ -0,0 +1,164 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var ErrInvalidShards = errors.New("shard count must be positive") // ErrInvalidShards is returned when opening a sharded cache with no shards.
+
+// Sharded splits a file-backed cache across several files by key hash, keeping each
+// snapshot smaller and flushing the shards in parallel.
+// Keys are routed by the high bits of the FNV-1 hash of their encoded form, since
+// the buckets of each shard are picked by the low bits, so a sharded cache must
+// always be reopened with the same number of shards.
+type Sharded[K any, V any] struct {
+	Shards []Cache[K, V]
+}
+
+// OpenSharded opens a cache split across shards files in dir, creating dir if needed.
+// The options are applied to every shard, so limits such as WithMaxCost apply per shard.
+func OpenSharded[K, V any](dir string, shards int, options ...Option) (Sharded[K, V], error) {
+	if shards <= 0 {
+		return zero[Sharded[K, V]](), ErrInvalidShards
+	}
+
+	if err := os.MkdirAll(dir, 0o777); err != nil {
+		return zero[Sharded[K, V]](), err
+	}
+
+	ret := Sharded[K, V]{Shards: make([]Cache[K, V], 0, shards)}
+
+	for i := range shards {
+		shard, err := OpenFile[K, V](shardFile(dir, i), options...)
+		if err != nil {
+			return zero[Sharded[K, V]](), errors.Join(err, ret.Close())
+		}
+
+		ret.Shards = append(ret.Shards, shard)
+	}
+
+	// Keys of snapshots written before rawRevision are re-encoded when loaded, and
+	// earlier versions routed keys by the low bits of the hash, so keys may now
+	// belong to another shard.
+	for _, shard := range ret.Shards {
+		err := shard.Store.Rehome(func(key []byte) *store {
+			return &ret.Shards[ret.shardOf(key)].Store
+		})
+		if err != nil {
+			return zero[Sharded[K, V]](), errors.Join(err, ret.Close())
+		}
+	}
+
+	return ret, nil
+}
+
+// shardFile returns the name of the file backing shard i.
+func shardFile(dir string, i int) string {
+	return filepath.Join(dir, fmt.Sprintf("shard-%04d.db", i))
+}
+
+// ShardOf returns the index of the shard that owns key.
+func (c Sharded[K, V]) ShardOf(key K) (int, error) {
+	keyData, err := c.Shards[0].encodeKey(key)
+	if err != nil {
+		return 0, err
+	}
+
+	return c.shardOf(keyData), nil
+}
+
+// shardOf returns the index of the shard that owns an encoded key.
+func (c Sharded[K, V]) shardOf(keyData []byte) int {
+	return int((hash(keyData) >> 32) % uint64(len(c.Shards)))
+}
+
+// shard returns the shard that owns key.
+func (c Sharded[K, V]) shard(key K) (Cache[K, V], error) {
+	i, err := c.ShardOf(key)
+	if err != nil {
+		return zero[Cache[K, V]](), err
+	}
+
+	return c.Shards[i], nil
+}
+
+// Get retrieves a value from the owning shard by key and returns its TTL.
+func (c Sharded[K, V]) Get(key K, value *V) (time.Duration, error) {
+	shard, err := c.shard(key)
+	if err != nil {
+		return 0, err
+	}
+
+	return shard.Get(key, value)
+}
+
+// GetValue retrieves a value from the owning shard by key and returns the value and its TTL.
+func (c Sharded[K, V]) GetValue(key K) (V, time.Duration, error) {
+	shard, err := c.shard(key)
+	if err != nil {
+		return zero[V](), 0, err
+	}
+
+	return shard.GetValue(key)
+}
+
+// Set adds a key-value pair to the owning shard with a specified TTL.
+func (c Sharded[K, V]) Set(key K, value V, ttl time.Duration) error {
+	shard, err := c.shard(key)
+	if err != nil {
+		return err
+	}
+
+	return shard.Set(key, value, ttl)
+}
+
+// Delete removes a key from the owning shard.
+func (c Sharded[K, V]) Delete(key K) error {
+	shard, err := c.shard(key)
+	if err != nil {
+		return err
+	}
+
+	return shard.Delete(key)
+}
+
+// Flush writes every shard to its file in parallel.
+func (c Sharded[K, V]) Flush() error {
+	return c.each(Cache[K, V].Flush)
+}
+
+// Close closes every shard in parallel.
+func (c Sharded[K, V]) Close() error {
+	return c.each(Cache[K, V].Close)
+}
+
+// CloseWithoutFlush closes every shard in parallel without writing their files.
+func (c Sharded[K, V]) CloseWithoutFlush() error {
+	return c.each(Cache[K, V].CloseWithoutFlush)
+}
+
+// each calls fn for every shard concurrently and joins the errors.
+func (c Sharded[K, V]) each(fn func(Cache[K, V]) error) error {
+	errs := make([]error, len(c.Shards))
+
+	var wg sync.WaitGroup
+
+	for i, shard := range c.Shards {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			errs[i] = fn(shard)
+		}()
+	}
+
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
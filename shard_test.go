@@ -0,0 +1,179 @@
+package cache
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func TestOpenSharded(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	const shards = 4
+
+	db, err := OpenSharded[string, string](dir, shards)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	owner := map[string]int{}
+
+	for i := range 100 {
+		key := strconv.Itoa(i)
+
+		if err := db.Set(key, "Value"+key, 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		shard, err := db.ShardOf(key)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		owner[key] = shard
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Every key is stored in the file of its shard and nowhere else.
+	for i := range shards {
+		shard, err := OpenFile[string, string](shardFile(dir, i))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		for key, want := range owner {
+			_, _, err := shard.GetValue(key)
+			if found := err == nil; found != (want == i) {
+				t.Fatalf("key %s: expected in shard %d, found in shard %d: %v", key, want, i, found)
+			}
+		}
+
+		if err := shard.Close(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	db, err = OpenSharded[string, string](dir, shards)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	for key := range owner {
+		got, _, err := db.GetValue(key)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if got != "Value"+key {
+			t.Fatalf("expected Value%s, got %s", key, got)
+		}
+	}
+}
+
+func TestShardedBucketSpread(t *testing.T) {
+	t.Parallel()
+
+	const (
+		shards  = 4
+		buckets = 64
+	)
+
+	db, err := OpenSharded[string, string](t.TempDir(), shards)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	// The keys of a shard must still spread over all the buckets of its table.
+	used := map[uint64]bool{}
+
+	for i := range 10000 {
+		key := strconv.Itoa(i)
+
+		shard, err := db.ShardOf(key)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if shard != 0 {
+			continue
+		}
+
+		keyData, err := db.Shards[0].encodeKey(key)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		used[hash(keyData)%buckets] = true
+	}
+
+	if len(used) != buckets {
+		t.Fatalf("expected keys in all %d buckets, got %d", buckets, len(used))
+	}
+}
+
+func TestOpenShardedLegacy(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	const shards = 4
+
+	// Every key starts in the first shard, as the owners of re-encoded keys change.
+	entries := map[string]string{}
+	for i := range 20 {
+		entries[strconv.Itoa(i)] = "Value" + strconv.Itoa(i)
+	}
+
+	writeLegacySnapshot(t, shardFile(dir, 0), FormatBinary, entries)
+
+	db, err := OpenSharded[string, string](dir, shards)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	for key, want := range entries {
+		got, _, err := db.GetValue(key)
+		if err != nil {
+			t.Fatalf("key %s: unexpected error: %v", key, err)
+		}
+
+		if got != want {
+			t.Fatalf("expected %s, got %s", want, got)
+		}
+	}
+
+	if got := db.Shards[0].Store.Length; got == uint64(len(entries)) {
+		t.Fatalf("expected keys to be moved to their shards")
+	}
+}
+
+func TestOpenShardedInvalid(t *testing.T) {
+	t.Parallel()
+
+	if _, err := OpenSharded[string, string](t.TempDir(), 0); !errors.Is(err, ErrInvalidShards) {
+		t.Fatalf("expected error: %v, got %v", ErrInvalidShards, err)
+	}
+}
@@ -0,0 +1,208 @@
+package cache
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// statsBuckets is the number of buckets the stats window is split into.
+const statsBuckets = 10
+
+// statsBucket counts the hits and misses of one slice of the stats window.
+type statsBucket struct {
+	Hits   uint64
+	Misses uint64
+}
+
+// windowStats tracks hits and misses over a rolling time window using a ring of buckets.
+type windowStats struct {
+	Buckets [statsBuckets]statsBucket
+	Head    int
+	Start   time.Time
+	Span    time.Duration
+	Now     func() time.Time
+
+	Lock sync.Mutex
+}
+
+// newWindowStats creates stats covering the given window.
+func newWindowStats(window time.Duration) *windowStats {
+	w := &windowStats{
+		Span: window / statsBuckets,
+		Now:  time.Now,
+	}
+	w.Start = w.Now()
+
+	return w
+}
+
+// Record counts a hit or a miss in the current bucket.
+func (w *windowStats) Record(hit bool) {
+	w.Lock.Lock()
+	defer w.Lock.Unlock()
+
+	if hit {
+		w.Buckets[w.Head].Hits++
+	} else {
+		w.Buckets[w.Head].Misses++
+	}
+}
+
+// Advance moves the ring forward by the number of buckets elapsed since the
+// current bucket started, dropping the counts that fell out of the window.
+func (w *windowStats) Advance() {
+	w.Lock.Lock()
+	defer w.Lock.Unlock()
+
+	elapsed := w.Now().Sub(w.Start)
+	if w.Span <= 0 || elapsed < w.Span {
+		return
+	}
+
+	steps := int(elapsed / w.Span)
+	w.Start = w.Start.Add(time.Duration(steps) * w.Span)
+
+	for range min(steps, statsBuckets) {
+		w.Head = (w.Head + 1) % statsBuckets
+		w.Buckets[w.Head] = statsBucket{}
+	}
+}
+
+// HitRatio returns the share of hits among the lookups in the window.
+func (w *windowStats) HitRatio() float64 {
+	w.Lock.Lock()
+	defer w.Lock.Unlock()
+
+	var hits, total uint64
+
+	for _, b := range w.Buckets {
+		hits += b.Hits
+		total += b.Hits + b.Misses
+	}
+
+	if total == 0 {
+		return 0
+	}
+
+	return float64(hits) / float64(total)
+}
+
+// histogramBounds is the number of bucket bounds of a histogram. The bounds start
+// at a microsecond and grow by a factor of 4, reaching about a second.
+const histogramBounds = 11
+
+// Histogram is a distribution of durations. Counts[i] is the number of samples
+// no longer than Bounds[i], and above the previous bound. The last count holds the
+// samples above every bound.
+type Histogram struct {
+	Bounds []time.Duration
+	Counts []uint64
+	Count  uint64
+	Sum    time.Duration
+}
+
+// histogram counts durations in buckets with fixed bounds using atomic counters.
+type histogram struct {
+	Counts [histogramBounds + 1]atomic.Uint64
+	Sum    atomic.Int64
+}
+
+// histogramBound returns the upper bound of bucket i.
+func histogramBound(i int) time.Duration {
+	return time.Microsecond << (2 * i)
+}
+
+// Record adds a sample to the histogram.
+func (h *histogram) Record(d time.Duration) {
+	i := 0
+	for i < histogramBounds && d > histogramBound(i) {
+		i++
+	}
+
+	h.Counts[i].Add(1)
+	h.Sum.Add(int64(d))
+}
+
+// Clear removes all samples.
+func (h *histogram) Clear() {
+	for i := range h.Counts {
+		h.Counts[i].Store(0)
+	}
+
+	h.Sum.Store(0)
+}
+
+// Snapshot returns a copy of the histogram.
+func (h *histogram) Snapshot() Histogram {
+	ret := Histogram{
+		Bounds: make([]time.Duration, histogramBounds),
+		Counts: make([]uint64, len(h.Counts)),
+		Sum:    time.Duration(h.Sum.Load()),
+	}
+
+	for i := range ret.Bounds {
+		ret.Bounds[i] = histogramBound(i)
+	}
+
+	for i := range h.Counts {
+		ret.Counts[i] = h.Counts[i].Load()
+		ret.Count += ret.Counts[i]
+	}
+
+	return ret
+}
+
+// Stats holds the metrics collected by the cache.
+type Stats struct {
+	LockWait Histogram // LockWait is the time writers waited for the store lock.
+	LockHold Histogram // LockHold is the time writers held the store lock.
+
+	SnapshotWritten  uint64 // SnapshotWritten is the total number of bytes written to the file by snapshots.
+	LastSnapshotSize uint64 // LastSnapshotSize is the size of the last snapshot written to the file.
+}
+
+// metricLock is the store lock. When Metrics is set, it records how long writers
+// wait to acquire it and how long they hold it. Readers are not measured.
+type metricLock struct {
+	sync.RWMutex
+
+	Metrics  atomic.Bool
+	Acquired time.Time // Acquired is when the current writer took the lock, if measured.
+	Wait     histogram
+	Hold     histogram
+}
+
+// Lock acquires the write lock, recording the wait if metrics are enabled.
+func (l *metricLock) Lock() {
+	if !l.Metrics.Load() {
+		l.RWMutex.Lock()
+		return
+	}
+
+	start := time.Now()
+
+	l.RWMutex.Lock()
+
+	l.Acquired = time.Now()
+	l.Wait.Record(l.Acquired.Sub(start))
+}
+
+// Unlock releases the write lock, recording the hold time if the wait was recorded.
+func (l *metricLock) Unlock() {
+	if !l.Acquired.IsZero() {
+		l.Hold.Record(time.Since(l.Acquired))
+		l.Acquired = time.Time{}
+	}
+
+	l.RWMutex.Unlock()
+}
+
+// ResetMetrics disables the metrics and removes the recorded samples.
+// The caller must hold the write lock.
+func (l *metricLock) ResetMetrics() {
+	l.Metrics.Store(false)
+	l.Acquired = time.Time{}
+	l.Wait.Clear()
+	l.Hold.Clear()
+}
@@ -0,0 +1,305 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWindowStats(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	stats := newWindowStats(10 * time.Second)
+	stats.Now = func() time.Time { return now }
+	stats.Start = now
+
+	if got := stats.HitRatio(); got != 0 {
+		t.Fatalf("expected ratio %v, got %v", 0, got)
+	}
+
+	for range 4 {
+		stats.Record(true)
+	}
+
+	if got := stats.HitRatio(); got != 1 {
+		t.Fatalf("expected ratio %v, got %v", 1, got)
+	}
+
+	// Half a window later the hits are still counted
+	now = now.Add(5 * time.Second)
+	stats.Advance()
+
+	for range 4 {
+		stats.Record(false)
+	}
+
+	if got := stats.HitRatio(); got != 0.5 {
+		t.Fatalf("expected ratio %v, got %v", 0.5, got)
+	}
+
+	// A full window after the hits only the misses remain
+	now = now.Add(5 * time.Second)
+	stats.Advance()
+
+	if got := stats.HitRatio(); got != 0 {
+		t.Fatalf("expected ratio %v, got %v", 0, got)
+	}
+
+	// Long idle periods clear the whole window
+	now = now.Add(time.Hour)
+	stats.Advance()
+
+	stats.Record(true)
+
+	if got := stats.HitRatio(); got != 1 {
+		t.Fatalf("expected ratio %v, got %v", 1, got)
+	}
+}
+
+func TestCacheHitRatio(t *testing.T) {
+	t.Parallel()
+
+	db, err := OpenRawMem(WithStatsWindow(time.Minute))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	if err := db.Set([]byte("Key"), []byte("Value"), 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, key := range []string{"Key", "Key", "Key", "Missing"} {
+		_, _, _ = db.GetValue([]byte(key))
+	}
+
+	if got := db.HitRatio(); got != 0.75 {
+		t.Fatalf("expected ratio %v, got %v", 0.75, got)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	t.Parallel()
+
+	var h histogram
+
+	for _, d := range []time.Duration{0, time.Microsecond, 2 * time.Microsecond, time.Millisecond, time.Hour} {
+		h.Record(d)
+	}
+
+	got := h.Snapshot()
+
+	want := map[int]uint64{0: 2, 1: 1, 5: 1, histogramBounds: 1}
+	for i, count := range got.Counts {
+		if count != want[i] {
+			t.Errorf("bucket %d (<= %v): expected %d samples, got %d", i, histogramBound(i), want[i], count)
+		}
+	}
+
+	if got.Count != 5 {
+		t.Errorf("expected %d samples, got %d", 5, got.Count)
+	}
+
+	if wantSum := time.Hour + time.Millisecond + 3*time.Microsecond; got.Sum != wantSum {
+		t.Errorf("expected sum %v, got %v", wantSum, got.Sum)
+	}
+
+	h.Clear()
+
+	if got := h.Snapshot(); got.Count != 0 || got.Sum != 0 {
+		t.Errorf("expected an empty histogram, got %d samples", got.Count)
+	}
+}
+
+func TestCacheLockMetrics(t *testing.T) {
+	t.Parallel()
+
+	db, err := OpenRawMem(WithLockMetrics())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	const workers, writes = 8, 200
+
+	var wg sync.WaitGroup
+
+	for w := range workers {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := range writes {
+				key := []byte(strconv.Itoa(w*writes + i))
+				if err := db.Set(key, key, 0); err != nil {
+					t.Errorf("unexpected error: %v", err)
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	stats := db.Stats()
+
+	if stats.LockWait.Count < workers*writes {
+		t.Errorf("expected at least %d wait samples, got %d", workers*writes, stats.LockWait.Count)
+	}
+
+	if stats.LockHold.Count < workers*writes {
+		t.Errorf("expected at least %d hold samples, got %d", workers*writes, stats.LockHold.Count)
+	}
+
+	if stats.LockHold.Sum <= 0 {
+		t.Errorf("expected a positive hold time, got %v", stats.LockHold.Sum)
+	}
+
+	if err := db.Reset(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := db.Set([]byte("Key"), []byte("Value"), 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := db.Stats().LockWait.Count; got != 0 {
+		t.Errorf("expected no samples after Reset, got %d", got)
+	}
+}
+
+func TestCacheLockMetricsDisabled(t *testing.T) {
+	t.Parallel()
+
+	db := setupTestCache[string, string](t)
+
+	if err := db.Set("Key", "Value", 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if stats := db.Stats(); stats.LockWait.Count != 0 || stats.LockHold.Count != 0 {
+		t.Fatalf("expected no samples, got %d and %d", stats.LockWait.Count, stats.LockHold.Count)
+	}
+}
+
+func TestCacheSnapshotWritten(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "cache.db")
+
+	db, err := OpenRawFile(path, SetSnapshotTime(time.Hour))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	size := func() uint64 {
+		t.Helper()
+
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		return uint64(info.Size())
+	}
+
+	// Opening an empty file writes the initial snapshot.
+	total := size()
+
+	if stats := db.Stats(); stats.SnapshotWritten != total || stats.LastSnapshotSize != total {
+		t.Fatalf("expected %d bytes written, got %d, last %d", total, stats.SnapshotWritten, stats.LastSnapshotSize)
+	}
+
+	for i := range 3 {
+		for j := range 10 {
+			key := []byte(strconv.Itoa(i*10 + j))
+			if err := db.Set(key, key, 0); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+
+		if err := db.Flush(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		written := size()
+		total += written
+
+		stats := db.Stats()
+
+		if stats.SnapshotWritten != total {
+			t.Fatalf("expected %d bytes written, got %d", total, stats.SnapshotWritten)
+		}
+
+		if stats.LastSnapshotSize != written {
+			t.Fatalf("expected a last snapshot of %d bytes, got %d", written, stats.LastSnapshotSize)
+		}
+	}
+}
+
+func TestCacheMinSnapshotInterval(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "cache.db")
+
+	db, err := OpenRawFile(path, SetSnapshotTime(10*time.Millisecond), WithMinSnapshotInterval(time.Hour))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	initial := db.Stats().SnapshotWritten
+
+	if err := db.Set([]byte("First"), []byte("Value"), 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The first background snapshot is not limited.
+	deadline := time.Now().Add(time.Second)
+	for db.Stats().SnapshotWritten == initial {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected a background snapshot")
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	written := db.Stats().SnapshotWritten
+
+	if err := db.Set([]byte("Second"), []byte("Value"), 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if got := db.Stats().SnapshotWritten; got != written {
+		t.Fatalf("expected no snapshot within the minimum interval, got %d bytes written instead of %d", got, written)
+	}
+}
@@ -2,15 +2,55 @@ package cache
 
 import (
 	"bytes"
+	"cmp"
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"hash/maphash"
+	"math"
+	"math/bits"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/vmihailenco/msgpack/v5"
 	"go.sudomsg.com/cache/internal/pausedtimer"
 )
 
+// TTL sentinels that can be passed wherever a TTL is expected.
+const (
+	// NoExpire stores an entry without expiration, even if a default TTL is configured.
+	NoExpire time.Duration = math.MaxInt64
+	// DefaultTTL stores an entry with the TTL configured by WithDefaultTTL.
+	// A TTL of 0 behaves the same way.
+	DefaultTTL time.Duration = math.MinInt64
+)
+
 const (
 	initialBucketSize uint64  = 8
 	loadFactor        float64 = 0.9
+
+	// parallelResizeMin is the table size below which Resize never uses multiple goroutines.
+	parallelResizeMin = 4096
+
+	// rangeCheckInterval is the number of entries visited between context checks in Range.
+	rangeCheckInterval = 16
+)
+
+// Defaults applied to every newly opened cache and restored by Reset. They are
+// read when a cache is opened, so changing them does not affect open caches and
+// should happen before any cache is opened, typically from an init function or
+// TestMain.
+var (
+	// DefaultCleanupInterval is the interval between background expiration sweeps.
+	// Override it per cache with SetCleanupTime.
+	DefaultCleanupInterval = 10 * time.Second
+	// DefaultSnapshotInterval is the interval between background snapshots. A
+	// value of 0 disables periodic snapshots. Override it per cache with
+	// SetSnapshotTime.
+	DefaultSnapshotInterval time.Duration = 0
 )
 
 // node represents an entry in the cache with metadata for eviction and expiration.
@@ -20,11 +60,28 @@ type node struct {
 	Value      []byte
 	Expiration time.Time
 	Access     uint64
+	Protected  bool
+	LastAccess uint64
+	Blob       bool
+	InArena    bool
+	Slot       arenaSlot // Slot locates the value in the arena if InArena is set.
+	Version    uint64
+	Generation uint64
+	Written    time.Time
+	Tags       []string
+
+	// Access metadata kept with WithAccessTracking, in Unix nanoseconds. AccessedAt
+	// and Hits are updated under the read lock, so they are only accessed atomically.
+	CreatedAt  int64
+	AccessedAt int64
+	Hits       uint64
 
 	HashNext  *node
 	HashPrev  *node
 	EvictNext *node
 	EvictPrev *node
+	WheelNext *node
+	WheelPrev *node
 }
 
 func (n *node) UnlinkHash() {
@@ -56,37 +113,135 @@ func (n *node) TTL() time.Duration {
 }
 
 func (n *node) Cost() uint64 {
-	return uint64(len(n.Key) + len(n.Value))
+	return uint64(len(n.Key)+len(n.Value)) + uint64(n.Slot.Length)
+}
+
+// cost returns the cost of a node including the fixed per-entry overhead.
+func (s *store) cost(v *node) uint64 {
+	return v.Cost() + s.EntryOverhead
 }
 
 // store represents the in-memory cache with eviction policies and periodic tasks.
 type store struct {
-	Bucket         []node
-	Length         uint64
-	Cost           uint64
-	EvictList      node
-	MaxCost        uint64
-	SnapshotTicker *pausedtimer.PauseTimer
-	CleanupTicker  *pausedtimer.PauseTimer
-	Policy         evictionPolicy
-
-	Lock      sync.RWMutex
-	EvictLock sync.RWMutex
+	Bucket             []node
+	Length             uint64
+	Cost               uint64
+	EvictList          node
+	MaxCost            uint64
+	EntryOverhead      uint64
+	Unlimited          bool
+	Wheel              *expiryWheel
+	Tags               tagIndex
+	MaxAge             time.Duration
+	DefaultTTL         time.Duration
+	MinTTL             time.Duration
+	MaxTTL             time.Duration
+	SnapshotTicker     *pausedtimer.PauseTimer
+	ForceTicker        *pausedtimer.PauseTimer
+	CleanupTicker      *pausedtimer.PauseTimer
+	StatsTicker        *pausedtimer.PauseTimer
+	Stats              *windowStats
+	History            *historyRing
+	Format             SnapshotFormat
+	ResizeWorkers      int
+	MaxBuckets         uint64
+	SnapshotBufferSize int
+	SnapshotFilter     func(key, value []byte, ttl time.Duration) bool
+	Upgrade            func(key, value []byte) ([]byte, []byte, error) // Upgrade converts entries of snapshots older than rawRevision.
+	SyncMaintain       bool
+	Overshoot          float64
+	RejectOnFull       bool
+	IncrementRefresh   bool
+	TrackAge           bool
+	TrackAccess        bool
+	Generation         uint64
+	Now                func() time.Time
+	Dirty              atomic.Bool
+	Policy             evictionPolicy
+	OnSet              func(key, value []byte, ttl time.Duration)
+	OnGet              func(key []byte, hit bool)
+	OnEvict            func(key, value []byte, reason EvictionReason)
+	Hasher             func(key []byte) uint64
+	Blobs              *blobStore
+	Arena              *arena
+	OverflowCost       uint64
+
+	Stripes      [updateStripes]sync.Mutex
+	Lock         metricLock
+	EvictLock    sync.RWMutex
+	SnapshotLock sync.Mutex
 }
 
 // Init initializes the store with default settings.
 func (s *store) Init() {
 	s.Clear()
 	s.Policy = evictionPolicy{
-		ListLock: &s.EvictLock,
-		Sentinel: &s.EvictList,
+		ListLock:   &s.EvictLock,
+		Sentinel:   &s.EvictList,
+		Bucket:     &s.Bucket,
+		Segment:    slruSegment{Last: &s.EvictList},
+		SLRURatio:  defaultSLRURatio,
+		SampleSize: defaultSampleSize,
 	}
-	s.SnapshotTicker = pausedtimer.NewStopped(0)
-	s.CleanupTicker = pausedtimer.NewStopped(10 * time.Second)
+	s.SnapshotTicker = pausedtimer.NewStopped(DefaultSnapshotInterval)
+	s.ForceTicker = pausedtimer.NewStopped(0)
+	s.CleanupTicker = pausedtimer.NewStopped(DefaultCleanupInterval)
+	s.StatsTicker = pausedtimer.NewStopped(0)
+	s.Now = time.Now
 
 	if err := s.Policy.SetPolicy(PolicyNone); err != nil {
 		panic(err)
 	}
+
+	s.Dirty.Store(false)
+}
+
+// Reset removes all entries from the store and restores the default configuration.
+func (s *store) Reset() error {
+	s.Clear()
+
+	s.Lock.Lock()
+	defer s.Lock.Unlock()
+
+	s.MaxCost = 0
+	s.EntryOverhead = 0
+	s.Unlimited = false
+	s.Wheel = nil
+	s.MaxAge = 0
+	s.DefaultTTL = 0
+	s.MinTTL = 0
+	s.MaxTTL = 0
+	s.OnSet = nil
+	s.OnGet = nil
+	s.OnEvict = nil
+	s.Hasher = nil
+	s.Blobs = nil
+	s.Arena = nil
+	s.OverflowCost = 0
+	s.Stats = nil
+	s.History = nil
+	s.Format = FormatBinary
+	s.ResizeWorkers = 0
+	s.MaxBuckets = 0
+	s.SnapshotBufferSize = 0
+	s.SnapshotFilter = nil
+	s.SyncMaintain = false
+	s.Overshoot = 0
+	s.RejectOnFull = false
+	s.IncrementRefresh = false
+	s.TrackAge = false
+	s.TrackAccess = false
+	s.Lock.ResetMetrics()
+
+	s.SnapshotTicker.Reset(DefaultSnapshotInterval)
+	s.ForceTicker.Reset(0)
+	s.CleanupTicker.Reset(DefaultCleanupInterval)
+	s.StatsTicker.Reset(0)
+
+	s.Policy.SLRURatio = defaultSLRURatio
+	s.Policy.SampleSize = defaultSampleSize
+
+	return s.Policy.SetPolicy(PolicyNone)
 }
 
 // Clear removes all entries from the store.
@@ -94,27 +249,106 @@ func (s *store) Clear() {
 	s.Lock.Lock()
 	defer s.Lock.Unlock()
 
-	s.Bucket = make([]node, initialBucketSize)
+	s.clear()
+}
+
+// Drain passes every entry to the OnEvict hook and then removes all entries.
+func (s *store) Drain() {
+	s.Lock.Lock()
+	defer s.Lock.Unlock()
+
+	for v := s.EvictList.EvictNext; v != &s.EvictList; v = v.EvictNext {
+		s.record(v, ReasonDrained)
+	}
+
+	s.clear()
+}
+
+// clear removes all entries from the store. The caller must hold the store lock.
+func (s *store) clear() {
+	for v := s.EvictList.EvictNext; v != nil && v != &s.EvictList; v = v.EvictNext {
+		s.dropBlob(v)
+	}
+
+	if s.Arena != nil {
+		s.Arena = newArena(s.Arena.SlabSize)
+	}
+
+	if s.Wheel != nil {
+		s.Wheel = newExpiryWheel()
+	}
+
+	s.Bucket = newBuckets(int(initialBucketSize))
+	s.Tags = nil
 	s.Length = 0
 	s.Cost = 0
+	s.Dirty.Store(true)
 
 	s.EvictList.EvictNext = &s.EvictList
 	s.EvictList.EvictPrev = &s.EvictList
+	s.Policy.Segment = slruSegment{Last: &s.EvictList}
+	s.Policy.renew()
+}
+
+// hash hashes a key with the configured hasher, falling back to FNV-1.
+func (s *store) hash(key []byte) uint64 {
+	if s.Hasher != nil {
+		return s.Hasher(key)
+	}
+
+	return hash(key)
+}
+
+// setHasher replaces the hash function and rehashes all entries with it.
+// The caller must hold the store lock.
+func (s *store) setHasher(fn func(key []byte) uint64) {
+	s.Hasher = fn
+
+	for v := s.EvictList.EvictNext; v != &s.EvictList; v = v.EvictNext {
+		v.Hash = s.hash(v.Key)
+	}
+
+	// Entries may move to any bucket, so this cannot use the parallel resize.
+	bucket := newBuckets(len(s.Bucket))
+	rehash(s.Bucket, bucket)
+	s.Bucket = bucket
+}
+
+// HashDistribution returns the number of entries in each bucket of the hash table.
+func (s *store) HashDistribution() []int {
+	s.Lock.RLock()
+	defer s.Lock.RUnlock()
+
+	counts := make([]int, len(s.Bucket))
+
+	for i := range s.Bucket {
+		sentinel := &s.Bucket[i]
+		for v := sentinel.HashNext; v != sentinel; v = v.HashNext {
+			counts[i]++
+		}
+	}
+
+	return counts
 }
 
 // lookupIdx calculates the hash and index for a given key.
 func lookupIdx(s *store, key []byte) (uint64, uint64) {
-	hash := hash(key)
+	hash := s.hash(key)
 
 	return hash % uint64(len(s.Bucket)), hash
 }
 
-// lazyInitBucket initializes the hash bucket if it hasn't been initialized yet.
-func lazyInitBucket(n *node) {
-	if n.HashNext == nil {
-		n.HashNext = n
-		n.HashPrev = n
+// newBuckets allocates a hash table with every bucket sentinel linked to itself, so
+// lookups never have to check for an uninitialized bucket.
+func newBuckets(size int) []node {
+	bucket := make([]node, size)
+
+	for i := range bucket {
+		bucket[i].HashNext = &bucket[i]
+		bucket[i].HashPrev = &bucket[i]
 	}
+
+	return bucket
 }
 
 // lookup finds a node in the store by key.
@@ -122,9 +356,6 @@ func (s *store) lookup(key []byte) (*node, uint64, uint64) {
 	idx, hash := lookupIdx(s, key)
 
 	bucket := &s.Bucket[idx]
-
-	lazyInitBucket(bucket)
-
 	for v := bucket.HashNext; v != bucket; v = v.HashNext {
 		if bytes.Equal(key, v.Key) {
 			return v, idx, hash
@@ -134,238 +365,1887 @@ func (s *store) lookup(key []byte) (*node, uint64, uint64) {
 	return nil, idx, hash
 }
 
-// Get retrieves a value from the store by key with locking.
-func (s *store) Get(key []byte) ([]byte, time.Duration, bool) {
+// HasMulti reports for each key whether it holds a valid entry, under a single read lock.
+func (s *store) HasMulti(keys [][]byte) []bool {
 	s.Lock.RLock()
 	defer s.Lock.RUnlock()
 
-	v, _, _ := s.lookup(key)
-	if v != nil {
-		if !v.IsValid() {
-			return nil, 0, false
-		}
-
-		s.Policy.OnAccess(v)
+	found := make([]bool, len(keys))
 
-		return v.Value, v.TTL(), true
+	for i, key := range keys {
+		v, _, _ := s.lookup(key)
+		found[i] = v != nil && v.IsValid()
 	}
 
-	return nil, 0, false
+	return found
 }
 
-// resize doubles the size of the hash table and rehashes all entries.
-func (s *store) Resize() {
-	bucket := make([]node, 2*len(s.Bucket))
+// Touch resets the expiration of a key to ttl in place, without touching its value,
+// and reports whether the key was present and not expired.
+func (s *store) Touch(key []byte, ttl time.Duration) (bool, error) {
+	n, err := s.ExpireMulti([][]byte{key}, ttl)
 
-	for i := range s.Bucket {
-		sentinel := &s.Bucket[i]
-		if sentinel.HashNext == nil {
-			continue
-		}
+	return n == 1, err
+}
 
-		var order []*node
-		for v := sentinel.HashNext; v != sentinel; v = v.HashNext {
-			order = append(order, v)
-		}
+// ExpireMulti resets the expiration of every present key to ttl under a single write
+// lock and returns how many entries were updated. Missing and expired keys are skipped.
+func (s *store) ExpireMulti(keys [][]byte, ttl time.Duration) (int, error) {
+	s.Lock.Lock()
+	defer s.Lock.Unlock()
 
-		for _, v := range order {
-			idx := v.Hash % uint64(len(bucket))
+	if err := s.checkTTL(ttl); err != nil {
+		return 0, err
+	}
 
-			n := &bucket[idx]
-			lazyInitBucket(n)
+	expiration := s.expiration(ttl)
+	updated := 0
 
-			v.HashPrev = n
-			v.HashNext = v.HashPrev.HashNext
-			v.HashNext.HashPrev = v
-			v.HashPrev.HashNext = v
+	for _, key := range keys {
+		v, _, _ := s.lookup(key)
+		if v == nil || !v.IsValid() {
+			continue
 		}
+
+		s.setExpiration(v, expiration)
+		s.Policy.OnUpdate(v)
+		updated++
 	}
 
-	s.Bucket = bucket
+	if updated > 0 {
+		s.Dirty.Store(true)
+	}
+
+	return updated, nil
 }
 
-// cleanup removes expired entries from the store.
-func (s *store) Cleanup() {
-	s.Lock.Lock()
-	defer s.Lock.Unlock()
+// Get retrieves a value from the store by key with locking.
+func (s *store) Get(key []byte) ([]byte, time.Duration, bool) {
+	s.Lock.RLock()
+	defer s.Lock.RUnlock()
 
-	s.EvictLock.Lock()
-	defer s.EvictLock.Unlock()
+	return s.get(key)
+}
 
-	for v := s.EvictList.EvictNext; v != &s.EvictList; {
-		n := v.EvictNext
+// GetStale retrieves a value like Get, but also returns an expired entry that has not
+// been cleaned up yet, reporting it as stale with a negative TTL.
+// Stale entries are not counted as accesses by the eviction policy.
+func (s *store) GetStale(key []byte) ([]byte, time.Duration, bool, bool) {
+	s.Lock.RLock()
+	defer s.Lock.RUnlock()
 
-		if !v.IsValid() {
-			deleteNode(s, v)
-		}
+	v, _, _ := s.lookup(key)
+	if v == nil || v.IsValid() {
+		value, ttl, ok := s.get(key)
 
-		v = n
+		return value, ttl, false, ok
+	}
+
+	value, err := s.value(v)
+	if err != nil {
+		return nil, 0, false, false
 	}
+
+	return value, v.TTL(), true, true
 }
 
-// evict removes entries from the store based on the eviction policy.
-func (s *store) Evict() bool {
-	s.Lock.Lock()
-	defer s.Lock.Unlock()
+// GetMulti retrieves the values and TTLs of many keys under a single read lock.
+// Missing and expired keys are left out of the result, which is in key order.
+func (s *store) GetMulti(keys [][]byte) []Entry[[]byte, []byte] {
+	s.Lock.RLock()
+	defer s.Lock.RUnlock()
 
-	s.EvictLock.Lock()
-	defer s.EvictLock.Unlock()
+	entries := make([]Entry[[]byte, []byte], 0, len(keys))
 
-	if s.MaxCost == 0 {
-		return true
+	for _, key := range keys {
+		if value, ttl, ok := s.get(key); ok {
+			entries = append(entries, Entry[[]byte, []byte]{Key: key, Value: value, TTL: ttl})
+		}
 	}
 
-	for s.MaxCost < s.Cost {
-		n := s.Policy.Evict()
-		if n == nil {
-			break
-		}
+	return entries
+}
 
-		deleteNode(s, n)
+// GetBatch retrieves many keys under a single read lock. The result is aligned with
+// keys, with Found unset for missing and expired keys.
+func (s *store) GetBatch(keys [][]byte) []Result[[]byte] {
+	s.Lock.RLock()
+	defer s.Lock.RUnlock()
+
+	results := make([]Result[[]byte], len(keys))
+
+	for i, key := range keys {
+		results[i].Value, results[i].TTL, results[i].Found = s.get(key)
 	}
 
-	return true
+	return results
 }
 
-// insert adds a new key-value pair to the store.
-func (s *store) insert(key, value []byte, ttl time.Duration) {
-	idx, hash := lookupIdx(s, key)
-	bucket := &s.Bucket[idx]
+// get retrieves a value by key. The caller must hold the store lock.
+func (s *store) get(key []byte) ([]byte, time.Duration, bool) {
+	v, _, _ := s.lookup(key)
+	if v != nil && v.IsValid() {
+		value, err := s.value(v)
+		if err == nil {
+			s.Policy.OnAccess(v)
+			s.access(v)
 
-	if float64(s.Length) > loadFactor*float64(len(s.Bucket)) {
-		s.Resize()
-		// resize may invalidate pointer to bucket
-		idx, _ = lookupIdx(s, key)
-		bucket = &s.Bucket[idx]
-		lazyInitBucket(bucket)
-	}
+			if s.OnGet != nil {
+				s.OnGet(key, true)
+			}
 
-	v := &node{
-		Hash:  hash,
-		Key:   key,
-		Value: value,
-	}
+			if s.Stats != nil {
+				s.Stats.Record(true)
+			}
 
-	if ttl != 0 {
-		v.Expiration = time.Now().Add(ttl)
-	} else {
-		v.Expiration = zero[time.Time]()
+			return value, v.TTL(), true
+		}
 	}
 
-	v.HashPrev = bucket
-	v.HashNext = v.HashPrev.HashNext
-	v.HashNext.HashPrev = v
-	v.HashPrev.HashNext = v
+	if s.OnGet != nil {
+		s.OnGet(key, false)
+	}
 
-	s.Policy.OnInsert(v)
+	if s.Stats != nil {
+		s.Stats.Record(false)
+	}
 
-	s.Cost = s.Cost + v.Cost()
-	s.Length = s.Length + 1
+	return nil, 0, false
 }
 
-// Set adds or updates a key-value pair in the store with locking.
-func (s *store) Set(key, value []byte, ttl time.Duration) {
-	s.Lock.Lock()
-	defer s.Lock.Unlock()
+// TTL returns the time-to-live of a key without reading its value.
+// It does not count as an access for the eviction policy.
+func (s *store) TTL(key []byte) (time.Duration, bool) {
+	s.Lock.RLock()
+	defer s.Lock.RUnlock()
 
 	v, _, _ := s.lookup(key)
-	if v != nil {
-		cost := v.Cost()
+	if v == nil || !v.IsValid() {
+		return 0, false
+	}
 
-		v.Value = value
-		if ttl != 0 {
-			v.Expiration = time.Now().Add(ttl)
-		} else {
-			v.Expiration = zero[time.Time]()
-		}
+	return v.TTL(), true
+}
 
-		s.Cost = s.Cost + v.Cost() - cost
-		s.Policy.OnUpdate(v)
+// Meta returns the metadata of a key without reading its value.
+// It does not count as an access.
+func (s *store) Meta(key []byte) (Meta, bool) {
+	s.Lock.RLock()
+	defer s.Lock.RUnlock()
 
-		return
+	v, _, _ := s.lookup(key)
+	if v == nil || !v.IsValid() {
+		return Meta{}, false
 	}
 
-	s.insert(key, value, ttl)
+	return Meta{
+		LastAccess:  unixNano(atomic.LoadInt64(&v.AccessedAt)),
+		AccessCount: atomic.LoadUint64(&v.Hits),
+		CreatedAt:   unixNano(v.CreatedAt),
+		Expiration:  v.Expiration,
+	}, true
 }
 
-// deleteNode removes a node from the store.
-func deleteNode(s *store, v *node) {
-	v.UnlinkEvict()
-	v.UnlinkHash()
+// unixNano converts Unix nanoseconds to a time, mapping 0 to the zero time.
+func unixNano(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
 
-	s.Cost = s.Cost - v.Cost()
-	s.Length = s.Length - 1
+	return time.Unix(0, ns)
 }
 
-// Delete removes a key-value pair from the store with locking.
-func (s *store) Delete(key []byte) bool {
-	s.Lock.Lock()
-	defer s.Lock.Unlock()
-
-	v, _, _ := s.lookup(key)
-	if v != nil {
-		deleteNode(s, v)
+// resize doubles the size of the hash table and rehashes all entries.
+// It does nothing once the table has reached MaxBuckets.
+func (s *store) Resize() {
+	if size := s.capBuckets(2 * len(s.Bucket)); size > len(s.Bucket) {
+		s.resizeTo(size)
+	}
+}
 
-		return true
+// bucketSize returns the smallest doubling of size that holds length entries within the load factor.
+func bucketSize(length uint64, size int) int {
+	for float64(length) > loadFactor*float64(size) {
+		size = size * 2
 	}
 
-	return false
+	return size
 }
 
-// UpdateInPlace retrieves a value from the store, processes it using the provided function,
-// and then sets the result back into the store with the same key.
-func (s *store) UpdateInPlace(key []byte, processFunc func([]byte) ([]byte, error), ttl time.Duration) error {
-	s.Lock.Lock()
-	defer s.Lock.Unlock()
+// capBuckets limits a hash table size to MaxBuckets, if set. Beyond the cap the
+// table stops growing and the buckets hold longer chains instead. The cap is rounded
+// down to a power of two multiple of initialBucketSize, which resizeTo relies on.
+func (s *store) capBuckets(size int) int {
+	if s.MaxBuckets == 0 {
+		return size
+	}
 
-	v, _, _ := s.lookup(key)
-	if v == nil {
-		return ErrKeyNotFound
+	limit := initialBucketSize
+	for limit*2 <= s.MaxBuckets {
+		limit *= 2
 	}
 
-	if !v.IsValid() {
-		deleteNode(s, v)
-		return ErrKeyNotFound
+	return min(size, int(limit))
+}
+
+// reserve grows the hash table once so that length entries fit within the load factor.
+func (s *store) reserve(length uint64) {
+	if size := s.capBuckets(bucketSize(length, len(s.Bucket))); size > len(s.Bucket) {
+		s.resizeTo(size)
 	}
+}
 
-	value, err := processFunc(v.Value)
-	if err != nil {
-		return err
+// resizeTo rehashes all entries into a hash table of the given size.
+// Table sizes are always a power of two multiple of initialBucketSize, so every
+// old bucket maps onto its own set of new buckets and ranges of old buckets can
+// be rehashed by separate goroutines without sharing any list.
+func (s *store) resizeTo(size int) {
+	bucket := newBuckets(size)
+
+	workers := s.ResizeWorkers
+	if workers <= 1 || len(s.Bucket) < parallelResizeMin {
+		rehash(s.Bucket, bucket)
+		s.Bucket = bucket
+
+		return
 	}
 
-	cost := v.Cost()
+	var wg sync.WaitGroup
 
-	v.Value = value
-	if ttl != 0 {
-		v.Expiration = time.Now().Add(ttl)
-	} else {
-		v.Expiration = zero[time.Time]()
+	chunk := (len(s.Bucket) + workers - 1) / workers
+	for from := 0; from < len(s.Bucket); from += chunk {
+		to := min(from+chunk, len(s.Bucket))
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			rehash(s.Bucket[from:to], bucket)
+		}()
 	}
 
-	s.Cost = s.Cost + v.Cost() - cost
-	s.Policy.OnUpdate(v)
+	wg.Wait()
 
-	return nil
+	s.Bucket = bucket
 }
 
-// Memorize attempts to retrieve a value from the store. If the retrieval fails,
-// it sets the result of the factory function into the store and returns that result.
-func (s *store) Memorize(key []byte, factory func() ([]byte, error), ttl time.Duration) ([]byte, error) {
+// Compact removes expired entries and shrinks the hash table to the smallest size
+// that fits the remaining entries within the load factor.
+func (s *store) Compact() {
 	s.Lock.Lock()
 	defer s.Lock.Unlock()
 
-	v, _, _ := s.lookup(key)
-	if v != nil && v.IsValid() {
-		s.Policy.OnAccess(v)
-		return v.Value, nil
+	s.EvictLock.Lock()
+
+	for v := s.EvictList.EvictNext; v != &s.EvictList; {
+		n := v.EvictNext
+
+		if !v.IsValid() {
+			s.record(v, ReasonExpired)
+			deleteNode(s, v)
+		}
+
+		v = n
 	}
 
-	value, err := factory()
-	if err != nil {
-		return nil, err
+	s.EvictLock.Unlock()
+
+	s.compactArena()
+
+	size := s.capBuckets(bucketSize(s.Length, int(initialBucketSize)))
+	if size == len(s.Bucket) {
+		return
 	}
 
-	s.insert(key, value, ttl)
+	// Shrinking merges old buckets, so this cannot use the parallel resize.
+	bucket := newBuckets(size)
+	rehash(s.Bucket, bucket)
+	s.Bucket = bucket
+}
+
+// rehash moves the entries of the old buckets into the new hash table.
+func rehash(old []node, bucket []node) {
+	for i := range old {
+		sentinel := &old[i]
 
-	return value, nil
+		var order []*node
+		for v := sentinel.HashNext; v != sentinel; v = v.HashNext {
+			order = append(order, v)
+		}
+
+		for _, v := range order {
+			idx := v.Hash % uint64(len(bucket))
+
+			n := &bucket[idx]
+
+			v.HashPrev = n
+			v.HashNext = v.HashPrev.HashNext
+			v.HashNext.HashPrev = v
+			v.HashPrev.HashNext = v
+		}
+	}
+}
+
+// Cleanup removes expired entries from the store and returns how many were removed
+// and the cost they freed.
+func (s *store) Cleanup() (removed int, freedCost uint64) {
+	s.Lock.Lock()
+	defer s.Lock.Unlock()
+
+	s.EvictLock.Lock()
+	defer s.EvictLock.Unlock()
+
+	return s.expire()
+}
+
+// nextExpired returns an entry expire would remove, or nil if there is none. The
+// caller must hold the store lock and the eviction lock.
+func (s *store) nextExpired() *node {
+	if s.Wheel != nil {
+		return s.Wheel.Peek(time.Now())
+	}
+
+	for v := s.EvictList.EvictNext; v != &s.EvictList; v = v.EvictNext {
+		if !v.IsValid() {
+			return v
+		}
+	}
+
+	return nil
+}
+
+// expire removes the expired entries, through the expiry wheel if it is enabled or
+// in a single pass over the eviction list otherwise. The caller must hold the store
+// lock and the eviction lock.
+func (s *store) expire() (removed int, freedCost uint64) {
+	expire := func(v *node) {
+		s.record(v, ReasonExpired)
+
+		removed++
+		freedCost += s.cost(v)
+
+		deleteNode(s, v)
+	}
+
+	if s.Wheel != nil {
+		s.Wheel.Expire(time.Now(), expire)
+
+		return removed, freedCost
+	}
+
+	for v := s.EvictList.EvictNext; v != &s.EvictList; {
+		n := v.EvictNext
+
+		if !v.IsValid() {
+			expire(v)
+		}
+
+		v = n
+	}
+
+	return removed, freedCost
+}
+
+// CleanupBatched removes expired entries like Cleanup, but walks the hash table and
+// releases the lock after every batch buckets, so a long cleanup does not block
+// snapshots and writes for a whole pass. Entries moved by a resize in between may
+// be missed until the next cleanup.
+func (s *store) CleanupBatched(batch int) {
+	s.Lock.RLock()
+	wheel := s.Wheel != nil
+	s.Lock.RUnlock()
+
+	// The wheel only visits due entries, so there is nothing to split up.
+	if wheel {
+		s.Cleanup()
+		return
+	}
+
+	for idx := 0; ; idx += batch {
+		if !s.cleanupBuckets(idx, batch) {
+			return
+		}
+	}
+}
+
+// cleanupBuckets removes the expired entries of n buckets starting at from and
+// reports whether buckets are left after them.
+func (s *store) cleanupBuckets(from, n int) bool {
+	s.Lock.Lock()
+	defer s.Lock.Unlock()
+
+	s.EvictLock.Lock()
+	defer s.EvictLock.Unlock()
+
+	to := min(from+n, len(s.Bucket))
+
+	for idx := from; idx < to; idx++ {
+		bucket := &s.Bucket[idx]
+		for v := bucket.HashNext; v != bucket; {
+			next := v.HashNext
+
+			if !v.IsValid() {
+				s.record(v, ReasonExpired)
+				deleteNode(s, v)
+			}
+
+			v = next
+		}
+	}
+
+	return to < len(s.Bucket)
+}
+
+// Entries returns a copy of all live entries, so they can be iterated without
+// holding the store lock.
+func (s *store) Entries() []Entry[[]byte, []byte] {
+	s.Lock.RLock()
+	defer s.Lock.RUnlock()
+
+	entries := make([]Entry[[]byte, []byte], 0, s.Length)
+
+	for v := s.EvictList.EvictNext; v != &s.EvictList; v = v.EvictNext {
+		if !v.IsValid() {
+			continue
+		}
+
+		value, err := s.value(v)
+		if err != nil {
+			continue
+		}
+
+		entries = append(entries, Entry[[]byte, []byte]{
+			Key:   bytes.Clone(v.Key),
+			Value: bytes.Clone(value),
+			TTL:   v.TTL(),
+		})
+	}
+
+	return entries
+}
+
+// Expired returns the entries that have expired but have not been cleaned up yet.
+// The entries are left in place for a subsequent Cleanup to remove.
+func (s *store) Expired() []KeyValue[[]byte, []byte] {
+	s.Lock.RLock()
+	defer s.Lock.RUnlock()
+
+	s.EvictLock.RLock()
+	defer s.EvictLock.RUnlock()
+
+	var expired []KeyValue[[]byte, []byte]
+
+	for v := s.EvictList.EvictNext; v != &s.EvictList; v = v.EvictNext {
+		if v.IsValid() {
+			continue
+		}
+
+		value, err := s.value(v)
+		if err != nil {
+			continue
+		}
+
+		expired = append(expired, KeyValue[[]byte, []byte]{Key: v.Key, Value: value})
+	}
+
+	return expired
+}
+
+// NextEviction returns the key the eviction policy would remove next without removing it.
+// It follows the same rules as evict, so PolicyLRUTTL returns an expired entry first.
+// PolicyLRUSample draws a new sample on every call, so the next eviction may pick
+// another entry.
+func (s *store) NextEviction() ([]byte, bool) {
+	s.Lock.RLock()
+	defer s.Lock.RUnlock()
+
+	s.EvictLock.RLock()
+	defer s.EvictLock.RUnlock()
+
+	if s.Policy.Type == PolicyLRUTTL {
+		if n := s.nextExpired(); n != nil {
+			return n.Key, true
+		}
+	}
+
+	n := s.Policy.Evict()
+	if n == nil {
+		return nil, false
+	}
+
+	return n.Key, true
+}
+
+// SetMaxCost changes the maximum cost and evicts if the store is over the new limit.
+// A max cost of 0 is rejected with ErrNoMaxCost while a policy is set and the store
+// is not unlimited, since the policy would then never evict.
+func (s *store) SetMaxCost(maxCost uint64) error {
+	s.Lock.Lock()
+	defer s.Lock.Unlock()
+
+	if missingMaxCost(s.Policy.Type, maxCost, s.Unlimited) {
+		return ErrNoMaxCost
+	}
+
+	s.MaxCost = maxCost
+
+	if s.MaxCost < s.Cost {
+		s.evict()
+	}
+
+	return nil
+}
+
+// missingMaxCost reports whether an eviction policy is left without a limit to enforce.
+func missingMaxCost(policy EvictionPolicyType, maxCost uint64, unlimited bool) bool {
+	return policy != PolicyNone && maxCost == 0 && !unlimited
+}
+
+// Scan returns the keys of valid entries in the buckets starting at cursor, visiting
+// buckets until at least count keys are found, and the cursor to continue from.
+// A returned cursor of 0 means the scan is complete. Buckets are visited in reverse
+// binary order, as in Redis SCAN, so a key present for the whole scan is returned
+// even if the hash table grows or shrinks between calls, though it may be returned
+// more than once.
+func (s *store) Scan(cursor uint64, count int) ([][]byte, uint64) {
+	s.Lock.RLock()
+	defer s.Lock.RUnlock()
+
+	size := uint64(len(s.Bucket))
+
+	// The mask covers the table size rounded up to a power of two, which only differs
+	// when MaxBuckets caps the table at another size.
+	mask := uint64(1)<<bits.Len64(size-1) - 1
+
+	var keys [][]byte
+
+	for {
+		if idx := cursor & mask; idx < size {
+			bucket := &s.Bucket[idx]
+			for v := bucket.HashNext; v != bucket; v = v.HashNext {
+				if v.IsValid() {
+					keys = append(keys, v.Key)
+				}
+			}
+		}
+
+		// Increment the bits above the mask reversed, so the cursor visits the
+		// buckets a key can move to on a resize together.
+		cursor |= ^mask
+		cursor = bits.Reverse64(bits.Reverse64(cursor) + 1)
+
+		if cursor == 0 || len(keys) >= count {
+			return keys, cursor
+		}
+	}
+}
+
+// Range calls fn for each valid entry until fn returns false.
+// It stops early and returns the context error if ctx is cancelled.
+func (s *store) Range(ctx context.Context, fn func(key, value []byte, ttl time.Duration) bool) error {
+	s.Lock.RLock()
+	defer s.Lock.RUnlock()
+
+	i := 0
+
+	for v := s.EvictList.EvictNext; v != &s.EvictList; v = v.EvictNext {
+		if i%rangeCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+
+		i++
+
+		if !v.IsValid() {
+			continue
+		}
+
+		value, err := s.value(v)
+		if err != nil {
+			return err
+		}
+
+		if !fn(v.Key, value, v.TTL()) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// ParallelRange calls fn for each valid entry, splitting the hash table between the
+// given number of goroutines. The store is read locked while they run, so fn must not
+// modify it and may be called concurrently. The first error returned by fn stops the
+// iteration and is returned.
+func (s *store) ParallelRange(workers int, fn func(key, value []byte) error) error {
+	s.Lock.RLock()
+	defer s.Lock.RUnlock()
+
+	workers = max(workers, 1)
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+		stop     atomic.Bool
+	)
+
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			stop.Store(true)
+		})
+	}
+
+	chunk := (len(s.Bucket) + workers - 1) / workers
+	for from := 0; from < len(s.Bucket); from += chunk {
+		to := min(from+chunk, len(s.Bucket))
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := from; i < to && !stop.Load(); i++ {
+				sentinel := &s.Bucket[i]
+				for v := sentinel.HashNext; v != sentinel && !stop.Load(); v = v.HashNext {
+					if !v.IsValid() {
+						continue
+					}
+
+					value, err := s.value(v)
+					if err == nil {
+						err = fn(v.Key, value)
+					}
+
+					if err != nil {
+						fail(err)
+					}
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return firstErr
+}
+
+// KeyCost is a key together with the cost of its entry.
+type KeyCost struct {
+	Key  []byte
+	Cost uint64
+}
+
+// costHeap is a min-heap of entries ordered by cost.
+type costHeap []KeyCost
+
+func (h costHeap) Len() int           { return len(h) }
+func (h costHeap) Less(i, j int) bool { return h[i].Cost < h[j].Cost }
+func (h costHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *costHeap) Push(x any) {
+	*h = append(*h, x.(KeyCost))
+}
+
+func (h *costHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+
+	return x
+}
+
+// TopBySize returns the n entries with the largest cost, largest first.
+func (s *store) TopBySize(n int) []KeyCost {
+	s.Lock.RLock()
+	defer s.Lock.RUnlock()
+
+	if n <= 0 {
+		return nil
+	}
+
+	h := make(costHeap, 0, n)
+
+	for v := s.EvictList.EvictNext; v != &s.EvictList; v = v.EvictNext {
+		kc := KeyCost{Key: v.Key, Cost: s.cost(v)}
+
+		if h.Len() < n {
+			heap.Push(&h, kc)
+		} else if h[0].Cost < kc.Cost {
+			h[0] = kc
+			heap.Fix(&h, 0)
+		}
+	}
+
+	slices.SortFunc(h, func(a, b KeyCost) int {
+		return cmp.Compare(b.Cost, a.Cost)
+	})
+
+	return h
+}
+
+// evict removes entries from the store based on the eviction policy.
+func (s *store) Evict() bool {
+	s.Lock.Lock()
+	defer s.Lock.Unlock()
+
+	return s.evict()
+}
+
+// maintain evicts inline after a write when synchronous maintenance is enabled.
+// The caller must hold the store lock.
+func (s *store) maintain() {
+	if s.SyncMaintain || s.overshot() {
+		s.evict()
+	}
+}
+
+// overshot reports whether the cost exceeds MaxCost by more than the allowed overshoot.
+func (s *store) overshot() bool {
+	if s.Overshoot <= 0 || s.MaxCost == 0 {
+		return false
+	}
+
+	return float64(s.Cost) > float64(s.MaxCost)*(1+s.Overshoot)
+}
+
+// evict removes entries based on the eviction policy. The caller must hold the store lock.
+func (s *store) evict() bool {
+	s.EvictLock.Lock()
+	defer s.EvictLock.Unlock()
+
+	s.spill()
+
+	if s.MaxCost == 0 {
+		return true
+	}
+
+	// PolicyLRUTTL drops the expired entries before evicting live ones.
+	if s.Policy.Type == PolicyLRUTTL && s.MaxCost < s.Cost {
+		s.expire()
+	}
+
+	for s.MaxCost < s.Cost {
+		n := s.Policy.Evict()
+		if n == nil {
+			break
+		}
+
+		s.record(n, ReasonEvicted)
+		deleteNode(s, n)
+	}
+
+	return true
+}
+
+// EvictN removes up to n entries in the order of the eviction policy, regardless of
+// MaxCost, and returns how many were removed. PolicyNone never removes anything.
+func (s *store) EvictN(n int) int {
+	s.Lock.Lock()
+	defer s.Lock.Unlock()
+
+	s.EvictLock.Lock()
+	defer s.EvictLock.Unlock()
+
+	evicted := 0
+
+	for evicted < n {
+		v := s.Policy.Evict()
+		if v == nil {
+			break
+		}
+
+		s.record(v, ReasonEvicted)
+		deleteNode(s, v)
+
+		evicted++
+	}
+
+	return evicted
+}
+
+// access records a read of a node if access tracking is enabled. Reads hold only
+// the read lock, so the fields are updated atomically.
+func (s *store) access(v *node) {
+	if s.TrackAccess {
+		atomic.StoreInt64(&v.AccessedAt, s.Now().UnixNano())
+		atomic.AddUint64(&v.Hits, 1)
+	}
+}
+
+// stamp records the time a node was written if age tracking is enabled.
+func (s *store) stamp(v *node) {
+	if s.TrackAge {
+		v.Written = s.Now()
+	}
+}
+
+// bump assigns the next generation to a written node. Generations come from a single
+// counter for the whole store, so a key that is deleted and written again never
+// reuses a generation a reader may have seen before.
+func (s *store) bump(v *node) {
+	s.Generation++
+	v.Generation = s.Generation
+}
+
+// AgeStats returns the ages of the oldest and newest live entries, measured from
+// when each entry was last written. Entries loaded from a snapshot count as
+// written when they were loaded. Both ages are 0 if the store has no tracked entries.
+func (s *store) AgeStats() (oldest, newest time.Duration) {
+	s.Lock.RLock()
+	defer s.Lock.RUnlock()
+
+	now := s.Now()
+	found := false
+
+	for i := range s.Bucket {
+		bucket := &s.Bucket[i]
+		for v := bucket.HashNext; v != bucket; v = v.HashNext {
+			if !v.IsValid() || v.Written.IsZero() {
+				continue
+			}
+
+			age := now.Sub(v.Written)
+
+			if !found {
+				oldest, newest, found = age, age, true
+
+				continue
+			}
+
+			oldest = max(oldest, age)
+			newest = min(newest, age)
+		}
+	}
+
+	return oldest, newest
+}
+
+// TTLHistogram counts the live entries by remaining time to live. Count i is the
+// number of entries expiring within buckets[i] but after buckets[i-1], and the extra
+// last count is the number of entries without expiration. The buckets must be in
+// ascending order, and entries expiring after the last bucket are not counted.
+func (s *store) TTLHistogram(buckets []time.Duration) []uint64 {
+	s.Lock.RLock()
+	defer s.Lock.RUnlock()
+
+	counts := make([]uint64, len(buckets)+1)
+
+	for i := range s.Bucket {
+		bucket := &s.Bucket[i]
+		for v := bucket.HashNext; v != bucket; v = v.HashNext {
+			if !v.IsValid() {
+				continue
+			}
+
+			if v.Expiration.IsZero() {
+				counts[len(buckets)]++
+
+				continue
+			}
+
+			// The first bucket the remaining time fits in.
+			idx, _ := slices.BinarySearch(buckets, v.TTL())
+			if idx < len(buckets) {
+				counts[idx]++
+			}
+		}
+	}
+
+	return counts
+}
+
+// subCost subtracts cost from the total cost of the store. A cost larger than the
+// total means the accounting is inconsistent; the total is clamped at zero rather
+// than wrapping around, which would make the store look permanently full.
+// Builds with the cachedebug tag panic instead.
+func (s *store) subCost(cost uint64) {
+	if cost > s.Cost {
+		if debug {
+			panic(fmt.Sprintf("cache: cost underflow: subtracting %d from %d", cost, s.Cost))
+		}
+
+		s.Cost = 0
+
+		return
+	}
+
+	s.Cost = s.Cost - cost
+}
+
+// setExpiration sets the expiration of a node and keeps the expiry wheel in sync.
+func (s *store) setExpiration(v *node, t time.Time) {
+	if s.Wheel != nil {
+		s.Wheel.Remove(v)
+	}
+
+	v.Expiration = t
+
+	if s.Wheel != nil {
+		s.Wheel.Add(v)
+	}
+}
+
+// enableWheel indexes all entries in a new expiry wheel. The caller must hold the store lock.
+func (s *store) enableWheel() {
+	s.Wheel = newExpiryWheel()
+
+	for v := s.EvictList.EvictNext; v != &s.EvictList; v = v.EvictNext {
+		s.Wheel.Add(v)
+	}
+}
+
+// expiration computes the expiration time for a ttl, capped by MaxAge if set.
+func (s *store) expiration(ttl time.Duration) time.Time {
+	switch ttl {
+	case NoExpire:
+		ttl = 0
+	case DefaultTTL, 0:
+		ttl = s.DefaultTTL
+	}
+
+	if s.MaxAge != 0 && (ttl == 0 || ttl > s.MaxAge) {
+		ttl = s.MaxAge
+	}
+
+	if ttl == 0 {
+		return zero[time.Time]()
+	}
+
+	return time.Now().Add(ttl)
+}
+
+// value returns the value of a node, loading it from the blob store if it was spilled
+// or from the arena.
+func (s *store) value(v *node) ([]byte, error) {
+	switch {
+	case v.Blob:
+		return s.Blobs.Load(v.Value)
+	case v.InArena:
+		return s.Arena.Bytes(v.Slot), nil
+	}
+
+	return v.Value, nil
+}
+
+// setValue stores a value in a node, spilling it to the blob store if it is too large
+// and copying it into the arena if there is one.
+func (s *store) setValue(v *node, value []byte) error {
+	old := *v
+
+	v.InArena = false
+	v.Slot = arenaSlot{}
+
+	switch {
+	case s.Blobs != nil && s.Blobs.ShouldSpill(value):
+		ref, err := s.Blobs.Store(value)
+		if err != nil {
+			*v = old
+			return err
+		}
+
+		v.Value = ref
+		v.Blob = true
+	case s.Arena != nil && s.Arena.Fits(value):
+		v.Value = nil
+		v.Blob = false
+		v.InArena = true
+		v.Slot = s.Arena.Alloc(value)
+	default:
+		v.Value = value
+		v.Blob = false
+	}
+
+	s.dropValue(&old)
+
+	return nil
+}
+
+// putValue is setValue for a value that is either the bytes themselves or, if blob is
+// set, a reference to a file already written to the blob store.
+func (s *store) putValue(v *node, value []byte, blob bool) error {
+	if !blob {
+		return s.setValue(v, value)
+	}
+
+	old := *v
+
+	v.Value = value
+	v.Blob = true
+	v.InArena = false
+	v.Slot = arenaSlot{}
+
+	s.dropValue(&old)
+
+	return nil
+}
+
+// compactArena moves the values in the arena into new slabs, releasing the space
+// of values that were removed from partly used slabs. The caller must hold the store lock.
+func (s *store) compactArena() {
+	if s.Arena == nil {
+		return
+	}
+
+	next := newArena(s.Arena.SlabSize)
+
+	for v := s.EvictList.EvictNext; v != &s.EvictList; v = v.EvictNext {
+		if v.InArena {
+			v.Slot = next.Alloc(s.Arena.Bytes(v.Slot))
+		}
+	}
+
+	s.Arena = next
+}
+
+// overflowMinSize is the size up to which values are kept in memory by spill, since
+// moving them to a file would save little more than the size of the reference.
+const overflowMinSize = 64
+
+// spill moves values to the blob store, starting from the eviction end of the list,
+// until the cost is within OverflowCost. The entries stay in the store, so their
+// values are loaded back from disk on access. The caller must hold the store lock
+// and EvictLock.
+func (s *store) spill() {
+	if s.OverflowCost == 0 || s.Blobs == nil {
+		return
+	}
+
+	for v := s.EvictList.EvictPrev; v != &s.EvictList && s.Cost > s.OverflowCost; v = v.EvictPrev {
+		if v.Blob {
+			continue
+		}
+
+		value, err := s.value(v)
+		if err != nil || len(value) <= overflowMinSize {
+			continue
+		}
+
+		ref, err := s.Blobs.Store(value)
+		if err != nil {
+			// The remaining entries are left to the eviction policy.
+			return
+		}
+
+		s.subCost(s.cost(v))
+
+		old := *v
+
+		v.Value = ref
+		v.Blob = true
+		v.InArena = false
+		v.Slot = arenaSlot{}
+
+		s.dropValue(&old)
+
+		s.Cost = s.Cost + s.cost(v)
+	}
+}
+
+// dropBlob removes the blob file backing a node, if any.
+// Removal is best effort since the entry is already gone from the store.
+func (s *store) dropBlob(v *node) {
+	if v.Blob && s.Blobs != nil {
+		_ = s.Blobs.Remove(v.Value)
+	}
+}
+
+// dropValue releases the blob file or arena space backing a node, if any.
+func (s *store) dropValue(v *node) {
+	s.dropBlob(v)
+
+	if v.InArena {
+		s.Arena.Release(v.Slot)
+	}
+}
+
+// insert adds a new key-value pair to the store.
+func (s *store) insert(key, value []byte, ttl time.Duration) error {
+	return s.insertValue(key, value, false, ttl)
+}
+
+// insertValue is insert for a value that is either the bytes themselves or, if blob
+// is set, a reference to a file already written to the blob store.
+func (s *store) insertValue(key, value []byte, blob bool, ttl time.Duration) error {
+	s.reserve(s.Length + 1)
+
+	idx, hash := lookupIdx(s, key)
+	bucket := &s.Bucket[idx]
+
+	v := &node{
+		Hash: hash,
+		Key:  key,
+	}
+
+	if err := s.putValue(v, value, blob); err != nil {
+		return err
+	}
+
+	s.setExpiration(v, s.expiration(ttl))
+	s.stamp(v)
+	s.bump(v)
+
+	if s.TrackAccess {
+		v.CreatedAt = s.Now().UnixNano()
+	}
+
+	v.HashPrev = bucket
+	v.HashNext = v.HashPrev.HashNext
+	v.HashNext.HashPrev = v
+	v.HashPrev.HashNext = v
+
+	s.Policy.OnInsert(v)
+
+	s.Cost = s.Cost + s.cost(v)
+	s.Length = s.Length + 1
+	s.Dirty.Store(true)
+
+	return nil
+}
+
+// Set adds or updates a key-value pair in the store with locking.
+func (s *store) Set(key, value []byte, ttl time.Duration) error {
+	s.Lock.Lock()
+	defer s.Lock.Unlock()
+
+	if err := s.set(key, value, ttl); err != nil {
+		return err
+	}
+
+	s.maintain()
+
+	return nil
+}
+
+// SetEntries adds or updates many key-value pairs under a single lock,
+// growing the hash table and evicting at most once.
+func (s *store) SetEntries(entries []Entry[[]byte, []byte]) error {
+	s.Lock.Lock()
+	defer s.Lock.Unlock()
+
+	s.reserve(s.Length + uint64(len(entries)))
+
+	for _, e := range entries {
+		if err := s.set(e.Key, e.Value, e.TTL); err != nil {
+			return err
+		}
+	}
+
+	s.evict()
+
+	return nil
+}
+
+// Rehome moves every entry to the store owner returns for its key, keeping its
+// expiration, version, tags and access metadata. Entries owned by s stay in place.
+func (s *store) Rehome(owner func(key []byte) *store) error {
+	s.Lock.Lock()
+	defer s.Lock.Unlock()
+
+	for v := s.EvictList.EvictNext; v != &s.EvictList; {
+		next := v.EvictNext
+
+		dst := owner(v.Key)
+		if dst == s {
+			v = next
+			continue
+		}
+
+		value, err := s.value(v)
+		if err != nil {
+			return err
+		}
+
+		if v.InArena {
+			value = bytes.Clone(value)
+		}
+
+		moved := &node{
+			Key:        v.Key,
+			Value:      value,
+			Expiration: v.Expiration,
+			Access:     v.Access,
+			Protected:  v.Protected,
+			LastAccess: v.LastAccess,
+			Version:    v.Version,
+			Generation: v.Generation,
+			Tags:       v.Tags,
+			CreatedAt:  v.CreatedAt,
+			AccessedAt: v.AccessedAt,
+			Hits:       v.Hits,
+		}
+
+		dst.Lock.Lock()
+		dst.reserve(dst.Length + 1)
+		_, err = dst.restore(moved)
+		dst.Dirty.Store(true)
+		dst.Lock.Unlock()
+
+		if err != nil {
+			return err
+		}
+
+		deleteNode(s, v)
+
+		v = next
+	}
+
+	return nil
+}
+
+// ReplaceAll atomically replaces all entries of the store with entries. The new
+// contents are built in a separate store with the same configuration and swapped in
+// under the lock, so readers see either the old or the new entries, never a mix.
+func (s *store) ReplaceAll(entries []Entry[[]byte, []byte]) error {
+	next := &store{}
+	next.Init()
+
+	s.Lock.RLock()
+
+	next.MaxCost = s.MaxCost
+	next.EntryOverhead = s.EntryOverhead
+	next.MaxAge = s.MaxAge
+	next.DefaultTTL = s.DefaultTTL
+	next.MinTTL = s.MinTTL
+	next.MaxTTL = s.MaxTTL
+	next.TrackAge = s.TrackAge
+	next.TrackAccess = s.TrackAccess
+	next.Now = s.Now
+	next.Hasher = s.Hasher
+	next.Blobs = s.Blobs
+
+	if s.Arena != nil {
+		next.Arena = newArena(s.Arena.SlabSize)
+	}
+	next.Policy.SLRURatio = s.Policy.SLRURatio
+	next.Policy.SampleSize = s.Policy.SampleSize
+	next.Policy.Clock = atomic.LoadUint64(&s.Policy.Clock)
+	next.Policy.Name = s.Policy.Name
+
+	if s.Wheel != nil {
+		next.Wheel = newExpiryWheel()
+	}
+
+	// The new entries continue the generation counter, so none reuses a generation
+	// handed out before the replace.
+	seed := s.Generation
+	next.Generation = seed
+
+	err := next.Policy.SetPolicy(s.Policy.Type)
+
+	s.Lock.RUnlock()
+
+	if err != nil {
+		return err
+	}
+
+	if err := next.SetEntries(entries); err != nil {
+		return err
+	}
+
+	s.Lock.Lock()
+	defer s.Lock.Unlock()
+
+	s.clear()
+
+	s.Bucket = next.Bucket
+	s.Length = next.Length
+	s.Cost = next.Cost
+	s.Wheel = next.Wheel
+	s.Arena = next.Arena
+
+	if s.Generation == seed {
+		s.Generation = next.Generation
+	} else {
+		// Writes made while the entries were built used the same generations, so
+		// the new entries are renumbered after them.
+		for v := next.EvictList.EvictNext; v != &next.EvictList; v = v.EvictNext {
+			s.bump(v)
+		}
+	}
+
+	if next.Length > 0 {
+		s.EvictList.EvictNext = next.EvictList.EvictNext
+		s.EvictList.EvictPrev = next.EvictList.EvictPrev
+		s.EvictList.EvictNext.EvictPrev = &s.EvictList
+		s.EvictList.EvictPrev.EvictNext = &s.EvictList
+
+		s.Policy.Segment = next.Policy.Segment
+		if s.Policy.Segment.Last == &next.EvictList {
+			s.Policy.Segment.Last = &s.EvictList
+		}
+
+		s.Policy.renew()
+	}
+
+	return nil
+}
+
+var ErrCacheFull = errors.New("cache is full") // ErrCacheFull is returned when a write would exceed the max cost and nothing can be evicted.
+
+var ErrTTLOutOfRange = errors.New("ttl out of range") // ErrTTLOutOfRange is wrapped by TTLRangeError.
+
+// TTLRangeError reports a TTL outside the bounds set by WithTTLBounds.
+type TTLRangeError struct {
+	TTL time.Duration
+	Min time.Duration
+	Max time.Duration
+}
+
+func (e *TTLRangeError) Error() string {
+	return fmt.Sprintf("ttl %v outside of [%v, %v]", e.TTL, e.Min, e.Max)
+}
+
+func (e *TTLRangeError) Unwrap() error {
+	return ErrTTLOutOfRange
+}
+
+// checkTTL returns a TTLRangeError if bounds are configured and ttl is outside them.
+// The 0, NoExpire and DefaultTTL sentinels are always accepted.
+func (s *store) checkTTL(ttl time.Duration) error {
+	if s.MinTTL == 0 && s.MaxTTL == 0 {
+		return nil
+	}
+
+	switch ttl {
+	case 0, NoExpire, DefaultTTL:
+		return nil
+	}
+
+	if ttl < s.MinTTL || (s.MaxTTL != 0 && ttl > s.MaxTTL) {
+		return &TTLRangeError{TTL: ttl, Min: s.MinTTL, Max: s.MaxTTL}
+	}
+
+	return nil
+}
+
+// WouldFit reports whether storing values under keys would keep the cost within
+// MaxCost, costing entries like a Set and crediting the cost of the entries they
+// replace. A key repeated in the batch is only counted for its last value. It always
+// reports true without a MaxCost, and false if keys and values differ in length.
+func (s *store) WouldFit(keys, values [][]byte) bool {
+	if len(keys) != len(values) {
+		return false
+	}
+
+	s.Lock.RLock()
+	defer s.Lock.RUnlock()
+
+	if s.MaxCost == 0 {
+		return true
+	}
+
+	last := make(map[string]int, len(keys))
+	for i, key := range keys {
+		last[string(key)] = i
+	}
+
+	cost := s.Cost
+
+	for i, key := range keys {
+		if last[string(key)] != i {
+			continue
+		}
+
+		cost += uint64(len(key)+len(values[i])) + s.EntryOverhead
+
+		if v, _, _ := s.lookup(key); v != nil {
+			cost -= s.cost(v)
+		}
+	}
+
+	return cost <= s.MaxCost
+}
+
+// checkFull returns ErrCacheFull if RejectOnFull is set and storing value under key,
+// replacing the node v if any, would exceed MaxCost without a policy to evict.
+func (s *store) checkFull(v *node, key, value []byte) error {
+	if !s.RejectOnFull || s.MaxCost == 0 || s.Policy.Type != PolicyNone {
+		return nil
+	}
+
+	cost := s.Cost + uint64(len(key)+len(value)) + s.EntryOverhead
+	if v != nil {
+		cost = cost - s.cost(v)
+	}
+
+	if cost > s.MaxCost {
+		return ErrCacheFull
+	}
+
+	return nil
+}
+
+// set adds or updates a key-value pair in the store.
+func (s *store) set(key, value []byte, ttl time.Duration) error {
+	return s.put(key, value, false, ttl)
+}
+
+// put is set for a value that is either the bytes themselves or, if blob is set, a
+// reference to a file already written to the blob store.
+func (s *store) put(key, value []byte, blob bool, ttl time.Duration) error {
+	if err := s.checkTTL(ttl); err != nil {
+		return err
+	}
+
+	v, _, _ := s.lookup(key)
+
+	if err := s.checkFull(v, key, value); err != nil {
+		return err
+	}
+
+	if s.OnSet != nil {
+		hooked := value
+
+		if blob {
+			var err error
+			if hooked, err = s.Blobs.Load(value); err != nil {
+				return err
+			}
+		}
+
+		s.OnSet(key, hooked, ttl)
+	}
+
+	if v != nil {
+		cost := s.cost(v)
+
+		if err := s.putValue(v, value, blob); err != nil {
+			return err
+		}
+
+		s.setExpiration(v, s.expiration(ttl))
+		s.stamp(v)
+		s.bump(v)
+		s.untag(v)
+		v.Version = 0
+
+		s.Cost = s.Cost + s.cost(v)
+		s.subCost(cost)
+		s.Policy.OnUpdate(v)
+		s.Dirty.Store(true)
+
+		return nil
+	}
+
+	return s.insertValue(key, value, blob, ttl)
+}
+
+// SetBlob stores the file that ref points to in blobs as the value of key, like Set,
+// without reading it into memory. The file belongs to the store afterwards and is
+// removed if it cannot be stored. If the store has since moved to another blob store,
+// the value is read back and stored like any other.
+func (s *store) SetBlob(blobs *blobStore, key, ref []byte, ttl time.Duration) error {
+	s.Lock.Lock()
+	defer s.Lock.Unlock()
+
+	if s.Blobs != blobs {
+		value, err := blobs.Load(ref)
+		_ = blobs.Remove(ref)
+
+		if err != nil {
+			return err
+		}
+
+		if err := s.set(key, value, ttl); err != nil {
+			return err
+		}
+
+		s.maintain()
+
+		return nil
+	}
+
+	if err := s.put(key, ref, true, ttl); err != nil {
+		_ = blobs.Remove(ref)
+
+		return err
+	}
+
+	s.maintain()
+
+	return nil
+}
+
+// GetWithVersion retrieves a value like Get together with the generation of the entry.
+func (s *store) GetWithVersion(key []byte) ([]byte, time.Duration, uint64, bool) {
+	s.Lock.RLock()
+	defer s.Lock.RUnlock()
+
+	value, ttl, ok := s.get(key)
+	if !ok {
+		return nil, 0, 0, false
+	}
+
+	v, _, _ := s.lookup(key)
+
+	return value, ttl, v.Generation, true
+}
+
+// SetIfVersion stores a key-value pair only if the generation of the entry still is
+// expected, and reports whether it was stored. Missing and expired keys have
+// generation 0, so an expected generation of 0 only stores new keys.
+func (s *store) SetIfVersion(key, value []byte, expected uint64, ttl time.Duration) (bool, error) {
+	s.Lock.Lock()
+	defer s.Lock.Unlock()
+
+	var current uint64
+	if v, _, _ := s.lookup(key); v != nil && v.IsValid() {
+		current = v.Generation
+	}
+
+	if current != expected {
+		return false, nil
+	}
+
+	if err := s.set(key, value, ttl); err != nil {
+		return false, err
+	}
+
+	s.maintain()
+
+	return true, nil
+}
+
+// SetIfNewer stores a key-value pair only if version is greater than the version of
+// the existing entry, and reports whether it was stored. Equal versions are rejected.
+// Expired entries are always replaced, and entries written by Set have version 0.
+func (s *store) SetIfNewer(key, value []byte, version uint64, ttl time.Duration) (bool, error) {
+	s.Lock.Lock()
+	defer s.Lock.Unlock()
+
+	if v, _, _ := s.lookup(key); v != nil && v.IsValid() && v.Version >= version {
+		return false, nil
+	}
+
+	if err := s.set(key, value, ttl); err != nil {
+		return false, err
+	}
+
+	v, _, _ := s.lookup(key)
+	v.Version = version
+
+	s.maintain()
+
+	return true, nil
+}
+
+// record adds the removal of a node to the eviction history, if enabled,
+// and passes it to the OnEvict hook.
+func (s *store) record(v *node, reason EvictionReason) {
+	if s.History != nil {
+		s.History.Add(v.Key, reason)
+	}
+
+	if s.OnEvict != nil {
+		value, _ := s.value(v)
+		s.OnEvict(v.Key, value, reason)
+	}
+}
+
+// EvictionHistory returns the recorded evictions from oldest to newest.
+func (s *store) EvictionHistory() []EvictionRecord {
+	s.Lock.RLock()
+	defer s.Lock.RUnlock()
+
+	if s.History == nil {
+		return nil
+	}
+
+	return s.History.List()
+}
+
+// deleteNode removes a node from the store.
+func deleteNode(s *store, v *node) {
+	s.Policy.unlink(v)
+	v.UnlinkEvict()
+	v.UnlinkHash()
+
+	if s.Wheel != nil {
+		s.Wheel.Remove(v)
+	}
+
+	s.dropValue(v)
+	s.untag(v)
+
+	s.subCost(s.cost(v))
+	s.Length = s.Length - 1
+	s.Dirty.Store(true)
+}
+
+// Delete removes a key-value pair from the store with locking.
+func (s *store) Delete(key []byte) bool {
+	s.Lock.Lock()
+	defer s.Lock.Unlock()
+
+	v, _, _ := s.lookup(key)
+	if v != nil {
+		deleteNode(s, v)
+
+		return true
+	}
+
+	return false
+}
+
+// updateStripes is the number of locks UpdateInPlace spreads keys over.
+const updateStripes = 64
+
+// stripeSeed seeds the hash picking the stripe of a key. It is independent of the
+// store hasher, so replacing the hasher never moves a key to another stripe.
+var stripeSeed = maphash.MakeSeed()
+
+// stripe returns the lock serializing UpdateInPlace calls on key.
+func (s *store) stripe(key []byte) *sync.Mutex {
+	return &s.Stripes[maphash.Bytes(stripeSeed, key)%updateStripes]
+}
+
+// UpdateInPlace retrieves a value from the store, processes it using the provided function,
+// and then sets the result back into the store with the same key.
+//
+// processFunc runs without the store lock, so a slow function only holds up other
+// UpdateInPlace calls on keys of the same stripe. Other writes to the key are not
+// held up, and if one lands while processFunc runs, the result is discarded and
+// processFunc is called again with the new value. processFunc may therefore run more
+// than once and must not have side effects beyond computing the new value.
+func (s *store) UpdateInPlace(key []byte, processFunc func([]byte) ([]byte, error), ttl time.Duration) error {
+	stripe := s.stripe(key)
+	stripe.Lock()
+	defer stripe.Unlock()
+
+	for {
+		current, generation, err := s.readForUpdate(key, ttl)
+		if err != nil {
+			return err
+		}
+
+		value, err := processFunc(current)
+		if err != nil {
+			return err
+		}
+
+		done, err := s.commitUpdate(key, value, generation, ttl)
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+// readForUpdate returns a copy of the value of key and its generation for UpdateInPlace.
+func (s *store) readForUpdate(key []byte, ttl time.Duration) ([]byte, uint64, error) {
+	s.Lock.RLock()
+	defer s.Lock.RUnlock()
+
+	if err := s.checkTTL(ttl); err != nil {
+		return nil, 0, err
+	}
+
+	v, _, _ := s.lookup(key)
+	if v == nil || !v.IsValid() {
+		return nil, 0, ErrKeyNotFound
+	}
+
+	current, err := s.value(v)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// processFunc runs without the lock, so it must not share the stored slice.
+	return bytes.Clone(current), v.Generation, nil
+}
+
+// commitUpdate stores the result of UpdateInPlace if key still has the generation it
+// was read with, and reports whether it did.
+func (s *store) commitUpdate(key, value []byte, generation uint64, ttl time.Duration) (bool, error) {
+	s.Lock.Lock()
+	defer s.Lock.Unlock()
+
+	v, _, _ := s.lookup(key)
+	if v == nil {
+		return false, ErrKeyNotFound
+	}
+
+	if !v.IsValid() {
+		deleteNode(s, v)
+		return false, ErrKeyNotFound
+	}
+
+	if v.Generation != generation {
+		return false, nil
+	}
+
+	if err := s.checkFull(v, key, value); err != nil {
+		return false, err
+	}
+
+	cost := s.cost(v)
+
+	if err := s.setValue(v, value); err != nil {
+		return false, err
+	}
+
+	s.setExpiration(v, s.expiration(ttl))
+	s.stamp(v)
+	s.bump(v)
+
+	s.Cost = s.Cost + s.cost(v)
+	s.subCost(cost)
+	s.Policy.OnUpdate(v)
+	s.Dirty.Store(true)
+
+	s.maintain()
+
+	return true, nil
+}
+
+var ErrNotCounter = errors.New("value is not an integer") // ErrNotCounter is returned when incrementing a value that is not an integer.
+
+// IncrementWithTTL adds delta to the msgpack encoded integer stored at key and returns
+// the result. A missing or expired key starts at 0 and is stored with ttl. An existing
+// key keeps its expiration, so the first increment fixes the window, unless
+// IncrementRefresh is set, in which case every increment resets the TTL.
+func (s *store) IncrementWithTTL(key []byte, delta int64, ttl time.Duration) (int64, error) {
+	s.Lock.Lock()
+	defer s.Lock.Unlock()
+
+	if err := s.checkTTL(ttl); err != nil {
+		return 0, err
+	}
+
+	v, _, _ := s.lookup(key)
+	if v == nil || !v.IsValid() {
+		value, err := msgpack.Marshal(delta)
+		if err != nil {
+			return 0, err
+		}
+
+		if err := s.set(key, value, ttl); err != nil {
+			return 0, err
+		}
+
+		s.maintain()
+
+		return delta, nil
+	}
+
+	current, err := s.value(v)
+	if err != nil {
+		return 0, err
+	}
+
+	// Trailing bytes mean the value only starts with an integer, such as a raw string.
+	r := bytes.NewReader(current)
+
+	counter, err := msgpack.NewDecoder(r).DecodeInt64()
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrNotCounter, err)
+	}
+
+	if r.Len() != 0 {
+		return 0, ErrNotCounter
+	}
+
+	counter += delta
+
+	value, err := msgpack.Marshal(counter)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := s.checkFull(v, key, value); err != nil {
+		return 0, err
+	}
+
+	cost := s.cost(v)
+
+	if err := s.setValue(v, value); err != nil {
+		return 0, err
+	}
+
+	if s.IncrementRefresh {
+		s.setExpiration(v, s.expiration(ttl))
+	}
+
+	s.stamp(v)
+	s.bump(v)
+
+	s.Cost = s.Cost + s.cost(v)
+	s.subCost(cost)
+	s.Policy.OnUpdate(v)
+	s.Dirty.Store(true)
+
+	s.maintain()
+
+	return counter, nil
+}
+
+// Memorize attempts to retrieve a value from the store. If the retrieval fails,
+// it sets the result of the factory function into the store and returns that result.
+// The factory runs without holding the lock, so a slow factory does not block other
+// operations. If another caller stores the key in the meantime, its value is kept
+// and returned instead.
+func (s *store) Memorize(key []byte, factory func() ([]byte, error), ttl time.Duration) ([]byte, error) {
+	if value, ok, err := s.memorized(key, ttl); ok || err != nil {
+		return value, err
+	}
+
+	value, err := factory()
+	if err != nil {
+		return nil, err
+	}
+
+	s.Lock.Lock()
+	defer s.Lock.Unlock()
+
+	if err := s.checkTTL(ttl); err != nil {
+		return nil, err
+	}
+
+	v, _, _ := s.lookup(key)
+	if v != nil && v.IsValid() {
+		s.Policy.OnAccess(v)
+		return s.value(v)
+	}
+
+	// An expired entry is removed, so the new one does not share its key.
+	if v != nil {
+		s.record(v, ReasonExpired)
+		deleteNode(s, v)
+	}
+
+	if err := s.checkFull(nil, key, value); err != nil {
+		return nil, err
+	}
+
+	if err := s.insert(key, value, ttl); err != nil {
+		return nil, err
+	}
+
+	s.maintain()
+
+	return value, nil
+}
+
+// memorized returns the valid value of key for Memorize, reporting whether there is one.
+func (s *store) memorized(key []byte, ttl time.Duration) ([]byte, bool, error) {
+	s.Lock.RLock()
+	defer s.Lock.RUnlock()
+
+	if err := s.checkTTL(ttl); err != nil {
+		return nil, false, err
+	}
+
+	v, _, _ := s.lookup(key)
+	if v == nil || !v.IsValid() {
+		return nil, false, nil
+	}
+
+	s.Policy.OnAccess(v)
+
+	value, err := s.value(v)
+
+	return value, true, err
 }
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"maps"
+	"slices"
 	"strconv"
 	"testing"
 	"time"
@@ -232,6 +234,27 @@ func TestStoreGetSet(t *testing.T) {
 		}
 	})
 
+	t.Run("Parallel Resize", func(t *testing.T) {
+		t.Parallel()
+
+		store := setupTestStore(t)
+		store.ResizeWorkers = 4
+
+		n := uint64(10 * parallelResizeMin)
+
+		for i := range n {
+			key := binary.LittleEndian.AppendUint64(nil, i)
+			store.Set(key, key, 0)
+		}
+
+		for i := range n {
+			key := binary.LittleEndian.AppendUint64(nil, i)
+			if _, _, ok := store.Get(key); !ok {
+				t.Fatalf("expected key %d to exist", i)
+			}
+		}
+	})
+
 	t.Run("Resize", func(t *testing.T) {
 		t.Parallel()
 
@@ -264,6 +287,237 @@ func TestStoreGetSet(t *testing.T) {
 	})
 }
 
+func TestStoreMaxAge(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		ttl  time.Duration
+		want time.Duration
+	}{
+		{
+			name: "Long TTL clamped",
+			ttl:  1 * time.Hour,
+			want: 1 * time.Minute,
+		},
+		{
+			name: "Short TTL unaffected",
+			ttl:  10 * time.Second,
+			want: 10 * time.Second,
+		},
+		{
+			name: "Immortal clamped",
+			ttl:  0,
+			want: 1 * time.Minute,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			store := setupTestStore(t)
+			store.MaxAge = 1 * time.Minute
+
+			store.Set([]byte("Key"), []byte("Value"), tt.ttl)
+
+			_, ttl, ok := store.Get([]byte("Key"))
+			if !ok {
+				t.Fatalf("expected key to exist")
+			}
+
+			if got := ttl.Round(time.Second); got != tt.want {
+				t.Errorf("expected ttl %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestStoreSetIfNewer(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		version uint64
+		applied bool
+		want    string
+	}{
+		{
+			name:    "Newer",
+			version: 6,
+			applied: true,
+			want:    "New",
+		},
+		{
+			name:    "Older",
+			version: 4,
+			applied: false,
+			want:    "Old",
+		},
+		{
+			name:    "Equal",
+			version: 5,
+			applied: false,
+			want:    "Old",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			store := setupTestStore(t)
+
+			if ok, err := store.SetIfNewer([]byte("Key"), []byte("Old"), 5, 0); err != nil || !ok {
+				t.Fatalf("expected first write to apply, got %v %v", ok, err)
+			}
+
+			ok, err := store.SetIfNewer([]byte("Key"), []byte("New"), tt.version, 0)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if ok != tt.applied {
+				t.Fatalf("expected applied %v, got %v", tt.applied, ok)
+			}
+
+			got, _, _ := store.Get([]byte("Key"))
+			if string(got) != tt.want {
+				t.Fatalf("expected %v, got %v", tt.want, string(got))
+			}
+		})
+	}
+
+	t.Run("Expired", func(t *testing.T) {
+		t.Parallel()
+
+		store := setupTestStore(t)
+
+		store.SetIfNewer([]byte("Key"), []byte("Old"), 5, time.Nanosecond)
+		time.Sleep(time.Millisecond)
+
+		if ok, _ := store.SetIfNewer([]byte("Key"), []byte("New"), 1, 0); !ok {
+			t.Fatalf("expected expired entry to be replaced")
+		}
+	})
+
+	t.Run("Set Resets Version", func(t *testing.T) {
+		t.Parallel()
+
+		store := setupTestStore(t)
+
+		store.SetIfNewer([]byte("Key"), []byte("Old"), 5, 0)
+		store.Set([]byte("Key"), []byte("Plain"), 0)
+
+		if ok, _ := store.SetIfNewer([]byte("Key"), []byte("New"), 1, 0); !ok {
+			t.Fatalf("expected write after Set to apply")
+		}
+	})
+}
+
+func TestStoreTTLBounds(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		ttl     time.Duration
+		wantErr error
+	}{
+		{name: "TooSmall", ttl: 10 * time.Nanosecond, wantErr: ErrTTLOutOfRange},
+		{name: "TooLarge", ttl: 1000 * time.Hour, wantErr: ErrTTLOutOfRange},
+		{name: "InRange", ttl: time.Minute},
+		{name: "Immortal", ttl: 0},
+		{name: "NoExpire", ttl: NoExpire},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			store := setupTestStore(t)
+			store.MinTTL = time.Second
+			store.MaxTTL = 24 * time.Hour
+
+			err := store.Set([]byte("Key"), []byte("Value"), tt.ttl)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error: %v, got %v", tt.wantErr, err)
+			}
+
+			var rangeErr *TTLRangeError
+			if tt.wantErr != nil && (!errors.As(err, &rangeErr) || rangeErr.TTL != tt.ttl) {
+				t.Fatalf("expected a TTLRangeError for %v, got %v", tt.ttl, err)
+			}
+
+			if _, _, ok := store.Get([]byte("Key")); ok != (tt.wantErr == nil) {
+				t.Fatalf("expected stored %v, got %v", tt.wantErr == nil, ok)
+			}
+		})
+	}
+}
+
+func TestStoreDefaultTTL(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		ttl  time.Duration
+		want time.Duration
+	}{
+		{
+			name: "NoExpire",
+			ttl:  NoExpire,
+			want: 0,
+		},
+		{
+			name: "DefaultTTL",
+			ttl:  DefaultTTL,
+			want: 1 * time.Minute,
+		},
+		{
+			name: "Zero",
+			ttl:  0,
+			want: 1 * time.Minute,
+		},
+		{
+			name: "Specific",
+			ttl:  10 * time.Second,
+			want: 10 * time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			store := setupTestStore(t)
+			store.DefaultTTL = 1 * time.Minute
+
+			store.Set([]byte("Key"), []byte("Value"), tt.ttl)
+
+			_, ttl, ok := store.Get([]byte("Key"))
+			if !ok {
+				t.Fatalf("expected key to exist")
+			}
+
+			if got := ttl.Round(time.Second); got != tt.want {
+				t.Errorf("expected ttl %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	t.Run("Unset", func(t *testing.T) {
+		t.Parallel()
+
+		store := setupTestStore(t)
+
+		store.Set([]byte("Key"), []byte("Value"), DefaultTTL)
+
+		if _, ttl, ok := store.Get([]byte("Key")); !ok || ttl != 0 {
+			t.Fatalf("expected immortal key, got ttl %v", ttl)
+		}
+	})
+}
+
 func TestStoreDelete(t *testing.T) {
 	t.Parallel()
 
@@ -334,147 +588,1000 @@ func TestStoreUpdateInPlace(t *testing.T) {
 			t.Fatalf("expected key to exist")
 		}
 
-		if !bytes.Equal(want, got) {
-			t.Errorf("got %v, want %v", got, want)
-		}
-	})
+		if !bytes.Equal(want, got) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Not Exists", func(t *testing.T) {
+		t.Parallel()
+
+		store := setupTestStore(t)
+
+		processFunc := func(v []byte) ([]byte, error) {
+			return []byte("Value"), nil
+		}
+
+		if err := store.UpdateInPlace([]byte("Key"), processFunc, 1*time.Hour); !errors.Is(err, ErrKeyNotFound) {
+			t.Fatalf("expected error: %v, got: %v", ErrKeyNotFound, err)
+		}
+	})
+
+	t.Run("Slow", func(t *testing.T) {
+		t.Parallel()
+
+		store := setupTestStore(t)
+		store.Set([]byte("Slow"), []byte("Value"), 0)
+
+		// Find a key on another stripe, so its updates are not serialized with Slow.
+		other := []byte("Other")
+		for i := 0; store.stripe(other) == store.stripe([]byte("Slow")); i++ {
+			other = []byte("Other" + strconv.Itoa(i))
+		}
+
+		store.Set(other, []byte("Value"), 0)
+
+		started := make(chan struct{})
+		release := make(chan struct{})
+		done := make(chan error)
+
+		go func() {
+			done <- store.UpdateInPlace([]byte("Slow"), func(v []byte) ([]byte, error) {
+				close(started)
+				<-release
+
+				return []byte("Updated"), nil
+			}, 0)
+		}()
+
+		<-started
+
+		fast := make(chan struct{})
+
+		go func() {
+			defer close(fast)
+
+			store.Set([]byte("Key"), []byte("Value"), 0)
+			store.Get(other)
+
+			if err := store.UpdateInPlace(other, func(v []byte) ([]byte, error) {
+				return []byte("Updated"), nil
+			}, 0); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+
+		select {
+		case <-fast:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("operations on other keys were blocked by a slow update")
+		}
+
+		close(release)
+
+		if err := <-done; err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		for _, key := range [][]byte{[]byte("Slow"), other} {
+			if got, _, _ := store.Get(key); !bytes.Equal(got, []byte("Updated")) {
+				t.Errorf("expected %q for %q, got %q", "Updated", key, got)
+			}
+		}
+	})
+
+	t.Run("Concurrent Write", func(t *testing.T) {
+		t.Parallel()
+
+		store := setupTestStore(t)
+		store.Set([]byte("Key"), []byte("1"), 0)
+
+		var seen [][]byte
+
+		// The first call sees a write land behind it, so its result must be discarded.
+		err := store.UpdateInPlace([]byte("Key"), func(v []byte) ([]byte, error) {
+			seen = append(seen, v)
+
+			if len(seen) == 1 {
+				store.Set([]byte("Key"), []byte("2"), 0)
+			}
+
+			return append(v, '+'), nil
+		}, 0)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if len(seen) != 2 || !bytes.Equal(seen[1], []byte("2")) {
+			t.Fatalf("expected a retry with the new value, got calls with %q", seen)
+		}
+
+		if got, _, _ := store.Get([]byte("Key")); !bytes.Equal(got, []byte("2+")) {
+			t.Fatalf("expected %q, got %q", "2+", got)
+		}
+	})
+}
+
+func TestStoreMemoize(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Cache Miss", func(t *testing.T) {
+		t.Parallel()
+
+		store := setupTestStore(t)
+
+		factoryFunc := func() ([]byte, error) {
+			return []byte("Value"), nil
+		}
+
+		got, err := store.Memorize([]byte("Key"), factoryFunc, 1*time.Hour)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if !bytes.Equal(got, []byte("Value")) {
+			t.Fatalf("expected: %v, got: %v", "Value", got)
+		}
+
+		got, _, ok := store.Get([]byte("Key"))
+		if !ok {
+			t.Fatalf("expected key to exist")
+		}
+
+		if !bytes.Equal(got, []byte("Value")) {
+			t.Fatalf("expected: %v, got: %v", "Value", got)
+		}
+	})
+
+	t.Run("Cache Hit", func(t *testing.T) {
+		t.Parallel()
+
+		store := setupTestStore(t)
+
+		store.Set([]byte("Key"), []byte("Value"), 1*time.Hour)
+
+		factoryFunc := func() ([]byte, error) {
+			return []byte("NewValue"), nil
+		}
+
+		got, err := store.Memorize([]byte("Key"), factoryFunc, 1*time.Hour)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if !bytes.Equal(got, []byte("Value")) {
+			t.Fatalf("expected: %v, got: %v", "Value", got)
+		}
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		t.Parallel()
+
+		store := setupTestStore(t)
+
+		if err := store.Set([]byte("Key"), []byte("Old"), time.Nanosecond); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		time.Sleep(time.Millisecond)
+
+		got, err := store.Memorize([]byte("Key"), func() ([]byte, error) {
+			return []byte("New"), nil
+		}, time.Hour)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if !bytes.Equal(got, []byte("New")) {
+			t.Fatalf("expected: %v, got: %v", "New", got)
+		}
+
+		// The expired entry is replaced, not kept next to the new one.
+		if store.Length != 1 || store.Cost != uint64(len("Key")+len("New")) {
+			t.Fatalf("expected 1 entry costing %d, got %d costing %d", len("Key")+len("New"), store.Length, store.Cost)
+		}
+
+		if err := store.verifyEvictList(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("Stored Meanwhile", func(t *testing.T) {
+		t.Parallel()
+
+		store := setupTestStore(t)
+
+		// The factory runs without the lock, so another write can store the key first.
+		got, err := store.Memorize([]byte("Key"), func() ([]byte, error) {
+			if err := store.Set([]byte("Key"), []byte("Other"), 0); err != nil {
+				return nil, err
+			}
+
+			return []byte("New"), nil
+		}, time.Hour)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if !bytes.Equal(got, []byte("Other")) {
+			t.Fatalf("expected: %v, got: %v", "Other", got)
+		}
+
+		if store.Length != 1 {
+			t.Fatalf("expected 1 entry, got %d", store.Length)
+		}
+	})
+}
+
+func TestStoreCleanup(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Cleanup Expired", func(t *testing.T) {
+		t.Parallel()
+
+		store := setupTestStore(t)
+
+		store.Set([]byte("1"), []byte("1"), 500*time.Millisecond)
+		store.Set([]byte("2"), []byte("2"), 1*time.Hour)
+
+		time.Sleep(600 * time.Millisecond)
+
+		store.Cleanup()
+
+		if _, _, ok := store.Get([]byte("1")); ok {
+			t.Fatalf("expected 1 to not exist")
+		}
+
+		if _, _, ok := store.Get([]byte("2")); !ok {
+			t.Fatalf("expected 2 to exist")
+		}
+	})
+
+	t.Run("No Cleanup", func(t *testing.T) {
+		t.Parallel()
+
+		store := setupTestStore(t)
+
+		store.Set([]byte("Key"), []byte("Value"), 1*time.Hour)
+
+		// No cleanup should occur
+		store.Cleanup()
+
+		if _, _, ok := store.Get([]byte("Key")); !ok {
+			t.Fatalf("expected key to exist")
+		}
+	})
+}
+
+func TestStoreCompact(t *testing.T) {
+	t.Parallel()
+
+	store := setupTestStore(t)
+
+	for i := range 1000 {
+		store.Set([]byte(strconv.Itoa(i)), []byte("Value"), 0)
+	}
+
+	for i := range 990 {
+		store.Delete([]byte(strconv.Itoa(i)))
+	}
+
+	store.Set([]byte("Expired"), []byte("Value"), -time.Second)
+
+	grown := len(store.Bucket)
+
+	store.Compact()
+
+	if len(store.Bucket) >= grown {
+		t.Fatalf("expected bucket count below %d, got %d", grown, len(store.Bucket))
+	}
+
+	if want := bucketSize(10, int(initialBucketSize)); len(store.Bucket) != want {
+		t.Fatalf("expected %d buckets, got %d", want, len(store.Bucket))
+	}
+
+	if store.Length != 10 {
+		t.Fatalf("expected 10 entries, got %d", store.Length)
+	}
+
+	for i := 990; i < 1000; i++ {
+		if _, _, ok := store.Get([]byte(strconv.Itoa(i))); !ok {
+			t.Fatalf("expected key %d to exist", i)
+		}
+	}
+}
+
+func TestStoreMaxBuckets(t *testing.T) {
+	t.Parallel()
+
+	store := setupTestStore(t)
+	store.MaxBuckets = 32
+
+	for i := range 1000 {
+		key := []byte(strconv.Itoa(i))
+		if err := store.Set(key, key, 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if len(store.Bucket) > 32 {
+			t.Fatalf("expected at most 32 buckets, got %d", len(store.Bucket))
+		}
+	}
+
+	store.Resize()
+
+	if len(store.Bucket) != 32 {
+		t.Fatalf("expected 32 buckets, got %d", len(store.Bucket))
+	}
+
+	for i := range 1000 {
+		key := []byte(strconv.Itoa(i))
+		if got, _, ok := store.Get(key); !ok || !bytes.Equal(got, key) {
+			t.Fatalf("expected key %s to be found", key)
+		}
+	}
+}
+
+func TestCacheMaxBucketsParallelResize(t *testing.T) {
+	t.Parallel()
+
+	db, err := OpenRawMem(WithParallelResize(8), WithMaxBuckets(5000))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	for i := range 20000 {
+		key := []byte(strconv.Itoa(i))
+		if err := db.Set(key, key, 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// The cap is rounded down, so parallel rehashing never mixes old buckets.
+	if len(db.Store.Bucket) != 4096 {
+		t.Fatalf("expected 4096 buckets, got %d", len(db.Store.Bucket))
+	}
+
+	for i := range 20000 {
+		key := []byte(strconv.Itoa(i))
+		if got, _, ok := db.Store.Get(key); !ok || !bytes.Equal(got, key) {
+			t.Fatalf("expected key %s to be found", key)
+		}
+	}
+}
+
+func TestStoreHashDistribution(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Default", func(t *testing.T) {
+		t.Parallel()
+
+		store := setupTestStore(t)
+
+		for i := range 100 {
+			store.Set([]byte(strconv.Itoa(i)), []byte("Value"), 0)
+		}
+
+		dist := store.HashDistribution()
+		if len(dist) != len(store.Bucket) {
+			t.Fatalf("expected %d buckets, got %d", len(store.Bucket), len(dist))
+		}
+
+		total := 0
+		for _, n := range dist {
+			total += n
+		}
+
+		if total != 100 {
+			t.Fatalf("expected 100 entries, got %d", total)
+		}
+	})
+
+	t.Run("Constant Hasher", func(t *testing.T) {
+		t.Parallel()
+
+		store := setupTestStore(t)
+
+		for i := range 10 {
+			store.Set([]byte(strconv.Itoa(i)), []byte("Value"), 0)
+		}
+
+		store.setHasher(func([]byte) uint64 { return 3 })
+
+		for i := 10; i < 20; i++ {
+			store.Set([]byte(strconv.Itoa(i)), []byte("Value"), 0)
+		}
+
+		for i, n := range store.HashDistribution() {
+			want := 0
+			if i == 3 {
+				want = 20
+			}
+
+			if n != want {
+				t.Fatalf("expected %d entries in bucket %d, got %d", want, i, n)
+			}
+		}
+
+		if _, _, ok := store.Get([]byte("5")); !ok {
+			t.Fatalf("expected key to exist after rehashing")
+		}
+	})
+}
+
+func TestStoreEntryOverhead(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Cost", func(t *testing.T) {
+		t.Parallel()
+
+		store := setupTestStore(t)
+		store.EntryOverhead = 10
+
+		store.Set([]byte("1"), []byte("1"), 0)
+
+		if store.Cost != 12 {
+			t.Fatalf("expected cost 12, got %d", store.Cost)
+		}
+
+		store.Set([]byte("1"), []byte("11"), 0)
+
+		if store.Cost != 13 {
+			t.Fatalf("expected cost 13, got %d", store.Cost)
+		}
+
+		store.Delete([]byte("1"))
+
+		if store.Cost != 0 {
+			t.Fatalf("expected cost 0, got %d", store.Cost)
+		}
+	})
+
+	t.Run("Evict", func(t *testing.T) {
+		t.Parallel()
+
+		store := setupTestStore(t)
+		if err := store.Policy.SetPolicy(PolicyFIFO); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		store.MaxCost = 30
+		store.EntryOverhead = 10
+
+		// Without the overhead the three entries would only cost 6.
+		store.Set([]byte("1"), []byte("1"), 0)
+		store.Set([]byte("2"), []byte("2"), 0)
+		store.Set([]byte("3"), []byte("3"), 0)
+		store.Evict()
+
+		if store.Length != 2 {
+			t.Fatalf("expected 2 entries, got %d", store.Length)
+		}
+
+		if _, _, ok := store.Get([]byte("1")); ok {
+			t.Fatalf("expected 1 to be evicted")
+		}
+	})
+}
+
+func TestStoreTTLHistogram(t *testing.T) {
+	t.Parallel()
+
+	store := setupTestStore(t)
+
+	buckets := []time.Duration{time.Minute, time.Hour, 24 * time.Hour}
+
+	if got := store.TTLHistogram(buckets); !slices.Equal(got, []uint64{0, 0, 0, 0}) {
+		t.Fatalf("expected no entries, got %v", got)
+	}
+
+	entries := map[string]time.Duration{
+		"Second":   time.Second,
+		"Minute":   30 * time.Second,
+		"Hour":     30 * time.Minute,
+		"Hours":    2 * time.Hour,
+		"Day":      12 * time.Hour,
+		"Week":     7 * 24 * time.Hour,
+		"Immortal": 0,
+		"Forever":  0,
+		"Expired":  time.Millisecond,
+	}
+
+	for key, ttl := range entries {
+		if err := store.Set([]byte(key), []byte("Value"), ttl); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	// Week is past the last bucket and Expired is no longer live.
+	want := []uint64{2, 1, 2, 2}
+	if got := store.TTLHistogram(buckets); !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestStoreAgeStats(t *testing.T) {
+	t.Parallel()
+
+	store := setupTestStore(t)
+	store.TrackAge = true
+
+	now := time.Now()
+	store.Now = func() time.Time { return now }
+
+	if oldest, newest := store.AgeStats(); oldest != 0 || newest != 0 {
+		t.Fatalf("expected 0 ages for an empty store, got %v and %v", oldest, newest)
+	}
+
+	for i, k := range []string{"1", "2", "3"} {
+		now = now.Add(time.Duration(i) * time.Minute)
+
+		ttl := time.Duration(0)
+		if k == "2" {
+			ttl = time.Hour
+		}
+
+		if err := store.Set([]byte(k), []byte(k), ttl); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	now = now.Add(time.Minute)
+
+	oldest, newest := store.AgeStats()
+	if oldest != 4*time.Minute {
+		t.Fatalf("expected oldest age %v, got %v", 4*time.Minute, oldest)
+	}
+
+	if newest != time.Minute {
+		t.Fatalf("expected newest age %v, got %v", time.Minute, newest)
+	}
+
+	// Overwriting an entry resets its age.
+	if err := store.Set([]byte("1"), []byte("1"), 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	oldest, newest = store.AgeStats()
+	if oldest != 3*time.Minute || newest != 0 {
+		t.Fatalf("expected ages %v and 0, got %v and %v", 3*time.Minute, oldest, newest)
+	}
+}
+
+func TestStoreCostUnderflow(t *testing.T) {
+	t.Parallel()
+
+	if debug {
+		t.Skip("cost underflow panics in debug builds")
+	}
+
+	tests := []struct {
+		name string
+		fn   func(s *store) error
+	}{
+		{
+			name: "Delete",
+			fn: func(s *store) error {
+				s.Delete([]byte("Key"))
+
+				return nil
+			},
+		},
+		{
+			name: "Update",
+			fn: func(s *store) error {
+				return s.Set([]byte("Key"), []byte("V"), 0)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			store := setupTestStore(t)
+
+			if err := store.Set([]byte("Key"), []byte("Value"), 0); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// Force the accounting out of sync with the entries.
+			store.Cost = 1
+
+			if err := tt.fn(store); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if store.Cost != 0 {
+				t.Fatalf("expected cost to be clamped to 0, got %d", store.Cost)
+			}
+		})
+	}
+}
+
+func TestStoreRejectOnFull(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Reject", func(t *testing.T) {
+		t.Parallel()
+
+		store := setupTestStore(t)
+		store.MaxCost = 5
+		store.RejectOnFull = true
+
+		if err := store.Set([]byte("1"), []byte("1"), 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if err := store.Set([]byte("2"), []byte("2"), 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if err := store.Set([]byte("3"), []byte("3"), 0); !errors.Is(err, ErrCacheFull) {
+			t.Fatalf("expected error: %v, got %v", ErrCacheFull, err)
+		}
+
+		// Replacing a value with one of the same size still fits.
+		if err := store.Set([]byte("1"), []byte("9"), 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if store.Length != 2 || store.Cost != 4 {
+			t.Fatalf("expected 2 entries with cost 4, got %d with cost %d", store.Length, store.Cost)
+		}
+	})
+
+	t.Run("Evict", func(t *testing.T) {
+		t.Parallel()
+
+		store := setupTestStore(t)
+		if err := store.Policy.SetPolicy(PolicyFIFO); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		store.MaxCost = 5
+		store.RejectOnFull = true
+
+		for _, k := range []string{"1", "2", "3"} {
+			if err := store.Set([]byte(k), []byte(k), 0); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+
+		store.Evict()
+
+		if _, _, ok := store.Get([]byte("1")); ok {
+			t.Fatalf("expected 1 to be evicted")
+		}
+
+		if _, _, ok := store.Get([]byte("3")); !ok {
+			t.Fatalf("expected 3 to exist")
+		}
+	})
+}
+
+func TestStoreSyncMaintain(t *testing.T) {
+	t.Parallel()
+
+	store := setupTestStore(t)
+	if err := store.Policy.SetPolicy(PolicyFIFO); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	store.MaxCost = 5
+	store.SyncMaintain = true
+
+	store.Set([]byte("1"), []byte("1"), 0)
+	store.Set([]byte("2"), []byte("2"), 0)
+	store.Set([]byte("3"), []byte("3"), 0)
+
+	if store.Cost > store.MaxCost {
+		t.Fatalf("expected cost at most %d, got %d", store.MaxCost, store.Cost)
+	}
+
+	if _, _, ok := store.Get([]byte("1")); ok {
+		t.Fatalf("expected 1 to be evicted")
+	}
+
+	if _, _, ok := store.Get([]byte("3")); !ok {
+		t.Fatalf("expected 3 to exist")
+	}
+}
+
+func TestStoreExpired(t *testing.T) {
+	t.Parallel()
+
+	store := setupTestStore(t)
+
+	store.Set([]byte("1"), []byte("1"), 100*time.Millisecond)
+	store.Set([]byte("2"), []byte("2"), 100*time.Millisecond)
+	store.Set([]byte("3"), []byte("3"), 1*time.Hour)
+
+	time.Sleep(200 * time.Millisecond)
+
+	got := map[string]string{}
+	for _, kv := range store.Expired() {
+		got[string(kv.Key)] = string(kv.Value)
+	}
+
+	want := map[string]string{"1": "1", "2": "2"}
+	if !maps.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+
+	if store.Length != 3 {
+		t.Fatalf("expected expired entries to remain, got length %d", store.Length)
+	}
+
+	store.Cleanup()
+
+	if store.Length != 1 {
+		t.Fatalf("expected 1 entry after cleanup, got %d", store.Length)
+	}
+
+	if expired := store.Expired(); len(expired) != 0 {
+		t.Fatalf("expected no expired entries, got %v", expired)
+	}
+}
+
+func TestStoreEvictN(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		policy  EvictionPolicyType
+		n       int
+		want    int
+		deleted []string
+	}{
+		{name: "FIFO", policy: PolicyFIFO, n: 2, want: 2, deleted: []string{"1", "2"}},
+		{name: "More Than Length", policy: PolicyFIFO, n: 10, want: 5, deleted: []string{"1", "2", "3", "4", "5"}},
+		{name: "None", policy: PolicyNone, n: 2, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			store := setupTestStore(t)
+			if err := store.Policy.SetPolicy(tt.policy); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// Far above the cost of the entries, so only EvictN removes them.
+			store.MaxCost = 1000
+
+			keys := []string{"1", "2", "3", "4", "5"}
+			for _, key := range keys {
+				store.Set([]byte(key), []byte("Value"), 0)
+			}
+
+			if got := store.EvictN(tt.n); got != tt.want {
+				t.Fatalf("expected %d entries evicted, got %d", tt.want, got)
+			}
+
+			for _, key := range keys {
+				_, _, ok := store.Get([]byte(key))
+				if want := !slices.Contains(tt.deleted, key); ok != want {
+					t.Errorf("key %s: expected exists %v, got %v", key, want, ok)
+				}
+			}
+
+			if store.Length != uint64(len(keys)-tt.want) {
+				t.Fatalf("expected %d entries, got %d", len(keys)-tt.want, store.Length)
+			}
+		})
+	}
+}
+
+func TestStoreEvict(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Evict FIFO", func(t *testing.T) {
+		t.Parallel()
+
+		store := setupTestStore(t)
+		if err := store.Policy.SetPolicy(PolicyFIFO); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		store.MaxCost = 5
+
+		store.Set([]byte("1"), []byte("1"), 0)
+		store.Set([]byte("2"), []byte("2"), 0)
+
+		// Trigger eviction
+		store.Set([]byte("3"), []byte("3"), 0)
+		store.Evict()
+
+		if _, _, ok := store.Get([]byte("1")); ok {
+			t.Fatalf("expected key 1 to not exist")
+		}
+
+		if _, _, ok := store.Get([]byte("2")); !ok {
+			t.Fatalf("expected key 2 to exist")
+		}
+	})
+
+	t.Run("Evict SLRU", func(t *testing.T) {
+		t.Parallel()
+
+		store := setupTestStore(t)
+		if err := store.Policy.SetPolicy(PolicySLRU); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		store.MaxCost = 5
+
+		store.Set([]byte("1"), []byte("1"), 0)
+		store.Set([]byte("2"), []byte("2"), 0)
+
+		// Accessing 1 twice promotes it to the protected segment
+		store.Get([]byte("1"))
+		store.Get([]byte("1"))
 
-	t.Run("Not Exists", func(t *testing.T) {
-		t.Parallel()
+		// Trigger eviction
+		store.Set([]byte("3"), []byte("3"), 0)
+		store.Evict()
 
-		store := setupTestStore(t)
+		if _, _, ok := store.Get([]byte("2")); ok {
+			t.Fatalf("expected key 2 to not exist")
+		}
 
-		processFunc := func(v []byte) ([]byte, error) {
-			return []byte("Value"), nil
+		v, _, _ := store.lookup([]byte("1"))
+		if v == nil {
+			t.Fatalf("expected key 1 to exist")
 		}
 
-		if err := store.UpdateInPlace([]byte("Key"), processFunc, 1*time.Hour); !errors.Is(err, ErrKeyNotFound) {
-			t.Fatalf("expected error: %v, got: %v", ErrKeyNotFound, err)
+		if !v.Protected {
+			t.Fatalf("expected key 1 to be protected")
 		}
 	})
-}
-
-func TestStoreMemoize(t *testing.T) {
-	t.Parallel()
 
-	t.Run("Cache Miss", func(t *testing.T) {
+	t.Run("Evict LRUSample", func(t *testing.T) {
 		t.Parallel()
 
 		store := setupTestStore(t)
-
-		factoryFunc := func() ([]byte, error) {
-			return []byte("Value"), nil
+		if err := store.Policy.SetPolicy(PolicyLRUSample); err != nil {
+			t.Fatalf("unexpected error: %v", err)
 		}
 
-		got, err := store.Memorize([]byte("Key"), factoryFunc, 1*time.Hour)
-		if err != nil {
+		// Sample every entry so the oldest one is always found
+		store.Policy.SampleSize = 16
+		if err := store.Policy.SetPolicy(PolicyLRUSample); err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
 
-		if !bytes.Equal(got, []byte("Value")) {
-			t.Fatalf("expected: %v, got: %v", "Value", got)
-		}
+		store.MaxCost = 5
 
-		got, _, ok := store.Get([]byte("Key"))
-		if !ok {
-			t.Fatalf("expected key to exist")
+		store.Set([]byte("1"), []byte("1"), 0)
+		store.Set([]byte("2"), []byte("2"), 0)
+
+		// Touch 1 so 2 becomes the least recently used
+		store.Get([]byte("1"))
+
+		// Trigger eviction
+		store.Set([]byte("3"), []byte("3"), 0)
+		store.Evict()
+
+		if _, _, ok := store.Get([]byte("2")); ok {
+			t.Fatalf("expected key 2 to not exist")
 		}
 
-		if !bytes.Equal(got, []byte("Value")) {
-			t.Fatalf("expected: %v, got: %v", "Value", got)
+		if _, _, ok := store.Get([]byte("1")); !ok {
+			t.Fatalf("expected key 1 to exist")
 		}
 	})
 
-	t.Run("Cache Hit", func(t *testing.T) {
+	t.Run("Evict LRUSample Sampled", func(t *testing.T) {
 		t.Parallel()
 
 		store := setupTestStore(t)
+		store.Policy.SampleSize = 5
 
-		store.Set([]byte("Key"), []byte("Value"), 1*time.Hour)
-
-		factoryFunc := func() ([]byte, error) {
-			return []byte("NewValue"), nil
-		}
-
-		got, err := store.Memorize([]byte("Key"), factoryFunc, 1*time.Hour)
-		if err != nil {
+		if err := store.Policy.SetPolicy(PolicyLRUSample); err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
 
-		if !bytes.Equal(got, []byte("Value")) {
-			t.Fatalf("expected: %v, got: %v", "Value", got)
-		}
-	})
-}
+		const n = 1000
 
-func TestStoreCleanup(t *testing.T) {
-	t.Parallel()
+		for i := range n {
+			store.Set([]byte(strconv.Itoa(i)), []byte("Value"), 0)
+		}
 
-	t.Run("Cleanup Expired", func(t *testing.T) {
-		t.Parallel()
+		// Touch the second half so it is more recent than the first.
+		for i := n / 2; i < n; i++ {
+			store.Get([]byte(strconv.Itoa(i)))
+		}
 
-		store := setupTestStore(t)
+		victims := map[string]int{}
+		recent := 0
 
-		store.Set([]byte("1"), []byte("1"), 500*time.Millisecond)
-		store.Set([]byte("2"), []byte("2"), 1*time.Hour)
+		for range n {
+			v := store.Policy.Evict()
+			if v == nil {
+				t.Fatalf("expected a victim")
+			}
 
-		time.Sleep(600 * time.Millisecond)
+			victims[string(v.Key)]++
 
-		store.Cleanup()
+			if i, _ := strconv.Atoi(string(v.Key)); i >= n/2 {
+				recent++
+			}
+		}
 
-		if _, _, ok := store.Get([]byte("1")); ok {
-			t.Fatalf("expected 1 to not exist")
+		// An exact scan would always pick key 0.
+		if len(victims) < 2 {
+			t.Fatalf("expected the victims to vary, got %v", victims)
 		}
 
-		if _, _, ok := store.Get([]byte("2")); !ok {
-			t.Fatalf("expected 2 to exist")
+		// All 5 samples land in the recent half with probability 1/32.
+		if recent > n/10 {
+			t.Fatalf("expected mostly old victims, got %d recent out of %d", recent, n)
 		}
 	})
 
-	t.Run("No Cleanup", func(t *testing.T) {
-		t.Parallel()
-
-		store := setupTestStore(t)
-
-		store.Set([]byte("Key"), []byte("Value"), 1*time.Hour)
+	for name, wheel := range map[string]bool{"Evict LRUTTL": false, "Evict LRUTTL Wheel": true} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
 
-		// No cleanup should occur
-		store.Cleanup()
+			store := setupTestStore(t)
+			if err := store.Policy.SetPolicy(PolicyLRUTTL); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
 
-		if _, _, ok := store.Get([]byte("Key")); !ok {
-			t.Fatalf("expected key to exist")
-		}
-	})
-}
+			if wheel {
+				store.Wheel = newExpiryWheel()
+			}
 
-func TestStoreEvict(t *testing.T) {
-	t.Parallel()
+			reasons := map[string]EvictionReason{}
+			store.OnEvict = func(key, value []byte, reason EvictionReason) {
+				reasons[string(key)] = reason
+			}
 
-	t.Run("Evict FIFO", func(t *testing.T) {
-		t.Parallel()
+			store.Set([]byte("1"), []byte("1"), 0)
+			store.Set([]byte("2"), []byte("2"), 0)
+			store.Set([]byte("3"), []byte("3"), 0)
+			store.Set([]byte("4"), []byte("4"), time.Nanosecond)
+			store.Get([]byte("1"))
 
-		store := setupTestStore(t)
-		if err := store.Policy.SetPolicy(PolicyFIFO); err != nil {
-			t.Fatalf("unexpected error: %v", err)
-		}
+			time.Sleep(time.Millisecond)
 
-		store.MaxCost = 5
+			if wheel {
+				// The wheel only hands out entries once their second has passed.
+				time.Sleep(time.Second)
+			}
 
-		store.Set([]byte("1"), []byte("1"), 0)
-		store.Set([]byte("2"), []byte("2"), 0)
+			// Evicting two entries removes the expired one, then the least recently used
+			store.MaxCost = 4
+			store.Evict()
 
-		// Trigger eviction
-		store.Set([]byte("3"), []byte("3"), 0)
-		store.Evict()
+			for _, k := range []string{"2", "4"} {
+				if v, _, _ := store.lookup([]byte(k)); v != nil {
+					t.Fatalf("expected key %s to not exist", k)
+				}
+			}
 
-		if _, _, ok := store.Get([]byte("1")); ok {
-			t.Fatalf("expected key 1 to not exist")
-		}
+			for _, k := range []string{"1", "3"} {
+				if _, _, ok := store.Get([]byte(k)); !ok {
+					t.Fatalf("expected key %s to exist", k)
+				}
+			}
 
-		if _, _, ok := store.Get([]byte("2")); !ok {
-			t.Fatalf("expected key 2 to exist")
-		}
-	})
+			want := map[string]EvictionReason{"4": ReasonExpired, "2": ReasonEvicted}
+			if !maps.Equal(reasons, want) {
+				t.Fatalf("expected reasons %v, got %v", want, reasons)
+			}
+		})
+	}
 
 	t.Run("No Evict", func(t *testing.T) {
 		t.Parallel()
@@ -553,13 +1660,163 @@ func TestStoreEvict(t *testing.T) {
 	})
 }
 
+func TestStoreTopBySize(t *testing.T) {
+	t.Parallel()
+
+	store := setupTestStore(t)
+
+	for i, size := range []int{5, 50, 1, 20, 100, 10} {
+		store.Set([]byte(strconv.Itoa(i)), bytes.Repeat([]byte("v"), size), 0)
+	}
+
+	got := store.TopBySize(3)
+	want := []KeyCost{
+		{Key: []byte("4"), Cost: 101},
+		{Key: []byte("1"), Cost: 51},
+		{Key: []byte("3"), Cost: 21},
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("expected %d entries, got %d", len(want), len(got))
+	}
+
+	for i := range want {
+		if !bytes.Equal(got[i].Key, want[i].Key) || got[i].Cost != want[i].Cost {
+			t.Errorf("expected %s (%d), got %s (%d)", want[i].Key, want[i].Cost, got[i].Key, got[i].Cost)
+		}
+	}
+
+	if got := store.TopBySize(10); len(got) != 6 {
+		t.Errorf("expected %d entries, got %d", 6, len(got))
+	}
+}
+
+func TestStoreNextEviction(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		policy EvictionPolicyType
+	}{
+		{name: "FIFO", policy: PolicyFIFO},
+		{name: "LRU", policy: PolicyLRU},
+		{name: "LFU", policy: PolicyLFU},
+		{name: "LTR", policy: PolicyLTR},
+		{name: "SLRU", policy: PolicySLRU},
+		{name: "LRUTTL", policy: PolicyLRUTTL},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			store := setupTestStore(t)
+			if err := store.Policy.SetPolicy(tt.policy); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			store.Set([]byte("1"), []byte("1"), 2*time.Hour)
+			store.Set([]byte("2"), []byte("2"), 3*time.Hour)
+			store.Set([]byte("3"), []byte("3"), 1*time.Hour)
+			store.Get([]byte("1"))
+			store.Get([]byte("3"))
+
+			got, ok := store.NextEviction()
+			if !ok {
+				t.Fatalf("expected a candidate")
+			}
+
+			// Peeking must not remove anything
+			if store.Length != 3 {
+				t.Fatalf("expected length 3, got %d", store.Length)
+			}
+
+			store.MaxCost = store.Cost - 1
+			store.Evict()
+
+			if _, _, ok := store.Get(got); ok {
+				t.Fatalf("expected key %q to be evicted", got)
+			}
+		})
+	}
+
+	t.Run("None", func(t *testing.T) {
+		t.Parallel()
+
+		store := setupTestStore(t)
+		store.Set([]byte("1"), []byte("1"), 0)
+
+		if _, ok := store.NextEviction(); ok {
+			t.Fatalf("expected no candidate")
+		}
+	})
+
+	t.Run("LRUTTL Expired", func(t *testing.T) {
+		t.Parallel()
+
+		store := setupTestStore(t)
+		if err := store.Policy.SetPolicy(PolicyLRUTTL); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		store.Set([]byte("1"), []byte("1"), 0)
+		store.Set([]byte("2"), []byte("2"), time.Nanosecond)
+		store.Set([]byte("3"), []byte("3"), 0)
+		store.Get([]byte("2"))
+
+		time.Sleep(time.Millisecond)
+
+		// The expired entry goes first even though 1 is the least recently used.
+		got, ok := store.NextEviction()
+		if !ok || !bytes.Equal(got, []byte("2")) {
+			t.Fatalf("expected %q, got %q", "2", got)
+		}
+
+		store.MaxCost = store.Cost - 1
+		store.Evict()
+
+		if v, _, _ := store.lookup(got); v != nil {
+			t.Fatalf("expected key %q to be removed", got)
+		}
+	})
+
+	t.Run("LRUSample", func(t *testing.T) {
+		t.Parallel()
+
+		store := setupTestStore(t)
+		store.Policy.SampleSize = 2
+
+		if err := store.Policy.SetPolicy(PolicyLRUSample); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		for i := range 8 {
+			store.Set([]byte(strconv.Itoa(i)), []byte("Value"), 0)
+		}
+
+		// The sample is drawn again on every call, so only the candidate is checked.
+		got, ok := store.NextEviction()
+		if !ok {
+			t.Fatalf("expected a candidate")
+		}
+
+		if v, _, _ := store.lookup(got); v == nil {
+			t.Fatalf("expected key %q to exist", got)
+		}
+	})
+}
+
+// BenchmarkStoreGet reads a key under every policy. PolicyLRUSample only stamps the
+// node, while PolicyLRU moves it in the eviction list.
 func BenchmarkStoreGet(b *testing.B) {
 	policy := map[string]EvictionPolicyType{
-		"None": PolicyNone,
-		"FIFO": PolicyFIFO,
-		"LRU":  PolicyLRU,
-		"LFU":  PolicyLFU,
-		"LTR":  PolicyLTR,
+		"None":      PolicyNone,
+		"FIFO":      PolicyFIFO,
+		"LRU":       PolicyLRU,
+		"LFU":       PolicyLFU,
+		"LTR":       PolicyLTR,
+		"SLRU":      PolicySLRU,
+		"LRUSample": PolicyLRUSample,
 	}
 	for k, v := range policy {
 		b.Run(k, func(b *testing.B) {
@@ -590,13 +1847,43 @@ func BenchmarkStoreGet(b *testing.B) {
 	}
 }
 
+// BenchmarkStoreGetMiss looks up absent keys, which mostly land in empty buckets
+// and so only pay for hashing and the bucket walk.
+func BenchmarkStoreGetMiss(b *testing.B) {
+	for n := 1; n <= 100000; n *= 10 {
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			want := setupTestStore(b)
+
+			for i := range n {
+				buf := binary.LittleEndian.AppendUint64(nil, uint64(i))
+				want.Set(buf, buf, 0)
+			}
+
+			keys := make([][]byte, 1024)
+			for i := range keys {
+				keys[i] = binary.LittleEndian.AppendUint64(nil, uint64(n+i))
+			}
+
+			b.ReportAllocs()
+
+			i := 0
+			for b.Loop() {
+				want.Get(keys[i%len(keys)])
+				i++
+			}
+		})
+	}
+}
+
 func BenchmarkStoreGetParallel(b *testing.B) {
 	policy := map[string]EvictionPolicyType{
-		"None": PolicyNone,
-		"FIFO": PolicyFIFO,
-		"LRU":  PolicyLRU,
-		"LFU":  PolicyLFU,
-		"LTR":  PolicyLTR,
+		"None":      PolicyNone,
+		"FIFO":      PolicyFIFO,
+		"LRU":       PolicyLRU,
+		"LFU":       PolicyLFU,
+		"LTR":       PolicyLTR,
+		"SLRU":      PolicySLRU,
+		"LRUSample": PolicyLRUSample,
 	}
 	for k, v := range policy {
 		b.Run(k, func(b *testing.B) {
@@ -631,11 +1918,13 @@ func BenchmarkStoreGetParallel(b *testing.B) {
 
 func BenchmarkStoreSet(b *testing.B) {
 	policy := map[string]EvictionPolicyType{
-		"None": PolicyNone,
-		"FIFO": PolicyFIFO,
-		"LRU":  PolicyLRU,
-		"LFU":  PolicyLFU,
-		"LTR":  PolicyLTR,
+		"None":      PolicyNone,
+		"FIFO":      PolicyFIFO,
+		"LRU":       PolicyLRU,
+		"LFU":       PolicyLFU,
+		"LTR":       PolicyLTR,
+		"SLRU":      PolicySLRU,
+		"LRUSample": PolicyLRUSample,
 	}
 	for k, v := range policy {
 		b.Run(k, func(b *testing.B) {
@@ -671,11 +1960,13 @@ func BenchmarkStoreSet(b *testing.B) {
 
 func BenchmarkStoreSetParallel(b *testing.B) {
 	policy := map[string]EvictionPolicyType{
-		"None": PolicyNone,
-		"FIFO": PolicyFIFO,
-		"LRU":  PolicyLRU,
-		"LFU":  PolicyLFU,
-		"LTR":  PolicyLTR,
+		"None":      PolicyNone,
+		"FIFO":      PolicyFIFO,
+		"LRU":       PolicyLRU,
+		"LFU":       PolicyLFU,
+		"LTR":       PolicyLTR,
+		"SLRU":      PolicySLRU,
+		"LRUSample": PolicyLRUSample,
 	}
 	for k, v := range policy {
 		b.Run(k, func(b *testing.B) {
@@ -707,13 +1998,35 @@ func BenchmarkStoreSetParallel(b *testing.B) {
 	}
 }
 
+func BenchmarkStoreResize(b *testing.B) {
+	for _, workers := range []int{1, 4, 8} {
+		b.Run(strconv.Itoa(workers), func(b *testing.B) {
+			store := setupTestStore(b)
+			store.ResizeWorkers = workers
+
+			for i := range 1000000 {
+				key := binary.LittleEndian.AppendUint64(nil, uint64(i))
+				store.Set(key, key, 0)
+			}
+
+			// Rehashing into a table of the same size costs as much as the
+			// worst case insert that triggers a resize.
+			for b.Loop() {
+				store.resizeTo(len(store.Bucket))
+			}
+		})
+	}
+}
+
 func BenchmarkStoreSetInsert(b *testing.B) {
 	policy := map[string]EvictionPolicyType{
-		"None": PolicyNone,
-		"FIFO": PolicyFIFO,
-		"LRU":  PolicyLRU,
-		"LFU":  PolicyLFU,
-		"LTR":  PolicyLTR,
+		"None":      PolicyNone,
+		"FIFO":      PolicyFIFO,
+		"LRU":       PolicyLRU,
+		"LFU":       PolicyLFU,
+		"LTR":       PolicyLTR,
+		"SLRU":      PolicySLRU,
+		"LRUSample": PolicyLRUSample,
 	}
 	for k, v := range policy {
 		b.Run(k, func(b *testing.B) {
@@ -748,6 +2061,46 @@ func BenchmarkStoreSetInsert(b *testing.B) {
 	}
 }
 
+// BenchmarkStoreEvict sets new keys into a full store, so every Set evicts an entry.
+// PolicyLRUSample pays at eviction time for the Gets that PolicyLRU pays for.
+func BenchmarkStoreEvict(b *testing.B) {
+	policy := map[string]EvictionPolicyType{
+		"LRU":       PolicyLRU,
+		"LRUSample": PolicyLRUSample,
+	}
+	for k, v := range policy {
+		b.Run(k, func(b *testing.B) {
+			for n := 10; n <= 100000; n *= 10 {
+				b.Run(strconv.Itoa(n), func(b *testing.B) {
+					want := setupTestStore(b)
+
+					if err := want.Policy.SetPolicy(v); err != nil {
+						b.Fatalf("unexpected error: %v", err)
+					}
+
+					want.MaxCost = uint64(n) * 16
+
+					i := uint64(0)
+					for ; i < uint64(n); i++ {
+						buf := make([]byte, 8)
+						binary.LittleEndian.PutUint64(buf, i)
+						want.Set(buf, buf, 0)
+					}
+
+					b.ReportAllocs()
+
+					for b.Loop() {
+						buf := make([]byte, 8)
+						binary.LittleEndian.PutUint64(buf, i)
+						want.Set(buf, buf, 0)
+						i++
+					}
+				})
+			}
+		})
+	}
+}
+
 func BenchmarkStoreDelete(b *testing.B) {
 	for n := 1; n <= 100000; n *= 10 {
 		b.Run(strconv.Itoa(n), func(b *testing.B) {
@@ -0,0 +1,150 @@
+package cache
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"time"
+)
+
+// streamWriter collects a value written in chunks and stores it when closed. The
+// chunks are buffered until they pass threshold, and from then on written to a file
+// in blobs, which becomes the value without being read back into memory.
+type streamWriter struct {
+	cache     *cache
+	key       []byte
+	ttl       time.Duration
+	blobs     *blobStore
+	threshold uint64
+	buf       bytes.Buffer
+	file      *os.File
+	err       error
+	closed    bool
+}
+
+// Write appends p to the pending value.
+func (w *streamWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	if w.file == nil && w.blobs != nil && uint64(w.buf.Len()+len(p)) > w.threshold {
+		if w.err = w.spill(); w.err != nil {
+			return 0, w.err
+		}
+	}
+
+	if w.file == nil {
+		return w.buf.Write(p)
+	}
+
+	n, err := w.file.Write(p)
+	if err != nil {
+		w.err = err
+	}
+
+	return n, err
+}
+
+// spill moves the buffered chunks to a new blob file that takes the following ones.
+func (w *streamWriter) spill() error {
+	file, err := w.blobs.Create()
+	if err != nil {
+		return err
+	}
+
+	w.file = file
+
+	if _, err := w.file.Write(w.buf.Bytes()); err != nil {
+		return err
+	}
+
+	w.buf = bytes.Buffer{}
+
+	return nil
+}
+
+// Close stores the accumulated bytes as the value of the key.
+func (w *streamWriter) Close() error {
+	if w.closed {
+		return os.ErrClosed
+	}
+
+	w.closed = true
+
+	if w.file == nil {
+		if w.err != nil {
+			return w.err
+		}
+
+		return w.cache.Set(w.key, w.buf.Bytes(), w.ttl)
+	}
+
+	err := w.err
+	if err1 := w.file.Close(); err == nil {
+		err = err1
+	}
+
+	if err == nil {
+		err = w.cache.Error()
+	}
+
+	if err != nil {
+		os.Remove(w.file.Name())
+
+		return err
+	}
+
+	ref, err := w.blobs.Ref(w.file.Name())
+	if err != nil {
+		os.Remove(w.file.Name())
+
+		return err
+	}
+
+	return w.cache.Store.SetBlob(w.blobs, w.key, ref, w.ttl)
+}
+
+// SetStream returns a writer for the value of key. The value is stored with the given
+// TTL once the writer is closed, and nothing is stored if it is never closed.
+//
+// Without WithBlobStore or WithOverflow the whole value is buffered in memory until
+// Close. With them, once the value grows past the blob threshold, or past 64 bytes
+// with WithOverflow, the chunks are written to a file in the blob directory as they
+// arrive and the file becomes the value as it is. Close must then be called to store
+// or remove that file.
+func (c CacheRaw) SetStream(key []byte, ttl time.Duration) (io.WriteCloser, error) {
+	if err := c.Error(); err != nil {
+		return nil, err
+	}
+
+	w := &streamWriter{cache: c.cache, key: bytes.Clone(key), ttl: ttl}
+
+	c.Store.Lock.RLock()
+	defer c.Store.Lock.RUnlock()
+
+	if c.Store.Blobs != nil {
+		w.blobs = c.Store.Blobs
+		w.threshold = c.Store.Blobs.Threshold
+
+		if c.Store.OverflowCost != 0 {
+			w.threshold = min(w.threshold, overflowMinSize)
+		}
+	}
+
+	return w, nil
+}
+
+// GetStream returns a reader over the value of key and its TTL.
+func (c CacheRaw) GetStream(key []byte) (io.ReadCloser, time.Duration, error) {
+	value, ttl, err := c.GetValue(key)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return io.NopCloser(bytes.NewReader(value)), ttl, nil
+}
@@ -0,0 +1,228 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCacheStream(t *testing.T) {
+	t.Parallel()
+
+	db, err := OpenRawMem()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	w, err := db.SetStream([]byte("Key"), time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var want []byte
+
+	for i := range 10 {
+		chunk := bytes.Repeat([]byte{byte('a' + i)}, 1024)
+		want = append(want, chunk...)
+
+		if _, err := w.Write(chunk); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if _, _, err := db.GetStream([]byte("Key")); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected error: %v, got %v", ErrKeyNotFound, err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := w.Write([]byte("More")); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("expected error: %v, got %v", os.ErrClosed, err)
+	}
+
+	r, ttl, err := db.GetStream([]byte("Key"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer r.Close()
+
+	if ttl <= 0 || ttl > time.Hour {
+		t.Fatalf("expected ttl within %v, got %v", time.Hour, ttl)
+	}
+
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !bytes.Equal(got, want) {
+		t.Fatalf("expected %d bytes, got %d bytes", len(want), len(got))
+	}
+}
+
+func TestCacheStreamBlob(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		option func(dir string) Option
+	}{
+		{"BlobStore", func(dir string) Option { return WithBlobStore(dir, 1024) }},
+		{"Overflow", func(dir string) Option { return WithOverflow(dir, 1<<20) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+
+			db, err := OpenRawMem(tt.option(dir))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			t.Cleanup(func() {
+				if err := db.Close(); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			})
+
+			w, err := db.SetStream([]byte("Key"), 0)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var want []byte
+
+			for i := range 10 {
+				chunk := bytes.Repeat([]byte{byte('a' + i)}, 1024)
+				want = append(want, chunk...)
+
+				if _, err := w.Write(chunk); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			if got := countBlobs(t, dir); got != 1 {
+				t.Fatalf("expected the chunks in 1 blob before Close, got %d", got)
+			}
+
+			if err := w.Close(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			v, _, _ := db.Store.lookup([]byte("Key"))
+			if v == nil || !v.Blob {
+				t.Fatalf("expected the value to stay in the blob")
+			}
+
+			if got := countBlobs(t, dir); got != 1 {
+				t.Fatalf("expected 1 blob, got %d", got)
+			}
+
+			got, _, err := db.GetValue([]byte("Key"))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !bytes.Equal(got, want) {
+				t.Fatalf("expected %d bytes, got %d bytes", len(want), len(got))
+			}
+
+			if err := db.Delete([]byte("Key")); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got := countBlobs(t, dir); got != 0 {
+				t.Fatalf("expected 0 blobs, got %d", got)
+			}
+		})
+	}
+
+	t.Run("Small", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+
+		db, err := OpenRawMem(WithBlobStore(dir, 1024))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		t.Cleanup(func() {
+			if err := db.Close(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+
+		w, err := db.SetStream([]byte("Key"), 0)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if _, err := w.Write([]byte("Value")); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if err := w.Close(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if got := countBlobs(t, dir); got != 0 {
+			t.Fatalf("expected 0 blobs, got %d", got)
+		}
+
+		got, _, err := db.GetValue([]byte("Key"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if !bytes.Equal(got, []byte("Value")) {
+			t.Fatalf("expected %q, got %q", "Value", got)
+		}
+	})
+
+	t.Run("Closed", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+
+		db, err := OpenRawMem(WithBlobStore(dir, 16))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		w, err := db.SetStream([]byte("Key"), 0)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if _, err := w.Write(bytes.Repeat([]byte("Value"), 100)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if err := db.Close(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if err := w.Close(); !errors.Is(err, ErrClosed) {
+			t.Fatalf("expected error: %v, got %v", ErrClosed, err)
+		}
+
+		if got := countBlobs(t, dir); got != 0 {
+			t.Fatalf("expected the blob to be removed, got %d", got)
+		}
+	})
+}
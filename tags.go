@@ -0,0 +1,80 @@
+package cache
+
+import (
+	"slices"
+	"time"
+)
+
+// tagIndex maps every tag to the nodes carrying it.
+type tagIndex map[string]map[*node]struct{}
+
+// setTags replaces the tags of a node and updates the tag index.
+func (s *store) setTags(v *node, tags []string) {
+	s.untag(v)
+
+	if len(tags) == 0 {
+		return
+	}
+
+	if s.Tags == nil {
+		s.Tags = tagIndex{}
+	}
+
+	v.Tags = slices.Clone(tags)
+
+	for _, tag := range v.Tags {
+		nodes := s.Tags[tag]
+		if nodes == nil {
+			nodes = map[*node]struct{}{}
+			s.Tags[tag] = nodes
+		}
+
+		nodes[v] = struct{}{}
+	}
+}
+
+// untag removes a node from the tag index.
+func (s *store) untag(v *node) {
+	for _, tag := range v.Tags {
+		delete(s.Tags[tag], v)
+
+		if len(s.Tags[tag]) == 0 {
+			delete(s.Tags, tag)
+		}
+	}
+
+	v.Tags = nil
+}
+
+// SetTagged adds a key-value pair to the store like Set and labels it with tags,
+// replacing any tags the key had.
+func (s *store) SetTagged(key, value []byte, ttl time.Duration, tags []string) error {
+	s.Lock.Lock()
+	defer s.Lock.Unlock()
+
+	if err := s.set(key, value, ttl); err != nil {
+		return err
+	}
+
+	v, _, _ := s.lookup(key)
+	s.setTags(v, tags)
+
+	s.maintain()
+
+	return nil
+}
+
+// InvalidateTag deletes every entry carrying tag and returns how many were deleted.
+func (s *store) InvalidateTag(tag string) int {
+	s.Lock.Lock()
+	defer s.Lock.Unlock()
+
+	nodes := s.Tags[tag]
+	n := len(nodes)
+
+	for v := range nodes {
+		deleteNode(s, v)
+	}
+
+	return n
+}
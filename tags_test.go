@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestStoreInvalidateTag(t *testing.T) {
+	t.Parallel()
+
+	store := setupTestStore(t)
+
+	entries := []struct {
+		key  string
+		tags []string
+	}{
+		{key: "1", tags: []string{"tenant-x"}},
+		{key: "2", tags: []string{"tenant-x"}},
+		{key: "3", tags: []string{"tenant-y"}},
+		{key: "4", tags: []string{"tenant-y"}},
+		{key: "5"},
+	}
+
+	for _, e := range entries {
+		if err := store.SetTagged([]byte(e.key), []byte("Value"), 0, e.tags); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if got := store.InvalidateTag("tenant-x"); got != 2 {
+		t.Fatalf("expected 2 entries invalidated, got %d", got)
+	}
+
+	for _, e := range entries {
+		_, _, ok := store.Get([]byte(e.key))
+		if want := len(e.tags) == 0 || e.tags[0] != "tenant-x"; ok != want {
+			t.Fatalf("key %s: expected present %v, got %v", e.key, want, ok)
+		}
+	}
+
+	if got := store.InvalidateTag("tenant-x"); got != 0 {
+		t.Fatalf("expected no entries invalidated, got %d", got)
+	}
+
+	// A plain Set removes the tags of the key.
+	if err := store.Set([]byte("3"), []byte("Value"), 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := store.InvalidateTag("tenant-y"); got != 1 {
+		t.Fatalf("expected 1 entry invalidated, got %d", got)
+	}
+
+	if store.Length != 2 {
+		t.Fatalf("expected 2 entries, got %d", store.Length)
+	}
+}
+
+func TestStoreSnapshotTags(t *testing.T) {
+	t.Parallel()
+
+	for _, format := range []SnapshotFormat{FormatBinary, FormatMsgpack} {
+		want := setupTestStore(t)
+		want.Format = format
+
+		if err := want.SetTagged([]byte("1"), []byte("Value"), 0, []string{"a", "b"}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if err := want.Set([]byte("2"), []byte("Value"), 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		var buf bytes.Buffer
+		if err := want.Snapshot(&buf); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		got := setupTestStore(t)
+		if err := got.LoadSnapshot(bytes.NewReader(buf.Bytes())); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if n := got.InvalidateTag("b"); n != 1 {
+			t.Fatalf("format %d: expected 1 entry invalidated, got %d", format, n)
+		}
+
+		if got.Length != 1 || len(got.Tags) != 0 {
+			t.Fatalf("format %d: expected 1 untagged entry, got %d entries and %d tags", format, got.Length, len(got.Tags))
+		}
+	}
+}
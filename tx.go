@@ -0,0 +1,184 @@
+package cache
+
+import (
+	"errors"
+	"slices"
+	"time"
+)
+
+// txOp is a buffered write of a transaction.
+type txOp struct {
+	Key    []byte
+	Value  []byte
+	TTL    time.Duration
+	Delete bool
+}
+
+// Tx buffers Set and Delete operations so that they are applied to the cache
+// together. Readers never observe part of a committed transaction.
+type Tx[K any, V any] struct {
+	ops         []txOp
+	encodeKey   func(K) ([]byte, error)
+	encodeValue func(V) ([]byte, error)
+}
+
+// Set buffers adding a key-value pair with a specified TTL.
+func (tx *Tx[K, V]) Set(key K, value V, ttl time.Duration) error {
+	keyData, err := tx.encodeKey(key)
+	if err != nil {
+		return err
+	}
+
+	valueData, err := tx.encodeValue(value)
+	if err != nil {
+		return err
+	}
+
+	tx.ops = append(tx.ops, txOp{Key: keyData, Value: valueData, TTL: ttl})
+
+	return nil
+}
+
+// Delete buffers removing a key. Deleting a missing key is not an error.
+func (tx *Tx[K, V]) Delete(key K) error {
+	keyData, err := tx.encodeKey(key)
+	if err != nil {
+		return err
+	}
+
+	tx.ops = append(tx.ops, txOp{Key: keyData, Delete: true})
+
+	return nil
+}
+
+// txUndo is the state of a key before a transaction changed it.
+type txUndo struct {
+	Key        []byte
+	Found      bool
+	Value      []byte
+	Expiration time.Time
+	Tags       []string
+	Version    uint64
+	Generation uint64
+}
+
+// Apply performs the buffered operations of a transaction under a single lock.
+// If an operation fails, the operations before it are undone and the error is returned.
+func (s *store) Apply(ops []txOp) error {
+	s.Lock.Lock()
+	defer s.Lock.Unlock()
+
+	undo := make([]txUndo, 0, len(ops))
+
+	for _, op := range ops {
+		v, _, _ := s.lookup(op.Key)
+
+		u := txUndo{Key: op.Key}
+		if v != nil {
+			value, err := s.value(v)
+			if err != nil {
+				return s.rollback(undo, err)
+			}
+
+			u = txUndo{
+				Key:        op.Key,
+				Found:      true,
+				Value:      value,
+				Expiration: v.Expiration,
+				Tags:       v.Tags,
+				Version:    v.Version,
+				Generation: v.Generation,
+			}
+		}
+
+		undo = append(undo, u)
+
+		if op.Delete {
+			if v != nil {
+				deleteNode(s, v)
+			}
+
+			continue
+		}
+
+		if err := s.set(op.Key, op.Value, op.TTL); err != nil {
+			return s.rollback(undo, err)
+		}
+	}
+
+	s.maintain()
+
+	return nil
+}
+
+// rollback restores the keys recorded in undo, latest change first, after a failed
+// operation and returns err together with any error restoring them. Restored entries
+// keep their value, expiration, tags, version and generation, but move to the front
+// of the eviction order. The caller must hold the store lock.
+func (s *store) rollback(undo []txUndo, err error) error {
+	errs := []error{err}
+
+	for _, u := range slices.Backward(undo) {
+		if v, _, _ := s.lookup(u.Key); v != nil {
+			deleteNode(s, v)
+		}
+
+		if !u.Found {
+			continue
+		}
+
+		if err := s.insert(u.Key, u.Value, 0); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		v, _, _ := s.lookup(u.Key)
+
+		s.setExpiration(v, u.Expiration)
+		s.setTags(v, u.Tags)
+		v.Version = u.Version
+		v.Generation = u.Generation
+	}
+
+	if len(errs) == 1 {
+		return err
+	}
+
+	return errors.Join(errs...)
+}
+
+// identity returns the bytes unchanged, used to encode raw keys and values.
+func identity(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+// Transaction runs fn and atomically applies the operations it buffered in tx.
+// If fn returns an error, or an operation fails when applied, for example with a TTL
+// outside the bounds, nothing is applied and the error is returned.
+func (c *cache) Transaction(fn func(tx *Tx[[]byte, []byte]) error) error {
+	tx := &Tx[[]byte, []byte]{encodeKey: identity, encodeValue: identity}
+
+	return c.commit(fn(tx), tx.ops)
+}
+
+// commit applies the buffered operations unless the transaction failed.
+func (c *cache) commit(err error, ops []txOp) error {
+	if err != nil {
+		return err
+	}
+
+	if err := c.Error(); err != nil {
+		return err
+	}
+
+	return c.Store.Apply(ops)
+}
+
+// Transaction runs fn and atomically applies the operations it buffered in tx.
+// If fn returns an error, or an operation fails when applied, for example with a TTL
+// outside the bounds, nothing is applied and the error is returned.
+func (c Cache[K, V]) Transaction(fn func(tx *Tx[K, V]) error) error {
+	tx := &Tx[K, V]{encodeKey: c.encodeKey, encodeValue: marshal[V]}
+
+	return c.commit(fn(tx), tx.ops)
+}
@@ -0,0 +1,153 @@
+package cache
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCacheTransaction(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Commit", func(t *testing.T) {
+		t.Parallel()
+
+		db := setupTestCache[string, string](t)
+
+		if err := db.Set("Old", "Value", 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		err := db.Transaction(func(tx *Tx[string, string]) error {
+			if err := tx.Set("1", "One", 0); err != nil {
+				return err
+			}
+
+			if err := tx.Set("2", "Two", time.Hour); err != nil {
+				return err
+			}
+
+			return tx.Delete("Old")
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		for k, want := range map[string]string{"1": "One", "2": "Two"} {
+			got, _, err := db.GetValue(k)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got != want {
+				t.Errorf("expected %v, got %v", want, got)
+			}
+		}
+
+		if _, _, err := db.GetValue("Old"); !errors.Is(err, ErrKeyNotFound) {
+			t.Fatalf("expected error: %v, got: %v", ErrKeyNotFound, err)
+		}
+	})
+
+	t.Run("Rollback", func(t *testing.T) {
+		t.Parallel()
+
+		db := setupTestCache[string, string](t)
+
+		if err := db.Set("1", "Initial", 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		want := errors.New("abort")
+
+		err := db.Transaction(func(tx *Tx[string, string]) error {
+			if err := tx.Set("1", "One", 0); err != nil {
+				return err
+			}
+
+			if err := tx.Set("2", "Two", 0); err != nil {
+				return err
+			}
+
+			return want
+		})
+		if !errors.Is(err, want) {
+			t.Fatalf("expected error: %v, got: %v", want, err)
+		}
+
+		got, _, err := db.GetValue("1")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if got != "Initial" {
+			t.Errorf("expected %v, got %v", "Initial", got)
+		}
+
+		if _, _, err := db.GetValue("2"); !errors.Is(err, ErrKeyNotFound) {
+			t.Fatalf("expected error: %v, got: %v", ErrKeyNotFound, err)
+		}
+	})
+
+	t.Run("Failed Op", func(t *testing.T) {
+		t.Parallel()
+
+		db, err := OpenMem[string, string](WithTTLBounds(time.Second, time.Hour))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		t.Cleanup(func() {
+			if err := db.Close(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+
+		if err := db.SetTagged("Old", "Initial", time.Minute, "Tag"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		_, version, _, err := db.GetWithVersion("Old")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		err = db.Transaction(func(tx *Tx[string, string]) error {
+			if err := tx.Set("a", "Value", time.Minute); err != nil {
+				return err
+			}
+
+			if err := tx.Set("Old", "Changed", time.Minute); err != nil {
+				return err
+			}
+
+			if err := tx.Delete("Old"); err != nil {
+				return err
+			}
+
+			return tx.Set("b", "Value", 48*time.Hour)
+		})
+		if !errors.Is(err, ErrTTLOutOfRange) {
+			t.Fatalf("expected error: %v, got: %v", ErrTTLOutOfRange, err)
+		}
+
+		for _, key := range []string{"a", "b"} {
+			if _, _, err := db.GetValue(key); !errors.Is(err, ErrKeyNotFound) {
+				t.Fatalf("expected %s to not be stored, got: %v", key, err)
+			}
+		}
+
+		got, restored, ttl, err := db.GetWithVersion("Old")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if got != "Initial" || restored != version || ttl <= 0 || ttl > time.Minute {
+			t.Fatalf("expected %q with version %d, got %q with version %d and TTL %v", "Initial", version, got, restored, ttl)
+		}
+
+		if n, err := db.InvalidateTag("Tag"); err != nil || n != 1 {
+			t.Fatalf("expected the tag to be restored, got %d, %v", n, err)
+		}
+	})
+}
@@ -11,7 +11,7 @@ func zero[T any]() T {
 	return ret
 }
 
-// hash computes the 64-bit FNV-1a hash of the provided data.
+// hash computes the 64-bit FNV-1 hash of the provided data.
 func hash(data []byte) uint64 {
 	hasher := fnv.New64()
 	if _, err := hasher.Write(data); err != nil {
@@ -0,0 +1,114 @@
+package cache
+
+import "time"
+
+// expiryWheel indexes expiring nodes by the second they expire in, so cleanup only
+// visits the slots whose time has passed instead of scanning every entry.
+type expiryWheel struct {
+	Slots map[int64]*node
+	Next  int64
+}
+
+// newExpiryWheel creates an empty expiry wheel.
+func newExpiryWheel() *expiryWheel {
+	return &expiryWheel{Slots: map[int64]*node{}}
+}
+
+// Add indexes a node by its expiration. Nodes without expiration are left out.
+func (w *expiryWheel) Add(n *node) {
+	if n.Expiration.IsZero() {
+		return
+	}
+
+	// A node is filed under the first whole second at which it has expired.
+	sec := n.Expiration.Unix()
+	if n.Expiration.Nanosecond() != 0 {
+		sec++
+	}
+
+	sec = max(sec, w.Next)
+
+	sentinel, ok := w.Slots[sec]
+	if !ok {
+		sentinel = &node{}
+		sentinel.WheelNext = sentinel
+		sentinel.WheelPrev = sentinel
+		w.Slots[sec] = sentinel
+	}
+
+	n.WheelPrev = sentinel
+	n.WheelNext = n.WheelPrev.WheelNext
+	n.WheelNext.WheelPrev = n
+	n.WheelPrev.WheelNext = n
+}
+
+// Remove drops a node from the index.
+func (w *expiryWheel) Remove(n *node) {
+	if n.WheelNext == nil {
+		return
+	}
+
+	n.WheelNext.WheelPrev = n.WheelPrev
+	n.WheelPrev.WheelNext = n.WheelNext
+	n.WheelNext = nil
+	n.WheelPrev = nil
+}
+
+// Expire calls fn for every node in the slots that have passed by now and drops those slots.
+func (w *expiryWheel) Expire(now time.Time, fn func(n *node)) {
+	end := now.Unix()
+
+	if end-w.Next > int64(len(w.Slots)) {
+		// After a long pause it is cheaper to look at the filled slots only.
+		for sec := range w.Slots {
+			if sec <= end {
+				w.expireSlot(sec, fn)
+			}
+		}
+	} else {
+		for sec := w.Next; sec <= end; sec++ {
+			w.expireSlot(sec, fn)
+		}
+	}
+
+	w.Next = max(w.Next, end+1)
+}
+
+// Peek returns a node of the earliest slot that has passed by now, or nil if there is
+// none, without dropping anything.
+func (w *expiryWheel) Peek(now time.Time) *node {
+	end := now.Unix()
+
+	var (
+		first *node
+		at    int64
+	)
+
+	for sec, sentinel := range w.Slots {
+		if sec <= end && sentinel.WheelNext != sentinel && (first == nil || sec < at) {
+			first, at = sentinel.WheelNext, sec
+		}
+	}
+
+	return first
+}
+
+// expireSlot calls fn for every node of a slot and drops the slot.
+func (w *expiryWheel) expireSlot(sec int64, fn func(n *node)) {
+	sentinel, ok := w.Slots[sec]
+	if !ok {
+		return
+	}
+
+	delete(w.Slots, sec)
+
+	for v := sentinel.WheelNext; v != sentinel; {
+		n := v.WheelNext
+
+		v.WheelNext = nil
+		v.WheelPrev = nil
+		fn(v)
+
+		v = n
+	}
+}
@@ -0,0 +1,171 @@
+package cache
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestExpiryWheel(t *testing.T) {
+	t.Parallel()
+
+	base := time.Unix(1000, 0)
+
+	t.Run("Expire", func(t *testing.T) {
+		t.Parallel()
+
+		w := newExpiryWheel()
+		w.Next = base.Unix()
+
+		nodes := []*node{
+			{Key: []byte("1"), Expiration: base.Add(500 * time.Millisecond)},
+			{Key: []byte("2"), Expiration: base.Add(2 * time.Second)},
+			{Key: []byte("3")},
+		}
+
+		for _, n := range nodes {
+			w.Add(n)
+		}
+
+		var got []string
+
+		collect := func(n *node) {
+			got = append(got, string(n.Key))
+		}
+
+		w.Expire(base.Add(900*time.Millisecond), collect)
+
+		if len(got) != 0 {
+			t.Fatalf("expected nothing to expire before the slot passed, got %v", got)
+		}
+
+		w.Expire(base.Add(time.Second), collect)
+
+		if len(got) != 1 || got[0] != "1" {
+			t.Fatalf("expected [1], got %v", got)
+		}
+
+		w.Expire(base.Add(time.Hour), collect)
+
+		if len(got) != 2 || got[1] != "2" {
+			t.Fatalf("expected [1 2], got %v", got)
+		}
+
+		if len(w.Slots) != 0 {
+			t.Fatalf("expected all slots to be dropped, got %d", len(w.Slots))
+		}
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		t.Parallel()
+
+		w := newExpiryWheel()
+		w.Next = base.Unix()
+
+		n := &node{Expiration: base.Add(time.Second)}
+		w.Add(n)
+		w.Remove(n)
+
+		w.Expire(base.Add(time.Hour), func(n *node) {
+			t.Fatalf("expected removed node to not expire")
+		})
+	})
+
+	t.Run("Past", func(t *testing.T) {
+		t.Parallel()
+
+		w := newExpiryWheel()
+		w.Expire(base, func(*node) {})
+
+		// Nodes expiring before the wheel position are filed in the next slot.
+		n := &node{Expiration: base.Add(-time.Hour)}
+		w.Add(n)
+
+		expired := false
+
+		w.Expire(base.Add(time.Second), func(*node) {
+			expired = true
+		})
+
+		if !expired {
+			t.Fatalf("expected node to expire")
+		}
+	})
+
+	t.Run("Peek", func(t *testing.T) {
+		t.Parallel()
+
+		w := newExpiryWheel()
+		w.Next = base.Unix()
+
+		early := &node{Key: []byte("1"), Expiration: base.Add(500 * time.Millisecond)}
+		late := &node{Key: []byte("2"), Expiration: base.Add(2 * time.Second)}
+		w.Add(late)
+		w.Add(early)
+
+		if n := w.Peek(base.Add(900 * time.Millisecond)); n != nil {
+			t.Fatalf("expected nothing before the slot passed, got %q", n.Key)
+		}
+
+		if n := w.Peek(base.Add(time.Hour)); n != early {
+			t.Fatalf("expected the earliest node, got %v", n)
+		}
+
+		// Peeking drops nothing, and a removed node is skipped.
+		w.Remove(early)
+
+		if n := w.Peek(base.Add(time.Hour)); n != late {
+			t.Fatalf("expected the remaining node, got %v", n)
+		}
+	})
+}
+
+func TestStoreExpirationWheel(t *testing.T) {
+	t.Parallel()
+
+	store := setupTestStore(t)
+	store.enableWheel()
+
+	store.Set([]byte("Expired"), []byte("Value"), -time.Second)
+	store.Set([]byte("Updated"), []byte("Value"), -time.Second)
+	store.Set([]byte("Updated"), []byte("Value"), time.Hour)
+	store.Set([]byte("Immortal"), []byte("Value"), 0)
+	store.Set([]byte("Deleted"), []byte("Value"), -time.Second)
+	store.Delete([]byte("Deleted"))
+
+	store.Cleanup()
+
+	if store.Length != 2 {
+		t.Fatalf("expected 2 entries, got %d", store.Length)
+	}
+
+	for _, k := range []string{"Updated", "Immortal"} {
+		if _, _, ok := store.Get([]byte(k)); !ok {
+			t.Fatalf("expected %s to exist", k)
+		}
+	}
+}
+
+func BenchmarkStoreCleanup(b *testing.B) {
+	const entries = 100_000
+
+	for name, wheel := range map[string]bool{"Scan": false, "Wheel": true} {
+		b.Run(name, func(b *testing.B) {
+			store := setupTestStore(b)
+			if wheel {
+				store.enableWheel()
+			}
+
+			// Staggered TTLs, so each cleanup only finds a small share of the entries due.
+			for i := range entries {
+				store.Set([]byte(strconv.Itoa(i)), []byte("Value"), time.Duration(i%3600+1)*time.Second)
+			}
+
+			b.ReportAllocs()
+
+			for b.Loop() {
+				store.Cleanup()
+			}
+		})
+	}
+}